@@ -0,0 +1,247 @@
+package acl
+
+// 监听端口的访问控制：CIDR允许/拒绝列表、并发会话限制以及失败次数过多时的临时封禁
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"rtmpproxy/utils"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	Allow            []string      // 允许的CIDR或IP，为空时允许所有
+	Deny             []string      // 拒绝的CIDR或IP，优先于Allow
+	MaxSessions      int           // 全局最大并发会话数，0 为不限制
+	MaxSessionsPerIP int           // 单个IP最大并发会话数，0 为不限制
+	BanAfter         int           // 连续失败次数达到后临时封禁，0 为不封禁
+	BanTime          time.Duration // 封禁时长，同时也是失败次数的统计窗口
+}
+
+// 过期的失败记录和封禁的清理间隔，以及最多记录的失败地址数，避免大量不同地址的失败占用内存
+const (
+	pruneInterval = time.Minute
+	maxFailures   = 65536
+)
+
+type failure struct {
+	count int
+	last  time.Time
+}
+
+// Guard 在接受连接时检查客户端地址并统计并发会话
+type Guard struct {
+	cfg      Config
+	allow    []*net.IPNet
+	deny     []*net.IPNet
+	mu       sync.Mutex
+	total    int
+	perIP    map[string]int
+	failures map[string]*failure
+	bans     map[string]time.Time
+	pruned   time.Time
+	now      func() time.Time
+}
+
+func NewGuard(cfg Config) (*Guard, error) {
+	allow, err := ParseCIDRs(cfg.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := ParseCIDRs(cfg.Deny)
+	if err != nil {
+		return nil, err
+	}
+	return &Guard{
+		cfg:      cfg,
+		allow:    allow,
+		deny:     deny,
+		perIP:    make(map[string]int),
+		failures: make(map[string]*failure),
+		bans:     make(map[string]time.Time),
+		now:      time.Now,
+	}, nil
+}
+
+// Acquire 检查地址是否允许连接并占用一个会话名额，成功时返回的release必须在会话结束时调用
+func (g *Guard) Acquire(addr net.Addr) (func(), error) {
+	ip := hostIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("%w: %s", utils.AddressDenied, addr)
+	}
+	if !g.allowed(ip) {
+		return nil, fmt.Errorf("%w: %s", utils.AddressDenied, ip)
+	}
+	key := ip.String()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	g.prune(now)
+	if until, ok := g.bans[key]; ok {
+		if now.Before(until) {
+			return nil, fmt.Errorf("%w: %s until %s", utils.AddressBanned, key, until.Format(time.DateTime))
+		}
+		delete(g.bans, key)
+	}
+	if g.cfg.MaxSessions > 0 && g.total >= g.cfg.MaxSessions {
+		return nil, fmt.Errorf("%w: %d sessions", utils.TooManySessions, g.total)
+	}
+	if g.cfg.MaxSessionsPerIP > 0 && g.perIP[key] >= g.cfg.MaxSessionsPerIP {
+		return nil, fmt.Errorf("%w: %d sessions from %s", utils.TooManySessions, g.perIP[key], key)
+	}
+	g.total++
+	g.perIP[key]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			g.total--
+			if g.perIP[key]--; g.perIP[key] <= 0 {
+				delete(g.perIP, key)
+			}
+		})
+	}, nil
+}
+
+// Fail 记录一次鉴权或握手失败，统计窗口内达到BanAfter次时封禁该地址
+func (g *Guard) Fail(addr net.Addr) {
+	ip := hostIP(addr)
+	if ip == nil || g.cfg.BanAfter <= 0 {
+		return
+	}
+	key := ip.String()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	g.prune(now)
+	f := g.failures[key]
+	if f == nil || now.Sub(f.last) > g.cfg.BanTime {
+		if f == nil && len(g.failures) >= maxFailures {
+			g.evictOldest()
+		}
+		f = &failure{}
+		g.failures[key] = f
+	}
+	f.count++
+	f.last = now
+	if f.count >= g.cfg.BanAfter {
+		delete(g.failures, key)
+		g.bans[key] = now.Add(g.cfg.BanTime)
+		slog.Warn("Banned client address", "addr", key, "duration", g.cfg.BanTime.String(), "failures", f.count)
+	}
+}
+
+// Succeed 推流成功后清除该地址的失败记录
+func (g *Guard) Succeed(addr net.Addr) {
+	ip := hostIP(addr)
+	if ip == nil {
+		return
+	}
+	g.mu.Lock()
+	delete(g.failures, ip.String())
+	g.mu.Unlock()
+}
+
+// prune 每隔 pruneInterval 删除统计窗口外的失败记录和过期的封禁，调用时持有锁
+func (g *Guard) prune(now time.Time) {
+	if now.Sub(g.pruned) < pruneInterval {
+		return
+	}
+	g.pruned = now
+	for key, f := range g.failures {
+		if now.Sub(f.last) > g.cfg.BanTime {
+			delete(g.failures, key)
+		}
+	}
+	for key, until := range g.bans {
+		if !now.Before(until) {
+			delete(g.bans, key)
+		}
+	}
+}
+
+// evictOldest 失败记录达到 maxFailures 时删除最早的一条，调用时持有锁
+func (g *Guard) evictOldest() {
+	var oldest string
+	var last time.Time
+	for key, f := range g.failures {
+		if oldest == "" || f.last.Before(last) {
+			oldest, last = key, f.last
+		}
+	}
+	delete(g.failures, oldest)
+}
+
+func (g *Guard) allowed(ip net.IP) bool {
+	for _, n := range g.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(g.allow) == 0 {
+		return true
+	}
+	for _, n := range g.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCIDRs 解析CIDR列表，单个IP视为/32或/128
+func ParseCIDRs(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR: %s", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Contains addr的IP是否在nets中
+func Contains(nets []*net.IPNet, addr net.Addr) bool {
+	ip := hostIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func hostIP(addr net.Addr) net.IP {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,227 @@
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"rtmpproxy/utils"
+	"testing"
+	"time"
+)
+
+func addr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000}
+}
+
+// fakeClock 可以手动推进的时钟
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newGuard(t *testing.T, cfg Config) (*Guard, *fakeClock) {
+	t.Helper()
+	g, err := NewGuard(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	g.now = clock.now
+	return g, clock
+}
+
+func TestAllowDeny(t *testing.T) {
+	g, _ := newGuard(t, Config{
+		Allow: []string{"10.0.0.0/8", "192.168.1.10", "2001:db8::/32"},
+		Deny:  []string{"10.1.0.0/16", "2001:db8::1"},
+	})
+	tests := []struct {
+		ip   string
+		want error
+	}{
+		{"10.0.0.1", nil},
+		{"10.1.2.3", utils.AddressDenied}, // 拒绝优先于允许
+		{"192.168.1.10", nil},
+		{"192.168.1.11", utils.AddressDenied},
+		{"8.8.8.8", utils.AddressDenied},
+		{"2001:db8::2", nil},
+		{"2001:db8::1", utils.AddressDenied},
+		{"::ffff:10.0.0.1", nil},
+	}
+	for _, tt := range tests {
+		release, err := g.Acquire(addr(tt.ip))
+		if !errors.Is(err, tt.want) {
+			t.Errorf("Acquire(%s) = %v, want %v", tt.ip, err, tt.want)
+		}
+		if release != nil {
+			release()
+		}
+	}
+}
+
+func TestDenyOnly(t *testing.T) {
+	g, _ := newGuard(t, Config{Deny: []string{"127.0.0.1"}})
+	if _, err := g.Acquire(addr("127.0.0.1")); !errors.Is(err, utils.AddressDenied) {
+		t.Errorf("denied address: err = %v", err)
+	}
+	if _, err := g.Acquire(addr("127.0.0.2")); err != nil {
+		t.Errorf("other address: err = %v", err)
+	}
+}
+
+func TestInvalidCIDR(t *testing.T) {
+	for _, list := range [][]string{{"10.0.0.0/33"}, {"not-an-ip"}, {"10.0.0"}} {
+		if _, err := NewGuard(Config{Allow: list}); err == nil {
+			t.Errorf("NewGuard(%v) succeeded", list)
+		}
+	}
+}
+
+func TestSessionLimits(t *testing.T) {
+	g, _ := newGuard(t, Config{MaxSessions: 3, MaxSessionsPerIP: 2})
+	r1, err := g.Acquire(addr("10.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Acquire(addr("10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Acquire(addr("10.0.0.1")); !errors.Is(err, utils.TooManySessions) {
+		t.Fatalf("third session from same IP: err = %v", err)
+	}
+	if _, err = g.Acquire(addr("10.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Acquire(addr("10.0.0.3")); !errors.Is(err, utils.TooManySessions) {
+		t.Fatalf("session over global limit: err = %v", err)
+	}
+
+	// 重复调用release只释放一次
+	r1()
+	r1()
+	if _, err = g.Acquire(addr("10.0.0.1")); err != nil {
+		t.Fatalf("after release: err = %v", err)
+	}
+	if _, err = g.Acquire(addr("10.0.0.3")); !errors.Is(err, utils.TooManySessions) {
+		t.Fatalf("release called twice freed two sessions: err = %v", err)
+	}
+}
+
+func TestBan(t *testing.T) {
+	g, clock := newGuard(t, Config{BanAfter: 3, BanTime: 10 * time.Minute})
+	a := addr("10.0.0.1")
+	g.Fail(a)
+	g.Fail(a)
+	if _, err := g.Acquire(a); err != nil {
+		t.Fatalf("before ban: err = %v", err)
+	}
+	g.Fail(a)
+	if _, err := g.Acquire(a); !errors.Is(err, utils.AddressBanned) {
+		t.Fatalf("after ban: err = %v", err)
+	}
+	if _, err := g.Acquire(addr("10.0.0.2")); err != nil {
+		t.Fatalf("other address: err = %v", err)
+	}
+
+	clock.advance(10*time.Minute - time.Second)
+	if _, err := g.Acquire(a); !errors.Is(err, utils.AddressBanned) {
+		t.Fatalf("before expiry: err = %v", err)
+	}
+	clock.advance(time.Second)
+	if _, err := g.Acquire(a); err != nil {
+		t.Fatalf("after expiry: err = %v", err)
+	}
+}
+
+func TestFailureWindow(t *testing.T) {
+	g, clock := newGuard(t, Config{BanAfter: 2, BanTime: time.Minute})
+	a := addr("10.0.0.1")
+
+	// 窗口外的失败重新计数
+	g.Fail(a)
+	clock.advance(2 * time.Minute)
+	g.Fail(a)
+	if _, err := g.Acquire(a); err != nil {
+		t.Fatalf("failures outside window: err = %v", err)
+	}
+
+	// 成功后清除失败记录
+	g.Succeed(a)
+	g.Fail(a)
+	if _, err := g.Acquire(a); err != nil {
+		t.Fatalf("after succeed: err = %v", err)
+	}
+	g.Fail(a)
+	if _, err := g.Acquire(a); !errors.Is(err, utils.AddressBanned) {
+		t.Fatalf("two failures in window: err = %v", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	g, clock := newGuard(t, Config{BanAfter: 2, BanTime: time.Minute})
+	for i := 0; i < 100; i++ {
+		a := addr(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+		g.Fail(a)
+		if i%2 == 0 {
+			g.Fail(a)
+		}
+	}
+	if len(g.failures) != 50 || len(g.bans) != 50 {
+		t.Fatalf("failures = %d, bans = %d, want 50, 50", len(g.failures), len(g.bans))
+	}
+
+	// 其它地址的连接触发清理，不需要同一地址重连
+	clock.advance(pruneInterval + time.Minute)
+	if _, err := g.Acquire(addr("192.168.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.failures) != 0 || len(g.bans) != 0 {
+		t.Errorf("after prune: failures = %d, bans = %d, want 0, 0", len(g.failures), len(g.bans))
+	}
+}
+
+func TestMaxFailures(t *testing.T) {
+	g, clock := newGuard(t, Config{BanAfter: 2, BanTime: time.Hour})
+	first := addr("10.255.255.255")
+	g.Fail(first)
+	for i := 1; i < maxFailures+10; i++ {
+		clock.advance(time.Millisecond)
+		g.Fail(addr(fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)))
+	}
+	if len(g.failures) > maxFailures {
+		t.Fatalf("failures = %d, want at most %d", len(g.failures), maxFailures)
+	}
+	// 最早的记录被删除，再次失败不会封禁
+	g.Fail(first)
+	if _, err := g.Acquire(first); err != nil {
+		t.Errorf("evicted address: err = %v", err)
+	}
+}
+
+func TestContains(t *testing.T) {
+	nets, err := ParseCIDRs([]string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		addr net.Addr
+		want bool
+	}{
+		{addr("10.1.2.3"), true},
+		{addr("11.0.0.1"), false},
+		{addr("2001:db8::1"), true},
+		{addr("2001:db8::2"), false},
+		{&net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, false},
+	}
+	for _, tt := range tests {
+		if got := Contains(nets, tt.addr); got != tt.want {
+			t.Errorf("Contains(%v) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+	if Contains(nil, addr("10.0.0.1")) {
+		t.Errorf("Contains(nil) = true")
+	}
+}
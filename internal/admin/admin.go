@@ -0,0 +1,129 @@
+package admin
+
+// 管理接口，查看和操作正在进行的会话
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"rtmpproxy/internal/plugins"
+	"rtmpproxy/internal/server"
+	"rtmpproxy/utils"
+	"strings"
+)
+
+type Handler struct {
+	srv     *server.Server
+	token   string
+	plugins []plugins.Named
+	mux     *http.ServeMux
+}
+
+// New 创建管理接口，请求需要携带 Authorization: Bearer <token>
+func New(srv *server.Server, token string, loaded []plugins.Named) *Handler {
+	h := &Handler{
+		srv:     srv,
+		token:   token,
+		plugins: loaded,
+		mux:     http.NewServeMux(),
+	}
+	h.mux.HandleFunc("GET /api/sessions", h.listSessions)
+	h.mux.HandleFunc("DELETE /api/sessions/{id}", h.kickSession)
+	h.mux.HandleFunc("POST /api/sessions/{id}/upstream", h.switchUpstream)
+	h.mux.HandleFunc("POST /api/sessions/{id}/output", h.controlOutput)
+	h.mux.HandleFunc("GET /api/plugins", h.listPlugins)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) listSessions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.srv.Sessions())
+}
+
+func (h *Handler) kickSession(w http.ResponseWriter, r *http.Request) {
+	err := h.srv.Kick(r.PathValue("id"))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type upstreamRequest struct {
+	Remote string `json:"remote"` // 新的远程地址，例如 rtmp://host/app/key
+}
+
+func (h *Handler) switchUpstream(w http.ResponseWriter, r *http.Request) {
+	var req upstreamRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Remote == "" {
+		writeError(w, http.StatusBadRequest, errors.New("remote is required"))
+		return
+	}
+	err = h.srv.SwitchUpstream(r.PathValue("id"), req.Remote)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type outputRequest struct {
+	Action string `json:"action"` // dump/slate/live
+}
+
+func (h *Handler) controlOutput(w http.ResponseWriter, r *http.Request) {
+	var req outputRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Action == "" {
+		writeError(w, http.StatusBadRequest, errors.New("action is required"))
+		return
+	}
+	err = h.srv.ControlOutput(r.PathValue("id"), req.Action)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) listPlugins(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, plugins.Statuses(h.plugins))
+}
+
+// statusOf 错误对应的HTTP状态码
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, utils.SessionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, utils.InvalidOutputAction):
+		return http.StatusBadRequest
+	case errors.Is(err, utils.SessionNotPublishing), errors.Is(err, utils.SwitchInProgress), errors.Is(err, utils.DelayNotEnabled),
+		errors.Is(err, utils.SlateNotConfigured), errors.Is(err, utils.NoActiveClient):
+		return http.StatusConflict
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Failed to write admin response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,349 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	amf "github.com/zhangpeihao/goamf"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"rtmpproxy/internal"
+	"rtmpproxy/internal/plugins"
+	"rtmpproxy/internal/rtmp"
+	"rtmpproxy/internal/server"
+	"rtmpproxy/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+const token = "secret"
+
+func init() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+// remote 接受推流的远程服务器，推流结束或连接断开后发送到ended
+type remote struct {
+	addr      string
+	published chan string
+	ended     chan struct{}
+}
+
+func startRemote(t *testing.T) *remote {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	r := &remote{addr: listener.Addr().String(), published: make(chan string, 4), ended: make(chan struct{}, 4)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *remote) serve(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	c := rtmp.NewConn(conn)
+	if c.ServerHandshake() != nil {
+		return
+	}
+	req, err := c.ReadPublish(nil)
+	if err != nil || c.AcceptPublish(req) != nil {
+		return
+	}
+	r.published <- req.StreamName
+	for {
+		if _, err = c.ReadMedia(); err != nil {
+			r.ended <- struct{}{}
+			return
+		}
+	}
+}
+
+func (r *remote) waitPublished(t *testing.T) string {
+	t.Helper()
+	select {
+	case name := <-r.published:
+		return name
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for publish")
+		return ""
+	}
+}
+
+// start 启动代理和管理接口，返回代理监听地址和管理接口地址
+func start(t *testing.T, remoteAddr string) (string, string) {
+	t.Helper()
+	listen, proxyAddr := "127.0.0.1:0", ""
+	remote := "rtmp://" + remoteAddr + "/live/first"
+	srv, err := server.New(&internal.Config{ListenAddr: &listen, RemoteAddr: &remote, ProxyAddr: &proxyAddr}, &plugins.DefaultInterceptor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = srv.Serve(listener)
+	}()
+	api := httptest.NewServer(New(srv, token, nil))
+	t.Cleanup(func() {
+		api.Close()
+		_ = srv.Shutdown(t.Context(), false)
+	})
+	return listener.Addr().String(), api.URL
+}
+
+// publish 连接代理并开始推流
+func publish(t *testing.T, addr string) {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	conn := rtmp.NewConn(c)
+	if err = conn.ClientHandshake(); err != nil {
+		t.Fatal(err)
+	}
+	err = conn.Connect(amf.Object{"app": "live", "tcUrl": "rtmp://" + addr + "/live", "type": "nonprivate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Publish("key", "live"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// call 发送请求，返回状态码和JSON响应中的error
+func call(t *testing.T, method string, url string, auth string, body string, v interface{}) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if err = json.Unmarshal(data, &e); err != nil {
+			t.Fatalf("%s %s: invalid error response %q", method, url, data)
+		}
+		return resp.StatusCode, e.Error
+	}
+	if v != nil {
+		if err = json.Unmarshal(data, v); err != nil {
+			t.Fatalf("%s %s: invalid response %q", method, url, data)
+		}
+	}
+	return resp.StatusCode, ""
+}
+
+// waitSession 等到有一个正在推流的会话
+func waitSession(t *testing.T, api string) server.SessionInfo {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var list []server.SessionInfo
+		call(t, http.MethodGet, api+"/api/sessions", "Bearer "+token, "", &list)
+		for _, info := range list {
+			if info.Publishing {
+				return info
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for publishing session")
+	return server.SessionInfo{}
+}
+
+func TestUnauthorized(t *testing.T) {
+	_, api := start(t, "127.0.0.1:1")
+	for _, auth := range []string{"", token, "Bearer wrong", "Basic " + token} {
+		if status, _ := call(t, http.MethodGet, api+"/api/sessions", auth, "", nil); status != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status = %d, want 401", auth, status)
+		}
+	}
+	var list []server.SessionInfo
+	if status, _ := call(t, http.MethodGet, api+"/api/sessions", "Bearer "+token, "", &list); status != http.StatusOK || len(list) != 0 {
+		t.Errorf("sessions = %d %v", status, list)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	first := startRemote(t)
+	addr, api := start(t, first.addr)
+	publish(t, addr)
+	first.waitPublished(t)
+
+	info := waitSession(t, api)
+	if info.App != "live" || info.Stream != "key" || !strings.Contains(info.RemoteAddr, first.addr) {
+		t.Errorf("session = %+v", info)
+	}
+
+	// 切换到新的远程服务器
+	second := startRemote(t)
+	status, msg := call(t, http.MethodPost, api+"/api/sessions/"+info.ID+"/upstream", "Bearer "+token, `{"remote":"rtmp://`+second.addr+`/live/second"}`, nil)
+	if status != http.StatusNoContent {
+		t.Fatalf("switch: %d %s", status, msg)
+	}
+	if name := second.waitPublished(t); name != "second" {
+		t.Errorf("switched stream = %s, want second", name)
+	}
+	if info = waitSession(t, api); !strings.Contains(info.RemoteAddr, second.addr) {
+		t.Errorf("remote after switch = %s", info.RemoteAddr)
+	}
+
+	// 踢掉会话后远程服务器收到结束推流
+	if status, msg = call(t, http.MethodDelete, api+"/api/sessions/"+info.ID, "Bearer "+token, "", nil); status != http.StatusNoContent {
+		t.Fatalf("kick: %d %s", status, msg)
+	}
+	select {
+	case <-second.ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("remote publish not ended after kick")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	first := startRemote(t)
+	addr, api := start(t, first.addr)
+
+	auth := "Bearer " + token
+	if status, _ := call(t, http.MethodDelete, api+"/api/sessions/missing", auth, "", nil); status != http.StatusNotFound {
+		t.Errorf("kick missing session: status = %d, want 404", status)
+	}
+	if status, _ := call(t, http.MethodPost, api+"/api/sessions/missing/upstream", auth, `{"remote":"rtmp://`+first.addr+`/live/x"}`, nil); status != http.StatusNotFound {
+		t.Errorf("switch missing session: status = %d, want 404", status)
+	}
+
+	publish(t, addr)
+	first.waitPublished(t)
+	info := waitSession(t, api)
+	url := api + "/api/sessions/" + info.ID + "/upstream"
+	for _, body := range []string{"", "{}", `{"remote":""}`} {
+		if status, _ := call(t, http.MethodPost, url, auth, body, nil); status != http.StatusBadRequest {
+			t.Errorf("switch with %q: status = %d, want 400", body, status)
+		}
+	}
+
+	// 远程服务器接受连接但不握手，切换会一直等待，同一会话的其它切换返回409
+	hang, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := hang.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	switched := make(chan int, 1)
+	go func() {
+		req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(`{"remote":"rtmp://`+hang.Addr().String()+`/live/hang"}`))
+		req.Header.Set("Authorization", auth)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			switched <- 0
+			return
+		}
+		_ = resp.Body.Close()
+		switched <- resp.StatusCode
+	}()
+	var hung net.Conn
+	select {
+	case hung = <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for switch to dial")
+	}
+	status, msg := call(t, http.MethodPost, url, auth, `{"remote":"rtmp://`+first.addr+`/live/other"}`, nil)
+	if status != http.StatusConflict || msg == "" {
+		t.Errorf("concurrent switch: %d %q, want 409", status, msg)
+	}
+
+	// 新的远程服务器断开，切换失败返回502
+	_ = hung.Close()
+	_ = hang.Close()
+	if status = <-switched; status != http.StatusBadGateway {
+		t.Errorf("failed switch: status = %d, want 502", status)
+	}
+}
+
+func TestControlOutput(t *testing.T) {
+	first := startRemote(t)
+	addr, api := start(t, first.addr)
+	auth := "Bearer " + token
+	if status, _ := call(t, http.MethodPost, api+"/api/sessions/missing/output", auth, `{"action":"dump"}`, nil); status != http.StatusNotFound {
+		t.Errorf("missing session: status = %d, want 404", status)
+	}
+
+	publish(t, addr)
+	first.waitPublished(t)
+	url := api + "/api/sessions/" + waitSession(t, api).ID + "/output"
+	for _, body := range []string{"", `{"action":""}`, `{"action":"pause"}`} {
+		if status, _ := call(t, http.MethodPost, url, auth, body, nil); status != http.StatusBadRequest {
+			t.Errorf("output with %q: status = %d, want 400", body, status)
+		}
+	}
+	// 没有配置 -delay
+	status, msg := call(t, http.MethodPost, url, auth, `{"action":"dump"}`, nil)
+	if status != http.StatusConflict || msg != utils.DelayNotEnabled.Error() {
+		t.Errorf("dump without delay: %d %q, want 409", status, msg)
+	}
+}
+
+func TestStatusOf(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{utils.SessionNotFound, http.StatusNotFound},
+		{fmt.Errorf("lookup: %w", utils.SessionNotFound), http.StatusNotFound},
+		{utils.InvalidOutputAction, http.StatusBadRequest},
+		{utils.SessionNotPublishing, http.StatusConflict},
+		{utils.SwitchInProgress, http.StatusConflict},
+		{utils.DelayNotEnabled, http.StatusConflict},
+		{utils.SlateNotConfigured, http.StatusConflict},
+		{utils.NoActiveClient, http.StatusConflict},
+		{errors.New("dial tcp: connection refused"), http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		if status := statusOf(tt.err); status != tt.status {
+			t.Errorf("statusOf(%v) = %d, want %d", tt.err, status, tt.status)
+		}
+	}
+}
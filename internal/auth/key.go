@@ -0,0 +1,93 @@
+package auth
+
+// 本地推流密钥校验，支持固定密钥列表和带有效期的HMAC签名密钥
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/url"
+	"rtmpproxy/utils"
+	"strconv"
+	"time"
+)
+
+// KeyVerifier 校验客户端publish的streamName
+type KeyVerifier struct {
+	keys   [][]byte
+	secret []byte
+}
+
+// NewKeyVerifier keys 为允许的固定密钥，secret 为签名密钥，均为空时不校验
+func NewKeyVerifier(keys []string, secret string) *KeyVerifier {
+	v := &KeyVerifier{}
+	for _, key := range keys {
+		if key != "" {
+			v.keys = append(v.keys, []byte(key))
+		}
+	}
+	if secret != "" {
+		v.secret = []byte(secret)
+	}
+	return v
+}
+
+// Enabled 是否配置了密钥校验
+func (v *KeyVerifier) Enabled() bool {
+	return len(v.keys) > 0 || v.secret != nil
+}
+
+// VerifyConnect connect阶段只能检查tcUrl中的有效期，过期时尽早拒绝
+func (v *KeyVerifier) VerifyConnect(args url.Values) error {
+	if v.secret == nil || args.Get("exp") == "" {
+		return nil
+	}
+	return checkExpire(args.Get("exp"))
+}
+
+// Verify 校验streamName，固定密钥或 name?exp=<unix时间>&sig=<hex(HMAC-SHA256(secret, "name:exp"))> 任一通过即可
+func (v *KeyVerifier) Verify(name string, args url.Values) error {
+	if !v.Enabled() {
+		return nil
+	}
+	if v.matchKey(name) {
+		return nil
+	}
+	exp, sig := args.Get("exp"), args.Get("sig")
+	if v.secret == nil || exp == "" || sig == "" {
+		return utils.InvalidStreamKey
+	}
+	expected, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(expected, Sign(v.secret, name, exp)) {
+		return utils.InvalidStreamKey
+	}
+	return checkExpire(exp)
+}
+
+// matchKey name是否为固定密钥之一，与所有密钥做常量时间比较，耗时不取决于匹配的位置和相同的前缀
+func (v *KeyVerifier) matchKey(name string) bool {
+	match := 0
+	for _, key := range v.keys {
+		match |= subtle.ConstantTimeCompare(key, []byte(name))
+	}
+	return match == 1
+}
+
+// Sign 计算签名密钥的sig
+func Sign(secret []byte, name string, exp string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(name + ":" + exp))
+	return mac.Sum(nil)
+}
+
+func checkExpire(exp string) error {
+	ts, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return utils.InvalidStreamKey
+	}
+	if time.Now().Unix() > ts {
+		return utils.StreamKeyExpired
+	}
+	return nil
+}
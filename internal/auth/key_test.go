@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"rtmpproxy/utils"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func signedArgs(secret string, name string, exp string) url.Values {
+	return url.Values{"exp": {exp}, "sig": {hex.EncodeToString(Sign([]byte(secret), name, exp))}}
+}
+
+func TestKeyVerifier(t *testing.T) {
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	past := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	v := NewKeyVerifier([]string{"static", "", "other"}, "secret")
+
+	tests := []struct {
+		name string
+		key  string
+		args url.Values
+		want error
+	}{
+		{"static key", "static", nil, nil},
+		{"second static key", "other", nil, nil},
+		{"static key prefix", "stat", nil, utils.InvalidStreamKey},
+		{"static key suffix", "static1", nil, utils.InvalidStreamKey},
+		{"empty key", "", nil, utils.InvalidStreamKey},
+		{"unknown key", "unknown", nil, utils.InvalidStreamKey},
+		{"signed key", "stream", signedArgs("secret", "stream", future), nil},
+		{"expired key", "stream", signedArgs("secret", "stream", past), utils.StreamKeyExpired},
+		{"wrong secret", "stream", signedArgs("other", "stream", future), utils.InvalidStreamKey},
+		{"signed for other name", "stream", signedArgs("secret", "other", future), utils.InvalidStreamKey},
+		{"tampered exp", "stream", url.Values{"exp": {future + "0"}, "sig": signedArgs("secret", "stream", future)["sig"]}, utils.InvalidStreamKey},
+		{"malformed exp", "stream", signedArgs("secret", "stream", "soon"), utils.InvalidStreamKey},
+		{"malformed sig", "stream", url.Values{"exp": {future}, "sig": {"zz"}}, utils.InvalidStreamKey},
+		{"missing sig", "stream", url.Values{"exp": {future}}, utils.InvalidStreamKey},
+		{"missing exp", "stream", url.Values{"sig": signedArgs("secret", "stream", future)["sig"]}, utils.InvalidStreamKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := v.Verify(tt.key, tt.args); !errors.Is(err, tt.want) {
+				t.Errorf("Verify(%q) = %v, want %v", tt.key, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyVerifierDisabled(t *testing.T) {
+	v := NewKeyVerifier(nil, "")
+	if v.Enabled() {
+		t.Fatal("Enabled() = true without keys and secret")
+	}
+	if err := v.Verify("anything", nil); err != nil {
+		t.Errorf("Verify = %v, want nil", err)
+	}
+}
+
+func TestKeyVerifierStaticOnly(t *testing.T) {
+	v := NewKeyVerifier([]string{"static"}, "")
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	// 没有配置secret时不接受签名密钥
+	if err := v.Verify("stream", signedArgs("", "stream", future)); !errors.Is(err, utils.InvalidStreamKey) {
+		t.Errorf("Verify = %v, want InvalidStreamKey", err)
+	}
+}
+
+func TestVerifyConnect(t *testing.T) {
+	v := NewKeyVerifier(nil, "secret")
+	past := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	tests := []struct {
+		args url.Values
+		want error
+	}{
+		{nil, nil},
+		{url.Values{"exp": {future}}, nil},
+		{url.Values{"exp": {past}}, utils.StreamKeyExpired},
+		{url.Values{"exp": {"soon"}}, utils.InvalidStreamKey},
+	}
+	for _, tt := range tests {
+		if err := v.VerifyConnect(tt.args); !errors.Is(err, tt.want) {
+			t.Errorf("VerifyConnect(%v) = %v, want %v", tt.args, err, tt.want)
+		}
+	}
+}
+
+func TestMatchKey(t *testing.T) {
+	v := NewKeyVerifier([]string{"a", "bb", "ccc"}, "")
+	for _, key := range []string{"a", "bb", "ccc"} {
+		if !v.matchKey(key) {
+			t.Errorf("matchKey(%q) = false", key)
+		}
+	}
+	for _, key := range []string{"", "b", "cc", "cccc", "A"} {
+		if v.matchKey(key) {
+			t.Errorf("matchKey(%q) = true", key)
+		}
+	}
+}
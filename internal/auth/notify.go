@@ -0,0 +1,97 @@
+package auth
+
+// 兼容nginx-rtmp的on_publish/on_done HTTP回调
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"rtmpproxy/internal"
+	"rtmpproxy/internal/rtmp"
+	"rtmpproxy/utils"
+	"strings"
+	"time"
+)
+
+// Notifier 推流开始前和结束后通知HTTP服务
+type Notifier struct {
+	OnPublishURL string
+	OnDoneURL    string
+	client       *http.Client
+}
+
+func NewNotifier(onPublish string, onDone string, timeout time.Duration) *Notifier {
+	return &Notifier{
+		OnPublishURL: onPublish,
+		OnDoneURL:    onDone,
+		client: &http.Client{
+			Timeout: timeout,
+			// 3xx不跟随，由Location决定新的远程地址
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// OnPublish 2xx允许推流，3xx的Location为新的远程地址，其它状态码拒绝推流
+func (n *Notifier) OnPublish(s *internal.Session, req *rtmp.PublishRequest) (string, error) {
+	if n.OnPublishURL == "" {
+		return "", nil
+	}
+	resp, err := n.client.PostForm(n.OnPublishURL, notifyValues("publish", s, req))
+	if err != nil {
+		return "", fmt.Errorf("on_publish request failed: %w", err)
+	}
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return "", nil
+	case resp.StatusCode >= 300 && resp.StatusCode < 400:
+		location := resp.Header.Get("Location")
+		if _, _, err := utils.ParseLink(location); err != nil {
+			return "", fmt.Errorf("%w: %q", utils.InvalidRedirect, utils.RedactLink(location))
+		}
+		s.Logger().Info("on_publish redirected to new remote address", "remote", utils.RedactLink(location))
+		return location, nil
+	}
+	return "", fmt.Errorf("%w: on_publish returned %s", utils.PublishUnauthorized, resp.Status)
+}
+
+// OnDone 推流结束后通知，忽略返回结果
+func (n *Notifier) OnDone(s *internal.Session, req *rtmp.PublishRequest) {
+	if n.OnDoneURL == "" {
+		return
+	}
+	resp, err := n.client.PostForm(n.OnDoneURL, notifyValues("done", s, req))
+	if err != nil {
+		s.Logger().Warn("on_done request failed", "err", err)
+		return
+	}
+	_ = resp.Body.Close()
+}
+
+// notifyValues 与nginx-rtmp相同的表单参数，附带客户端的?参数
+func notifyValues(call string, s *internal.Session, req *rtmp.PublishRequest) url.Values {
+	values := url.Values{}
+	for k, v := range req.Args {
+		values[k] = append([]string(nil), v...)
+	}
+	addr := s.ClientAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	values.Set("call", call)
+	values.Set("addr", addr)
+	values.Set("clientid", s.ID)
+	values.Set("app", req.App)
+	values.Set("flashVer", req.FlashVer)
+	values.Set("swfUrl", req.SwfUrl)
+	values.Set("tcUrl", strings.SplitN(req.TcUrl, "?", 2)[0])
+	values.Set("pageUrl", req.PageUrl)
+	values.Set("name", req.StreamName)
+	values.Set("type", req.Type)
+	return values
+}
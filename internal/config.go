@@ -1,23 +1,41 @@
 package internal
 
 import (
-	"golang.org/x/net/proxy"
-	"net"
-	"net/url"
+	"rtmpproxy/internal/acl"
+	"time"
 )
 
 type Config struct {
-	ListenAddr         *string
-	RemoteAddr         *string
-	ProxyAddr          *string
-	Plugin             *Plugin // 插件功能
-	InsecureSkipVerify bool
-	ForceHandle        bool // 强制处理所有数据包（可以处理到关闭流的streamName），仅在必要时启用
-	FlashVer           string
-	RTMPType           string
-	RemoteURL          *url.URL     // 解析后的远程地址
-	dialer             proxy.Dialer // 内部使用的dialer
-	conn               net.Conn     // 连接实例
+	ListenAddr          *string
+	RemoteAddr          *string
+	ProxyAddr           *string
+	Plugin              *Plugin // 插件功能
+	InsecureSkipVerify  bool
+	FlashVer            string
+	RTMPType            string
+	OnPublish           string        // 推流开始前回调的HTTP地址，兼容nginx-rtmp on_publish
+	OnDone              string        // 推流结束后回调的HTTP地址，兼容nginx-rtmp on_done
+	StreamKeys          []string      // 允许推流的本地密钥
+	KeySecret           string        // 签名密钥的HMAC secret
+	Access              acl.Config    // 监听端口的访问控制
+	AcceptProxyProtocol bool          // 接受受信任的负载均衡发送的PROXY头
+	ProxyProtocolFrom   []string      // 允许发送PROXY头的负载均衡CIDR或IP
+	SendProxyProtocol   int           // 向远程服务器发送的PROXY头版本，0 为不发送
+	HookPolicy          string        // 插件事件失败时的处理策略，见 plugins.ParsePolicies
+	StatsInterval       time.Duration // 推流统计的汇总间隔，0 为不汇总
+	Profile             string        // 检查推流参数的平台限制，见 stats.ParseProfile
+	StallTimeout        time.Duration // 超过该时长没有收到视频时报告推流问题，0 为不检测
+	BitrateCollapse     float64       // 视频码率低于平均码率的比例时报告码率骤降，0 为不检测
+	Standby             time.Duration // 客户端异常断开后发送垫片等待重新连接的时长，0 为不等待
+	Slate               string        // 垫片FLV文件
+	Failover            string        // 主备推流密钥，见 server.parseFailover
+	Delay               time.Duration // 客户端的音视频延迟该时长后再发往远程服务器，0 为不延迟
+	TimestampJump       time.Duration // 修复超过该阈值的时间戳跳变，0 为不修复
+	SessionRate         string        // 单个会话发往远程服务器的限速，见 shaper.New
+	SendQueue           time.Duration // 发送队列的最大延迟，超过时丢弃视频帧，0 为直接写入
+	Tracks              string        // 按route去掉视频、音频或数据消息，见 server.parseTracks
+	Output              string        // 转发的推流同时写入的FLV文件，"-" 为标准输出
+	GlobalRate          string        // 所有会话发往远程服务器的合计限速
 }
 
 type Plugin struct {
@@ -6,60 +6,61 @@ import (
 	"crypto/tls"
 	"fmt"
 	"golang.org/x/net/proxy"
-	"log"
+	"log/slog"
 	"net"
 	"net/url"
+	"rtmpproxy/internal/metrics"
+	"rtmpproxy/internal/proxyproto"
 	"rtmpproxy/utils"
+	"time"
 )
 
-// CreateDialer 创建proxy dialer
-func (c *Config) CreateDialer() error {
+// CreateDialer 根据 ProxyAddr 创建dialer，每次连接时创建，Config 可以被多个会话同时使用
+func (c *Config) CreateDialer() (proxy.Dialer, error) {
 	if *c.ProxyAddr == "" {
-		log.Printf("Direct to the remote server")
-		c.dialer = proxy.Direct
-	} else {
-		var auth *proxy.Auth
-		// 使用 url.Parse 解析字符串
-		proxyURL, err := url.Parse(*c.ProxyAddr)
-		if err != nil {
-			return utils.InvalidProxy
-		}
-		// 提取代理服务器地址 (Host 字段包含地址和端口)
-		proxyAddress := proxyURL.Host
-		if proxyAddress == "" {
-			return utils.InvalidProxy
-		} else {
-			if proxyURL.Scheme != "socks5" {
-				return utils.InvalidProxyScheme
-			}
-			if proxyURL.User != nil {
-				user := proxyURL.User.Username()
-				pass, ok := proxyURL.User.Password()
-				if user == "" || !ok {
-					return utils.InvalidProxyAuth
-				}
-				auth = &proxy.Auth{User: user, Password: pass}
-			}
+		slog.Debug("Direct to the remote server")
+		return proxy.Direct, nil
+	}
+	var auth *proxy.Auth
+	// 使用 url.Parse 解析字符串
+	proxyURL, err := url.Parse(*c.ProxyAddr)
+	if err != nil {
+		return nil, utils.InvalidProxy
+	}
+	// 提取代理服务器地址 (Host 字段包含地址和端口)
+	proxyAddress := proxyURL.Host
+	if proxyAddress == "" {
+		return nil, utils.InvalidProxy
+	}
+	if proxyURL.Scheme != "socks5" {
+		return nil, utils.InvalidProxyScheme
+	}
+	if proxyURL.User != nil {
+		user := proxyURL.User.Username()
+		pass, ok := proxyURL.User.Password()
+		if user == "" || !ok {
+			return nil, utils.InvalidProxyAuth
 		}
+		auth = &proxy.Auth{User: user, Password: pass}
+	}
 
-		c.dialer, err = proxy.SOCKS5("tcp", proxyAddress, auth, proxy.Direct)
-		if err != nil {
-			// 创建配置失败报错
-			return utils.FailedToCreateProxyDialer // 创建dialer配置失败
-		}
-		log.Printf("Using proxy: %s", *c.ProxyAddr)
+	dialer, err := proxy.SOCKS5("tcp", proxyAddress, auth, proxy.Direct)
+	if err != nil {
+		// 创建配置失败报错
+		return nil, utils.FailedToCreateProxyDialer // 创建dialer配置失败
 	}
-	return nil
+	slog.Debug("Using proxy", "proxy", proxyAddress)
+	return dialer, nil
 }
 
 // EstablishTLS 在给定的原始连接上建立 TLS 客户端连接
-func (c *Config) EstablishTLS() error {
+func (c *Config) EstablishTLS(rawConn net.Conn, remoteURL *url.URL, logger *slog.Logger) (net.Conn, error) {
 	// 从 remoteAddr 中提取主机名用于 TLS ServerName (SNI)
-	remoteHost, _, err := net.SplitHostPort(c.RemoteURL.Host)
+	remoteHost, _, err := net.SplitHostPort(remoteURL.Host)
 	if err != nil {
-		if net.ParseIP(c.RemoteURL.Host) != nil {
-			remoteHost = c.RemoteURL.Host
-			log.Printf("[%s] Warning: Remote address looks like an IP address. TLS validation might fail or require specific server configuration (SNI).", remoteHost)
+		if net.ParseIP(remoteURL.Host) != nil {
+			remoteHost = remoteURL.Host
+			logger.Warn("Remote address looks like an IP address. TLS validation might fail or require specific server configuration (SNI).", "host", remoteHost)
 		}
 	}
 
@@ -69,54 +70,69 @@ func (c *Config) EstablishTLS() error {
 		InsecureSkipVerify: c.InsecureSkipVerify,
 	}
 
-	log.Printf("Starting TLS handshake with %s (ServerName: %s)...", c.RemoteURL.Host, remoteHost)
+	logger.Debug("Starting TLS handshake", "remote", remoteURL.Host, "server_name", remoteHost)
 	// 在 rawConn 之上创建 TLS 客户端连接
-	c.conn = tls.Client(c.conn, tlsConfig)
+	tlsConn := tls.Client(rawConn, tlsConfig)
 
 	// 执行 TLS 握手
 
-	err = c.conn.(*tls.Conn).Handshake()
+	err = tlsConn.Handshake()
 	if err != nil {
-		log.Printf("TLS handshake failed with remote %s: %v", c.RemoteURL.Host, err)
-		return utils.FailedToEstablishTLS // 返回错误
+		logger.Warn("TLS handshake failed", "remote", remoteURL.Host, "err", err)
+		return nil, utils.FailedToEstablishTLS // 返回错误
 	}
 	// TLS 握手成功，返回 tlsConn
-	return nil
+	return tlsConn, nil
 }
 
-// ConnectRemoteAddress 连接到远程地址
-func (c *Config) ConnectRemoteAddress() (net.Conn, error) {
-	var err error
-	var useTLS bool
-	c.RemoteURL, useTLS, err = utils.ParseLink(*c.RemoteAddr)
+// ConnectRemoteAddress 连接到远程地址，返回连接和解析后的远程地址，clientAddr 用于发送PROXY头
+func (c *Config) ConnectRemoteAddress(remoteAddr string, clientAddr net.Addr, logger *slog.Logger) (net.Conn, *url.URL, error) {
+	remoteURL, useTLS, err := utils.ParseLink(remoteAddr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse remote address '%s': %w", *c.RemoteAddr, err)
+		return nil, nil, fmt.Errorf("failed to parse remote address '%s': %w", utils.RedactLink(remoteAddr), err)
 	}
-	err = c.CreateDialer()
+	dialer, err := c.CreateDialer()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	log.Printf("Dialing remote server %s", c.RemoteURL.Host)
-	c.conn, err = c.dialer.Dial("tcp", c.RemoteURL.Host)
+	logger.Debug("Dialing remote server", "remote", remoteURL.Host)
+	dialStart := time.Now()
+	conn, err := dialer.Dial("tcp", remoteURL.Host)
 	if err != nil {
-		log.Printf("Dial error: %v", err)
-		return nil, utils.FailedToConnectRemoteServer
+		logger.Warn("Dial error", "remote", remoteURL.Host, "err", err)
+		return nil, nil, utils.FailedToConnectRemoteServer
 	}
+	metrics.DialDuration.WithLabelValues(remoteURL.Host).Observe(time.Since(dialStart).Seconds())
 
-	log.Printf("Resolved remote target: %s (TLS: %v)", c.RemoteURL.Host, map[bool]string{true: "TLS", false: "No TLS"}[useTLS])
+	if c.SendProxyProtocol != 0 {
+		// 通过socks5代理时conn.RemoteAddr()不是远程服务器地址
+		dstAddr := conn.RemoteAddr()
+		if dialer != proxy.Direct {
+			if addr, err := net.ResolveTCPAddr("tcp", remoteURL.Host); err == nil {
+				dstAddr = addr
+			}
+		}
+		err = proxyproto.WriteHeader(conn, c.SendProxyProtocol, clientAddr, dstAddr)
+		if err != nil {
+			_ = conn.Close()
+			return nil, nil, fmt.Errorf("failed to send PROXY protocol header: %w", err)
+		}
+	}
 
 	if useTLS {
-		log.Printf("Establishing TLS connection with %s...", c.RemoteURL.Host)
-		// EstablishTLS 应该接收原始连接，返回 TLS 连接
+		// EstablishTLS 接收原始连接，返回 TLS 连接
 		// 注意：传入 RemoteURL.Hostname() 可能更适合 TLS 验证，而不是 remoteHost (包含端口)
-		err = c.EstablishTLS()
+		tlsStart := time.Now()
+		tlsConn, err := c.EstablishTLS(conn, remoteURL, logger)
 		if err != nil {
-			return nil, utils.FailedToEstablishTLS
+			_ = conn.Close()
+			return nil, nil, utils.FailedToEstablishTLS
 		}
-		log.Printf("TLS handshake successful with %s", c.RemoteURL.Host)
+		metrics.TLSDuration.WithLabelValues(remoteURL.Host).Observe(time.Since(tlsStart).Seconds())
+		conn = tlsConn
 	}
 
-	log.Printf("Successfully connected to remote Server")
-	return c.conn, nil
+	logger.Info("Connected to remote server", "remote", remoteURL.Host, "tls", useTLS)
+	return conn, remoteURL, nil
 }
@@ -0,0 +1,79 @@
+package flv
+
+import "errors"
+
+var errShortData = errors.New("unexpected end of data")
+
+// bitReader 按位读取，用于解析SPS和AudioSpecificConfig
+type bitReader struct {
+	data []byte
+	pos  int // 已读取的位数
+	err  error
+}
+
+func (r *bitReader) bit() uint32 {
+	if r.pos >= len(r.data)*8 {
+		r.err = errShortData
+		return 0
+	}
+	b := r.data[r.pos/8] >> (7 - r.pos%8) & 1
+	r.pos++
+	return uint32(b)
+}
+
+// bits 读取n(<=32)位无符号整数
+func (r *bitReader) bits(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		v = v<<1 | r.bit()
+	}
+	return v
+}
+
+func (r *bitReader) skip(n int) {
+	r.pos += n
+	if r.pos > len(r.data)*8 {
+		r.err = errShortData
+	}
+}
+
+// ue 读取无符号指数哥伦布编码
+func (r *bitReader) ue() uint32 {
+	zeros := 0
+	for r.bit() == 0 {
+		if r.err != nil || zeros >= 31 {
+			r.err = errShortData
+			return 0
+		}
+		zeros++
+	}
+	return (1<<zeros - 1) + r.bits(zeros)
+}
+
+// se 读取有符号指数哥伦布编码
+func (r *bitReader) se() int32 {
+	v := r.ue()
+	if v&1 == 1 {
+		return int32((v + 1) / 2)
+	}
+	return -int32(v / 2)
+}
+
+// unescapeRBSP 去掉NAL单元中的防竞争字节 0x000003
+func unescapeRBSP(nal []byte) []byte {
+	out := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, b := range nal {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
@@ -0,0 +1,68 @@
+package flv
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBitReader(t *testing.T) {
+	// 1 | 010 | 011 | 00100 | 1011
+	r := &bitReader{data: []byte{0xa6, 0x4b}}
+	for i, want := range []uint32{0, 1, 2, 3} {
+		if got := r.ue(); got != want {
+			t.Errorf("ue #%d = %d, want %d", i, got, want)
+		}
+	}
+	if got := r.bits(4); got != 0xb {
+		t.Errorf("bits(4) = %#x, want 0xb", got)
+	}
+	if r.err != nil {
+		t.Fatalf("err = %v", r.err)
+	}
+	r.bit()
+	if r.err != errShortData {
+		t.Errorf("read past end: err = %v, want errShortData", r.err)
+	}
+}
+
+func TestBitReaderSigned(t *testing.T) {
+	// ue 0,1,2,3,4 对应 se 0,1,-1,2,-2: 1 | 010 | 011 | 00100 | 00101
+	r := &bitReader{data: []byte{0xa6, 0x42, 0x80}}
+	for i, want := range []int32{0, 1, -1, 2, -2} {
+		if got := r.se(); got != want {
+			t.Errorf("se #%d = %d, want %d", i, got, want)
+		}
+	}
+	if r.err != nil {
+		t.Fatalf("err = %v", r.err)
+	}
+}
+
+func TestBitReaderShort(t *testing.T) {
+	// 前导零超出数据
+	r := &bitReader{data: []byte{0x00}}
+	if r.ue(); r.err == nil {
+		t.Errorf("ue on zeros: err = nil")
+	}
+	r = &bitReader{data: []byte{0xff}}
+	if r.skip(9); r.err == nil {
+		t.Errorf("skip past end: err = nil")
+	}
+}
+
+func TestUnescapeRBSP(t *testing.T) {
+	tests := []struct {
+		in, want []byte
+	}{
+		{[]byte{0x00, 0x00, 0x03, 0x01}, []byte{0x00, 0x00, 0x01}},
+		{[]byte{0x00, 0x00, 0x03, 0x03}, []byte{0x00, 0x00, 0x03}},
+		{[]byte{0x00, 0x00, 0x03, 0x00, 0x00, 0x03}, []byte{0x00, 0x00, 0x00, 0x00}},
+		{[]byte{0x00, 0x03, 0x00, 0x03}, []byte{0x00, 0x03, 0x00, 0x03}},
+		{[]byte{0x67, 0x64}, []byte{0x67, 0x64}},
+	}
+	for _, tt := range tests {
+		if got := unescapeRBSP(tt.in); !bytes.Equal(got, tt.want) {
+			t.Errorf("unescapeRBSP(% x) = % x, want % x", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,480 @@
+package flv
+
+// 解析sequence header中的编码参数：AVCDecoderConfigurationRecord/SPS、HEVCDecoderConfigurationRecord/VPS/SPS和AudioSpecificConfig
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnsupportedCodec = errors.New("unsupported codec")
+	ErrInvalidConfig    = errors.New("invalid decoder configuration")
+)
+
+// VideoInfo 视频编码参数
+type VideoInfo struct {
+	Codec   string
+	Width   int
+	Height  int
+	Profile string
+	Level   string
+}
+
+// AudioInfo 音频编码参数
+type AudioInfo struct {
+	Codec      string
+	SampleRate int
+	Channels   int
+	Profile    string
+}
+
+// ParseVideoSequenceHeader 从视频sequence header消息中解析编码参数
+func ParseVideoSequenceHeader(payload []byte) (VideoInfo, error) {
+	tag, ok := ParseVideoTag(payload)
+	if !ok || !tag.IsSequenceHeader() {
+		return VideoInfo{}, fmt.Errorf("%w: not a sequence header", ErrInvalidConfig)
+	}
+	record := payload[tag.HeaderSize:]
+	var info VideoInfo
+	var err error
+	switch tag.Codec() {
+	case "H264":
+		info, err = parseAVCDecoderConfig(record)
+	case "HEVC":
+		info, err = parseHEVCDecoderConfig(record)
+	default:
+		return VideoInfo{Codec: tag.Codec()}, ErrUnsupportedCodec
+	}
+	info.Codec = tag.Codec()
+	return info, err
+}
+
+// ParseAudioSequenceHeader 从音频消息中解析编码参数，AAC使用AudioSpecificConfig，其它编码使用tag header
+func ParseAudioSequenceHeader(payload []byte) (AudioInfo, error) {
+	tag, ok := ParseAudioTag(payload)
+	if !ok {
+		return AudioInfo{}, fmt.Errorf("%w: invalid audio tag", ErrInvalidConfig)
+	}
+	info := AudioInfo{Codec: tag.Codec()}
+	if tag.SoundFormat == SoundAAC && tag.IsSequenceHeader() {
+		return parseAudioSpecificConfig(payload[tag.HeaderSize:])
+	}
+	if tag.SoundFormat == SoundExHeader {
+		// Enhanced RTMP 的音频参数在各编码自己的配置中
+		return info, ErrUnsupportedCodec
+	}
+	info.SampleRate = [...]int{5512, 11025, 22050, 44100}[tag.SoundRate]
+	info.Channels = int(tag.SoundType) + 1
+	return info, nil
+}
+
+var avcProfiles = map[uint32]string{
+	66:  "Baseline",
+	77:  "Main",
+	88:  "Extended",
+	100: "High",
+	110: "High 10",
+	122: "High 4:2:2",
+	244: "High 4:4:4",
+}
+
+// parseAVCDecoderConfig 解析AVCDecoderConfigurationRecord中的第一个SPS
+func parseAVCDecoderConfig(record []byte) (VideoInfo, error) {
+	if len(record) < 8 || record[0] != 1 {
+		return VideoInfo{}, ErrInvalidConfig
+	}
+	numSPS := int(record[5] & 0x1f)
+	if numSPS == 0 {
+		return VideoInfo{}, fmt.Errorf("%w: no SPS", ErrInvalidConfig)
+	}
+	n := int(binary.BigEndian.Uint16(record[6:]))
+	if len(record) < 8+n || n < 4 {
+		return VideoInfo{}, ErrInvalidConfig
+	}
+	return parseAVCSPS(record[8 : 8+n])
+}
+
+// parseAVCSPS 解析H.264 SPS，sps 包含1字节的NAL header
+func parseAVCSPS(sps []byte) (VideoInfo, error) {
+	r := &bitReader{data: unescapeRBSP(sps[1:])}
+	profileIdc := r.bits(8)
+	r.skip(8) // constraint_set flags
+	levelIdc := r.bits(8)
+	r.ue() // seq_parameter_set_id
+
+	chromaFormatIdc := uint32(1)
+	separateColourPlane := uint32(0)
+	switch profileIdc {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormatIdc = r.ue()
+		if chromaFormatIdc == 3 {
+			separateColourPlane = r.bit()
+		}
+		r.ue()            // bit_depth_luma_minus8
+		r.ue()            // bit_depth_chroma_minus8
+		r.bit()           // qpprime_y_zero_transform_bypass_flag
+		if r.bit() == 1 { // seq_scaling_matrix_present_flag
+			lists := 8
+			if chromaFormatIdc == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if r.bit() == 1 {
+					size := 16
+					if i >= 6 {
+						size = 64
+					}
+					skipScalingList(r, size)
+				}
+			}
+		}
+	}
+	r.ue()          // log2_max_frame_num_minus4
+	switch r.ue() { // pic_order_cnt_type
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.bit() // delta_pic_order_always_zero_flag
+		r.se()  // offset_for_non_ref_pic
+		r.se()  // offset_for_top_to_bottom_field
+		cycle := r.ue()
+		for i := uint32(0); i < cycle && r.err == nil; i++ {
+			r.se()
+		}
+	}
+	r.ue()  // max_num_ref_frames
+	r.bit() // gaps_in_frame_num_value_allowed_flag
+	widthInMbs := r.ue() + 1
+	heightInMapUnits := r.ue() + 1
+	frameMbsOnly := r.bit()
+	if frameMbsOnly == 0 {
+		r.bit() // mb_adaptive_frame_field_flag
+	}
+	r.bit() // direct_8x8_inference_flag
+	var cropLeft, cropRight, cropTop, cropBottom uint32
+	if r.bit() == 1 {
+		cropLeft, cropRight, cropTop, cropBottom = r.ue(), r.ue(), r.ue(), r.ue()
+	}
+	if r.err != nil {
+		return VideoInfo{}, fmt.Errorf("%w: SPS: %v", ErrInvalidConfig, r.err)
+	}
+
+	// 裁剪单位，见H.264标准 7.4.2.1.1
+	chromaArrayType := chromaFormatIdc
+	if separateColourPlane == 1 {
+		chromaArrayType = 0
+	}
+	cropUnitX, cropUnitY := uint32(1), 2-frameMbsOnly
+	switch chromaArrayType {
+	case 1:
+		cropUnitX, cropUnitY = 2, 2*(2-frameMbsOnly)
+	case 2:
+		cropUnitX, cropUnitY = 2, 2-frameMbsOnly
+	}
+	width := widthInMbs*16 - cropUnitX*(cropLeft+cropRight)
+	height := (2-frameMbsOnly)*heightInMapUnits*16 - cropUnitY*(cropTop+cropBottom)
+
+	profile, ok := avcProfiles[profileIdc]
+	if !ok {
+		profile = fmt.Sprintf("%d", profileIdc)
+	}
+	return VideoInfo{
+		Width:   int(width),
+		Height:  int(height),
+		Profile: profile,
+		Level:   fmt.Sprintf("%d.%d", levelIdc/10, levelIdc%10),
+	}, nil
+}
+
+func skipScalingList(r *bitReader, size int) {
+	last, next := int32(8), int32(8)
+	for i := 0; i < size && r.err == nil; i++ {
+		if next != 0 {
+			next = (last + r.se() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+var hevcProfiles = map[uint32]string{
+	1: "Main",
+	2: "Main 10",
+	3: "Main Still Picture",
+	4: "Range Extensions",
+}
+
+// NAL单元类型
+const (
+	hevcNALVPS = 32
+	hevcNALSPS = 33
+)
+
+// parseHEVCDecoderConfig 解析HEVCDecoderConfigurationRecord的NAL数组中的VPS和SPS，分辨率来自SPS，
+// profile和level优先使用VPS中整个码流的值
+func parseHEVCDecoderConfig(record []byte) (VideoInfo, error) {
+	if len(record) < 23 || record[0] != 1 {
+		return VideoInfo{}, ErrInvalidConfig
+	}
+	var vps, sps []byte
+	numArrays := int(record[22])
+	pos := 23
+	for i := 0; i < numArrays; i++ {
+		if len(record) < pos+3 {
+			return VideoInfo{}, ErrInvalidConfig
+		}
+		nalType := record[pos] & 0x3f
+		numNalus := int(binary.BigEndian.Uint16(record[pos+1:]))
+		pos += 3
+		for j := 0; j < numNalus; j++ {
+			if len(record) < pos+2 {
+				return VideoInfo{}, ErrInvalidConfig
+			}
+			n := int(binary.BigEndian.Uint16(record[pos:]))
+			pos += 2
+			if len(record) < pos+n {
+				return VideoInfo{}, ErrInvalidConfig
+			}
+			switch {
+			case nalType == hevcNALVPS && n > 2 && vps == nil:
+				vps = record[pos : pos+n]
+			case nalType == hevcNALSPS && n > 2 && sps == nil:
+				sps = record[pos : pos+n]
+			}
+			pos += n
+		}
+	}
+	if sps == nil {
+		return VideoInfo{}, fmt.Errorf("%w: no SPS", ErrInvalidConfig)
+	}
+	info, err := parseHEVCSPS(sps)
+	if err != nil || vps == nil {
+		return info, err
+	}
+	profileIdc, levelIdc, err := parseHEVCVPS(vps)
+	if err != nil {
+		return VideoInfo{}, err
+	}
+	info.Profile, info.Level = hevcProfile(profileIdc), hevcLevel(levelIdc)
+	return info, nil
+}
+
+// parseHEVCVPS 解析H.265 VPS中的general_profile_idc和general_level_idc，vps 包含2字节的NAL header
+func parseHEVCVPS(vps []byte) (profileIdc uint32, levelIdc uint32, err error) {
+	r := &bitReader{data: unescapeRBSP(vps[2:])}
+	r.skip(4) // vps_video_parameter_set_id
+	r.skip(2) // vps_base_layer_internal_flag, vps_base_layer_available_flag
+	r.skip(6) // vps_max_layers_minus1
+	maxSubLayersMinus1 := int(r.bits(3))
+	r.bit()    // vps_temporal_id_nesting_flag
+	r.skip(16) // vps_reserved_0xffff_16bits
+	profileIdc, levelIdc = parseHEVCProfileTierLevel(r, maxSubLayersMinus1)
+	if r.err != nil {
+		return 0, 0, fmt.Errorf("%w: VPS: %v", ErrInvalidConfig, r.err)
+	}
+	return profileIdc, levelIdc, nil
+}
+
+// parseHEVCSPS 解析H.265 SPS，sps 包含2字节的NAL header
+func parseHEVCSPS(sps []byte) (VideoInfo, error) {
+	r := &bitReader{data: unescapeRBSP(sps[2:])}
+	r.skip(4) // sps_video_parameter_set_id
+	maxSubLayersMinus1 := int(r.bits(3))
+	r.bit() // sps_temporal_id_nesting_flag
+	profileIdc, levelIdc := parseHEVCProfileTierLevel(r, maxSubLayersMinus1)
+
+	r.ue() // sps_seq_parameter_set_id
+	chromaFormatIdc := r.ue()
+	if chromaFormatIdc == 3 {
+		r.bit() // separate_colour_plane_flag
+	}
+	width := r.ue()
+	height := r.ue()
+	if r.bit() == 1 { // conformance_window_flag
+		left, right, top, bottom := r.ue(), r.ue(), r.ue(), r.ue()
+		subWidthC, subHeightC := uint32(1), uint32(1)
+		switch chromaFormatIdc {
+		case 1:
+			subWidthC, subHeightC = 2, 2
+		case 2:
+			subWidthC = 2
+		}
+		width -= subWidthC * (left + right)
+		height -= subHeightC * (top + bottom)
+	}
+	if r.err != nil {
+		return VideoInfo{}, fmt.Errorf("%w: SPS: %v", ErrInvalidConfig, r.err)
+	}
+	return VideoInfo{
+		Width:   int(width),
+		Height:  int(height),
+		Profile: hevcProfile(profileIdc),
+		Level:   hevcLevel(levelIdc),
+	}, nil
+}
+
+// parseHEVCProfileTierLevel 解析VPS和SPS中的profile_tier_level，返回general_profile_idc和general_level_idc
+func parseHEVCProfileTierLevel(r *bitReader, maxSubLayersMinus1 int) (profileIdc uint32, levelIdc uint32) {
+	r.skip(2) // general_profile_space
+	r.bit()   // general_tier_flag
+	profileIdc = r.bits(5)
+	r.skip(32) // general_profile_compatibility_flags
+	r.skip(48) // general constraint flags
+	levelIdc = r.bits(8)
+	profilePresent := make([]uint32, maxSubLayersMinus1)
+	levelPresent := make([]uint32, maxSubLayersMinus1)
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		profilePresent[i] = r.bit()
+		levelPresent[i] = r.bit()
+	}
+	if maxSubLayersMinus1 > 0 {
+		r.skip(2 * (8 - maxSubLayersMinus1))
+	}
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		if profilePresent[i] == 1 {
+			r.skip(88)
+		}
+		if levelPresent[i] == 1 {
+			r.skip(8)
+		}
+	}
+	return profileIdc, levelIdc
+}
+
+func hevcProfile(profileIdc uint32) string {
+	if profile, ok := hevcProfiles[profileIdc]; ok {
+		return profile
+	}
+	return fmt.Sprintf("%d", profileIdc)
+}
+
+// hevcLevel general_level_idc 为 30 倍的level
+func hevcLevel(levelIdc uint32) string {
+	return fmt.Sprintf("%d.%d", levelIdc/30, levelIdc%30/3)
+}
+
+var aacSampleRates = [...]int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+var aacProfiles = map[uint32]string{
+	1:  "Main",
+	2:  "LC",
+	3:  "SSR",
+	4:  "LTP",
+	5:  "HE-AAC",
+	29: "HE-AACv2",
+}
+
+// parseAudioSpecificConfig 解析AAC的AudioSpecificConfig，HE-AAC返回SBR扩展后的采样率
+func parseAudioSpecificConfig(config []byte) (AudioInfo, error) {
+	r := &bitReader{data: config}
+	objectType := func() uint32 {
+		t := r.bits(5)
+		if t == 31 {
+			t = 32 + r.bits(6)
+		}
+		return t
+	}
+	sampleRate := func() int {
+		index := r.bits(4)
+		if index == 15 {
+			return int(r.bits(24))
+		}
+		if int(index) >= len(aacSampleRates) {
+			r.err = ErrInvalidConfig
+			return 0
+		}
+		return aacSampleRates[index]
+	}
+
+	aot := objectType()
+	rate := sampleRate()
+	channels := int(r.bits(4))
+	if aot == 5 || aot == 29 {
+		// 显式的SBR信令，之后为扩展采样率
+		rate = sampleRate()
+	}
+	if r.err != nil {
+		return AudioInfo{}, fmt.Errorf("%w: AudioSpecificConfig: %v", ErrInvalidConfig, r.err)
+	}
+	if channels == 7 {
+		channels = 8 // 7.1
+	}
+	profile, ok := aacProfiles[aot]
+	if !ok {
+		profile = fmt.Sprintf("%d", aot)
+	}
+	return AudioInfo{Codec: "AAC", SampleRate: rate, Channels: channels, Profile: profile}, nil
+}
+
+// NALULengthSize 返回H.264/HEVC sequence header中NALU长度字段的字节数，无法解析时返回4
+func NALULengthSize(payload []byte) int {
+	tag, ok := ParseVideoTag(payload)
+	if !ok || !tag.IsSequenceHeader() {
+		return 4
+	}
+	record := payload[tag.HeaderSize:]
+	switch tag.Codec() {
+	case "H264":
+		if len(record) > 4 {
+			return int(record[4]&0x03) + 1
+		}
+	case "HEVC":
+		if len(record) > 21 {
+			return int(record[21]&0x03) + 1
+		}
+	}
+	return 4
+}
+
+// IsDisposable 视频帧是否不被其它帧参考、可以单独丢弃：H.263的可丢弃帧，所有slice的nal_ref_idc为0的H.264帧，
+// 或者只包含子层非参考帧的HEVC帧，lengthSize 为NALU长度字段的字节数
+func IsDisposable(payload []byte, lengthSize int) bool {
+	tag, ok := ParseVideoTag(payload)
+	if !ok || !tag.IsFrame() {
+		return false
+	}
+	if tag.FrameType == FrameDisposable {
+		return true
+	}
+	if tag.FrameType == FrameKey || lengthSize < 1 || lengthSize > 4 {
+		return false
+	}
+	codec := tag.Codec()
+	if codec != "H264" && codec != "HEVC" {
+		return false
+	}
+	data := payload[tag.HeaderSize:]
+	slices := 0
+	for len(data) >= lengthSize {
+		n := 0
+		for _, b := range data[:lengthSize] {
+			n = n<<8 | int(b)
+		}
+		data = data[lengthSize:]
+		if n == 0 || n > len(data) {
+			return false
+		}
+		nal := data[:n]
+		data = data[n:]
+		if codec == "H264" {
+			if t := nal[0] & 0x1f; t >= 1 && t <= 5 {
+				if nal[0]&0x60 != 0 {
+					return false
+				}
+				slices++
+			}
+			continue
+		}
+		if t := (nal[0] >> 1) & 0x3f; t <= 31 {
+			// HEVC VCL NAL，0-14中的偶数为子层非参考帧
+			if t > 14 || t%2 != 0 {
+				return false
+			}
+			slices++
+		}
+	}
+	return slices > 0
+}
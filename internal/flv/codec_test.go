@@ -0,0 +1,205 @@
+package flv
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// x264 1280x720 High 3.1 的SPS和PPS
+var (
+	avcSPS = []byte{0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50, 0x05, 0xbb, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00,
+		0x10, 0x00, 0x00, 0x03, 0x03, 0xc0, 0xf1, 0x83, 0x19, 0x60}
+	avcPPS = []byte{0x68, 0xeb, 0xe3, 0xcb, 0x22, 0xc0}
+)
+
+// x265 1280x720 Main 3.1 的VPS和SPS
+var (
+	hevcVPS = []byte{0x40, 0x01, 0x0c, 0x01, 0xff, 0xff, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03,
+		0x00, 0x00, 0x03, 0x00, 0x5d, 0x95, 0x98, 0x09}
+	hevcSPS = []byte{0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03,
+		0x00, 0x5d, 0xa0, 0x02, 0x80, 0x80, 0x2d, 0x16, 0x59, 0x59, 0xa4, 0x93, 0x2b, 0xc0, 0x5a, 0x70, 0x80, 0x00,
+		0x01, 0xf4, 0x80, 0x00, 0x3a, 0x98, 0x04}
+)
+
+// avcSequenceHeader 传统格式的AVC sequence header
+func avcSequenceHeader(sps []byte, pps []byte) []byte {
+	b := []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01, sps[1], sps[2], sps[3], 0xff, 0xe1}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(sps)))
+	b = append(b, sps...)
+	b = append(b, 0x01)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(pps)))
+	return append(b, pps...)
+}
+
+// hevcSequenceHeader Enhanced RTMP格式的HEVC sequence header，nalus 按顺序各为一个NAL数组
+func hevcSequenceHeader(nalus ...[]byte) []byte {
+	b := []byte{0x90, 'h', 'v', 'c', '1'}
+	record := make([]byte, 23)
+	record[0] = 1
+	record[21] = 0x03
+	record[22] = byte(len(nalus))
+	for _, nal := range nalus {
+		record = append(record, nal[0]>>1&0x3f, 0x00, 0x01)
+		record = binary.BigEndian.AppendUint16(record, uint16(len(nal)))
+		record = append(record, nal...)
+	}
+	return append(b, record...)
+}
+
+func TestParseVideoSequenceHeader(t *testing.T) {
+	// VPS中的level与SPS不同，优先使用VPS
+	vps40 := append([]byte(nil), hevcVPS...)
+	vps40[20] = 120
+
+	tests := []struct {
+		name    string
+		payload []byte
+		want    VideoInfo
+	}{
+		{"H264", avcSequenceHeader(avcSPS, avcPPS), VideoInfo{Codec: "H264", Width: 1280, Height: 720, Profile: "High", Level: "3.1"}},
+		{"HEVC", hevcSequenceHeader(hevcVPS, hevcSPS), VideoInfo{Codec: "HEVC", Width: 1280, Height: 720, Profile: "Main", Level: "3.1"}},
+		{"HEVC without VPS", hevcSequenceHeader(hevcSPS), VideoInfo{Codec: "HEVC", Width: 1280, Height: 720, Profile: "Main", Level: "3.1"}},
+		{"HEVC level from VPS", hevcSequenceHeader(vps40, hevcSPS), VideoInfo{Codec: "HEVC", Width: 1280, Height: 720, Profile: "Main", Level: "4.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseVideoSequenceHeader(tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseVideoSequenceHeaderInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    error
+	}{
+		{"not a sequence header", []byte{0x17, 0x01, 0x00, 0x00, 0x00}, ErrInvalidConfig},
+		{"unsupported codec", []byte{0x90, 'a', 'v', '0', '1', 0x81}, ErrUnsupportedCodec},
+		{"truncated AVC record", avcSequenceHeader(avcSPS, avcPPS)[:20], ErrInvalidConfig},
+		{"truncated AVC SPS", avcSequenceHeader(avcSPS[:6], avcPPS), ErrInvalidConfig},
+		{"HEVC without SPS", hevcSequenceHeader(hevcVPS), ErrInvalidConfig},
+		{"truncated HEVC SPS", hevcSequenceHeader(hevcVPS, hevcSPS[:20]), ErrInvalidConfig},
+		{"truncated HEVC VPS", hevcSequenceHeader(hevcVPS[:10], hevcSPS), ErrInvalidConfig},
+		{"truncated HEVC record", hevcSequenceHeader(hevcVPS, hevcSPS)[:40], ErrInvalidConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseVideoSequenceHeader(tt.payload); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAudioSequenceHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    AudioInfo
+	}{
+		{"AAC LC", []byte{0xaf, 0x00, 0x12, 0x10}, AudioInfo{Codec: "AAC", SampleRate: 44100, Channels: 2, Profile: "LC"}},
+		{"AAC LC 48kHz mono", []byte{0xaf, 0x00, 0x11, 0x88}, AudioInfo{Codec: "AAC", SampleRate: 48000, Channels: 1, Profile: "LC"}},
+		{"HE-AAC explicit SBR", []byte{0xaf, 0x00, 0x2b, 0x11, 0x88}, AudioInfo{Codec: "AAC", SampleRate: 48000, Channels: 2, Profile: "HE-AAC"}},
+		{"explicit sample rate", []byte{0xaf, 0x00, 0x17, 0x80, 0x5d, 0xc0, 0x10}, AudioInfo{Codec: "AAC", SampleRate: 48000, Channels: 2, Profile: "LC"}},
+		{"7.1 channels", []byte{0xaf, 0x00, 0x11, 0xb8}, AudioInfo{Codec: "AAC", SampleRate: 48000, Channels: 8, Profile: "LC"}},
+		{"MP3 from tag header", []byte{0x2f, 0xff, 0xfb}, AudioInfo{Codec: "MP3", SampleRate: 44100, Channels: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAudioSequenceHeader(tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAudioSequenceHeaderInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+	}{
+		{"empty", nil},
+		{"truncated AudioSpecificConfig", []byte{0xaf, 0x00, 0x12}},
+		{"reserved sample rate index", []byte{0xaf, 0x00, 0x16, 0x90}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseAudioSequenceHeader(tt.payload); !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("err = %v, want ErrInvalidConfig", err)
+			}
+		})
+	}
+}
+
+// nalus 生成长度字段为4字节的NALU序列
+func nalus(list ...[]byte) []byte {
+	var b []byte
+	for _, nal := range list {
+		n := len(nal)
+		b = append(b, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+		b = append(b, nal...)
+	}
+	return b
+}
+
+func TestNALULengthSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    int
+	}{
+		{"avc 4 bytes", []byte{0x17, 0, 0, 0, 0, 1, 0x64, 0, 0x1f, 0xff}, 4},
+		{"avc 2 bytes", []byte{0x17, 0, 0, 0, 0, 1, 0x64, 0, 0x1f, 0xfd}, 2},
+		{"hevc 1 byte", append([]byte{0x1c, 0, 0, 0, 0}, append(make([]byte, 21), 0xfc)...), 1},
+		{"not a sequence header", []byte{0x27, 1, 0, 0, 0, 1, 0x64, 0, 0x1f, 0xfd}, 4},
+		{"truncated", []byte{0x17, 0, 0, 0, 0, 1}, 4},
+	}
+	for _, tt := range tests {
+		if got := NALULengthSize(tt.payload); got != tt.want {
+			t.Errorf("%s: NALULengthSize = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsDisposable(t *testing.T) {
+	avc := []byte{0x27, 1, 0, 0, 0}
+	hevc := []byte{0x2c, 1, 0, 0, 0}
+	tests := []struct {
+		name    string
+		payload []byte
+		want    bool
+	}{
+		{"h263 disposable", []byte{0x32, 0}, true},
+		{"h263 inter", []byte{0x22, 0}, false},
+		{"avc non-reference slice", append(avc, nalus([]byte{0x01, 0x9a})...), true},
+		{"avc reference slice", append(avc, nalus([]byte{0x21, 0x9a})...), false},
+		{"avc sei and non-reference slices", append(avc, nalus([]byte{0x06, 0x05}, []byte{0x01, 0x9a}, []byte{0x01, 0x9b})...), true},
+		{"avc mixed slices", append(avc, nalus([]byte{0x01, 0x9a}, []byte{0x41, 0x9b})...), false},
+		{"avc without slices", append(avc, nalus([]byte{0x06, 0x05})...), false},
+		{"avc truncated nalu", append(avc, 0, 0, 0, 9, 0x01), false},
+		{"avc keyframe", append([]byte{0x17, 1, 0, 0, 0}, nalus([]byte{0x05, 0x88})...), false},
+		{"hevc sub-layer non-reference", append(hevc, nalus([]byte{0x00, 0x01, 0xaf})...), true},
+		{"hevc trail_r", append(hevc, nalus([]byte{0x02, 0x01, 0xaf})...), false},
+		{"sequence header", []byte{0x27, 0, 0, 0, 0}, false},
+	}
+	for _, tt := range tests {
+		if got := IsDisposable(tt.payload, 4); got != tt.want {
+			t.Errorf("%s: IsDisposable = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if IsDisposable(append(avc, nalus([]byte{0x01, 0x9a})...), 5) {
+		t.Error("IsDisposable accepted invalid length size")
+	}
+}
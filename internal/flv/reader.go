@@ -0,0 +1,84 @@
+package flv
+
+// FLV文件的读取
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrNotFLV = errors.New("not an FLV file")
+
+// Tag 一个FLV tag，Data 为tag body，与RTMP音视频消息的payload相同
+type Tag struct {
+	Type      uint8
+	Timestamp uint32 // 毫秒
+	Data      []byte
+}
+
+// Reader 按顺序读取FLV文件中的tag
+type Reader struct {
+	r        *bufio.Reader
+	HasAudio bool // 文件头中的标记，不一定准确
+	HasVideo bool
+}
+
+// NewReader 读取FLV文件头
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	if string(header[:3]) != "FLV" {
+		return nil, ErrNotFLV
+	}
+	offset := binary.BigEndian.Uint32(header[5:9])
+	if offset < 9 {
+		return nil, ErrNotFLV
+	}
+	// 跳过扩展的文件头和 PreviousTagSize0
+	if _, err := br.Discard(int(offset-9) + 4); err != nil {
+		return nil, err
+	}
+	return &Reader{
+		r:        br,
+		HasAudio: header[4]&0x04 != 0,
+		HasVideo: header[4]&0x01 != 0,
+	}, nil
+}
+
+// ReadTag 读取下一个tag，文件结束时返回 io.EOF
+func (r *Reader) ReadTag() (*Tag, error) {
+	header := make([]byte, 11)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// 文件末尾不完整的tag
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	if header[0]&0x20 != 0 {
+		return nil, fmt.Errorf("encrypted FLV tag is not supported")
+	}
+	size := uint32(header[1])<<16 | uint32(header[2])<<8 | uint32(header[3])
+	tag := &Tag{
+		Type:      header[0] & 0x1f,
+		Timestamp: uint32(header[7])<<24 | uint32(header[4])<<16 | uint32(header[5])<<8 | uint32(header[6]),
+		Data:      make([]byte, size),
+	}
+	if _, err := io.ReadFull(r.r, tag.Data); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	// PreviousTagSize
+	if _, err := r.r.Discard(4); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return tag, nil
+}
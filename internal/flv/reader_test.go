@@ -0,0 +1,92 @@
+package flv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// buildFile 生成包含tags的FLV文件
+func buildFile(tags ...Tag) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{'F', 'L', 'V', 1, 0x05, 0, 0, 0, 9, 0, 0, 0, 0})
+	for _, tag := range tags {
+		size := len(tag.Data)
+		b.Write([]byte{
+			tag.Type, byte(size >> 16), byte(size >> 8), byte(size),
+			byte(tag.Timestamp >> 16), byte(tag.Timestamp >> 8), byte(tag.Timestamp), byte(tag.Timestamp >> 24),
+			0, 0, 0,
+		})
+		b.Write(tag.Data)
+		total := size + 11
+		b.Write([]byte{byte(total >> 24), byte(total >> 16), byte(total >> 8), byte(total)})
+	}
+	return b.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	tags := []Tag{
+		{Type: TagScript, Timestamp: 0, Data: []byte{2, 0, 10}},
+		{Type: TagVideo, Timestamp: 0, Data: []byte{0x17, 0, 0, 0, 0}},
+		{Type: TagAudio, Timestamp: 23, Data: []byte{0xaf, 1, 0x21}},
+		{Type: TagVideo, Timestamp: 0x01000040, Data: []byte{0x27, 1, 0, 0, 0}},
+	}
+	r, err := NewReader(bytes.NewReader(buildFile(tags...)))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if !r.HasAudio || !r.HasVideo {
+		t.Errorf("HasAudio/HasVideo = %v/%v, want true/true", r.HasAudio, r.HasVideo)
+	}
+	for _, want := range tags {
+		tag, err := r.ReadTag()
+		if err != nil {
+			t.Fatalf("ReadTag: %v", err)
+		}
+		if !reflect.DeepEqual(*tag, want) {
+			t.Errorf("tag = %+v, want %+v", *tag, want)
+		}
+	}
+	if _, err := r.ReadTag(); !errors.Is(err, io.EOF) {
+		t.Errorf("ReadTag at end = %v, want EOF", err)
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	file := buildFile(
+		Tag{Type: TagVideo, Data: []byte{0x17, 1, 0, 0, 0}},
+		Tag{Type: TagVideo, Timestamp: 40, Data: []byte{0x27, 1, 0, 0, 0}},
+	)
+	// 文件末尾不完整的tag按文件结束处理
+	r, err := NewReader(bytes.NewReader(file[:len(file)-6]))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if _, err := r.ReadTag(); err != nil {
+		t.Fatalf("ReadTag: %v", err)
+	}
+	if _, err := r.ReadTag(); !errors.Is(err, io.EOF) {
+		t.Errorf("ReadTag on truncated tag = %v, want EOF", err)
+	}
+}
+
+func TestReaderNotFLV(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("GIF89a\x00\x00\x00\x00\x00\x00\x00"))); !errors.Is(err, ErrNotFLV) {
+		t.Errorf("NewReader = %v, want ErrNotFLV", err)
+	}
+	if _, err := NewReader(bytes.NewReader([]byte{'F', 'L', 'V', 1, 5, 0, 0, 0, 5})); !errors.Is(err, ErrNotFLV) {
+		t.Errorf("NewReader with short header offset = %v, want ErrNotFLV", err)
+	}
+}
+
+func TestReaderEncrypted(t *testing.T) {
+	r, err := NewReader(bytes.NewReader(buildFile(Tag{Type: TagVideo | 0x20, Data: []byte{0x17}})))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if _, err := r.ReadTag(); err == nil {
+		t.Error("ReadTag of encrypted tag succeeded")
+	}
+}
@@ -0,0 +1,267 @@
+package flv
+
+// FLV音视频tag header的解析，RTMP音视频消息的payload与FLV tag body相同，兼容Enhanced RTMP
+
+// FLV tag类型，与RTMP消息类型相同
+const (
+	TagAudio  = 8
+	TagVideo  = 9
+	TagScript = 18
+)
+
+// 视频帧类型
+const (
+	FrameKey        = 1
+	FrameInter      = 2
+	FrameDisposable = 3 // 可丢弃的非参考帧，仅H.263
+	FrameGenerated  = 4
+	FrameCommand    = 5 // 视频信息或命令帧，不是实际的视频帧
+)
+
+// 传统视频CodecID
+const (
+	CodecH263    = 2
+	CodecScreen  = 3
+	CodecVP6     = 4
+	CodecVP6A    = 5
+	CodecScreen2 = 6
+	CodecAVC     = 7
+	CodecHEVC    = 12 // 国内常用的非标准扩展
+)
+
+// AVC/HEVC的AVCPacketType
+const (
+	AVCSequenceHeader = 0
+	AVCNALU           = 1
+	AVCEndOfSequence  = 2
+)
+
+// Enhanced RTMP视频的PacketType
+const (
+	PacketSequenceStart = 0
+	PacketCodedFrames   = 1
+	PacketSequenceEnd   = 2
+	PacketCodedFramesX  = 3
+	PacketMetadata      = 4
+)
+
+// 音频SoundFormat
+const (
+	SoundLPCM     = 0
+	SoundADPCM    = 1
+	SoundMP3      = 2
+	SoundLPCMLE   = 3
+	SoundG711A    = 7
+	SoundG711U    = 8
+	SoundExHeader = 9 // Enhanced RTMP
+	SoundAAC      = 10
+	SoundSpeex    = 11
+	SoundMP38k    = 14
+)
+
+// AACPacketType
+const (
+	AACSequenceHeader = 0
+	AACRaw            = 1
+)
+
+// VideoTag 视频tag header
+type VideoTag struct {
+	FrameType  uint8
+	CodecID    uint8  // 传统格式的CodecID
+	FourCC     string // Enhanced RTMP的FourCC，例如 avc1/hvc1/av01/vp09
+	Enhanced   bool
+	PacketType uint8 // AVCPacketType 或 Enhanced RTMP 的 PacketType
+	HeaderSize int   // tag header的长度，之后为编码数据
+}
+
+// ParseVideoTag 解析视频消息的tag header，payload过短时返回false
+func ParseVideoTag(payload []byte) (VideoTag, bool) {
+	if len(payload) < 1 {
+		return VideoTag{}, false
+	}
+	var t VideoTag
+	if payload[0]&0x80 != 0 {
+		if len(payload) < 5 {
+			return VideoTag{}, false
+		}
+		t.Enhanced = true
+		t.FrameType = (payload[0] >> 4) & 0x07
+		t.PacketType = payload[0] & 0x0f
+		t.FourCC = string(payload[1:5])
+		t.HeaderSize = 5
+		if t.PacketType == PacketCodedFrames && t.FourCC != "av01" && t.FourCC != "vp09" {
+			// avc1/hvc1 的 CodedFrames 带有3字节的CompositionTime
+			t.HeaderSize += 3
+		}
+		return t, true
+	}
+	t.FrameType = payload[0] >> 4
+	t.CodecID = payload[0] & 0x0f
+	t.HeaderSize = 1
+	if t.CodecID == CodecAVC || t.CodecID == CodecHEVC {
+		if len(payload) < 5 {
+			return VideoTag{}, false
+		}
+		t.PacketType = payload[1]
+		t.HeaderSize = 5
+	}
+	return t, true
+}
+
+// IsSequenceHeader 是否为AVC/HEVC等编码的sequence header
+func (t VideoTag) IsSequenceHeader() bool {
+	if t.Enhanced {
+		return t.PacketType == PacketSequenceStart
+	}
+	return (t.CodecID == CodecAVC || t.CodecID == CodecHEVC) && t.PacketType == AVCSequenceHeader
+}
+
+// IsKeyframe 是否为关键帧，sequence header 也标记为关键帧
+func (t VideoTag) IsKeyframe() bool {
+	return t.FrameType == FrameKey
+}
+
+// IsFrame 是否包含实际的视频帧，不包括sequence header和命令帧
+func (t VideoTag) IsFrame() bool {
+	if t.FrameType == FrameCommand {
+		return false
+	}
+	if t.Enhanced {
+		return t.PacketType == PacketCodedFrames || t.PacketType == PacketCodedFramesX
+	}
+	if t.CodecID == CodecAVC || t.CodecID == CodecHEVC {
+		return t.PacketType == AVCNALU
+	}
+	return true
+}
+
+// Codec 视频编码名称
+func (t VideoTag) Codec() string {
+	if t.Enhanced {
+		switch t.FourCC {
+		case "avc1":
+			return "H264"
+		case "hvc1":
+			return "HEVC"
+		case "av01":
+			return "AV1"
+		case "vp09":
+			return "VP9"
+		}
+		return t.FourCC
+	}
+	switch t.CodecID {
+	case CodecH263:
+		return "H263"
+	case CodecScreen, CodecScreen2:
+		return "Screen"
+	case CodecVP6, CodecVP6A:
+		return "VP6"
+	case CodecAVC:
+		return "H264"
+	case CodecHEVC:
+		return "HEVC"
+	}
+	return "unknown"
+}
+
+// AudioTag 音频tag header
+type AudioTag struct {
+	SoundFormat uint8
+	SoundRate   uint8 // 0: 5.5kHz 1: 11kHz 2: 22kHz 3: 44kHz
+	SoundSize   uint8 // 0: 8bit 1: 16bit
+	SoundType   uint8 // 0: mono 1: stereo
+	FourCC      string
+	PacketType  uint8 // AACPacketType 或 Enhanced RTMP 的 AudioPacketType
+	HeaderSize  int
+}
+
+// ParseAudioTag 解析音频消息的tag header，payload过短时返回false
+func ParseAudioTag(payload []byte) (AudioTag, bool) {
+	if len(payload) < 1 {
+		return AudioTag{}, false
+	}
+	t := AudioTag{
+		SoundFormat: payload[0] >> 4,
+		SoundRate:   (payload[0] >> 2) & 0x03,
+		SoundSize:   (payload[0] >> 1) & 0x01,
+		SoundType:   payload[0] & 0x01,
+		HeaderSize:  1,
+	}
+	switch t.SoundFormat {
+	case SoundAAC:
+		if len(payload) < 2 {
+			return AudioTag{}, false
+		}
+		t.PacketType = payload[1]
+		t.HeaderSize = 2
+	case SoundExHeader:
+		if len(payload) < 5 {
+			return AudioTag{}, false
+		}
+		t.PacketType = payload[0] & 0x0f
+		t.FourCC = string(payload[1:5])
+		t.HeaderSize = 5
+	}
+	return t, true
+}
+
+// IsSequenceHeader 是否为AAC等编码的sequence header
+func (t AudioTag) IsSequenceHeader() bool {
+	switch t.SoundFormat {
+	case SoundAAC:
+		return t.PacketType == AACSequenceHeader
+	case SoundExHeader:
+		return t.PacketType == PacketSequenceStart
+	}
+	return false
+}
+
+// IsFrame 是否包含实际的音频数据
+func (t AudioTag) IsFrame() bool {
+	switch t.SoundFormat {
+	case SoundAAC:
+		return t.PacketType == AACRaw
+	case SoundExHeader:
+		return t.PacketType == PacketCodedFrames
+	}
+	return true
+}
+
+// Codec 音频编码名称
+func (t AudioTag) Codec() string {
+	switch t.SoundFormat {
+	case SoundLPCM, SoundLPCMLE:
+		return "LPCM"
+	case SoundADPCM:
+		return "ADPCM"
+	case SoundMP3, SoundMP38k:
+		return "MP3"
+	case SoundG711A:
+		return "G711A"
+	case SoundG711U:
+		return "G711U"
+	case SoundAAC:
+		return "AAC"
+	case SoundSpeex:
+		return "Speex"
+	case SoundExHeader:
+		switch t.FourCC {
+		case "Opus":
+			return "Opus"
+		case "fLaC":
+			return "FLAC"
+		case "ac-3":
+			return "AC3"
+		case "ec-3":
+			return "EAC3"
+		case "mp4a":
+			return "AAC"
+		case ".mp3":
+			return "MP3"
+		}
+		return t.FourCC
+	}
+	return "unknown"
+}
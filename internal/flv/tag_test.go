@@ -0,0 +1,103 @@
+package flv
+
+import "testing"
+
+func TestParseVideoTag(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  []byte
+		codec    string
+		header   int
+		sequence bool
+		keyframe bool
+		frame    bool
+	}{
+		{"avc sequence header", []byte{0x17, AVCSequenceHeader, 0, 0, 0, 1}, "H264", 5, true, true, false},
+		{"avc keyframe", []byte{0x17, AVCNALU, 0, 0, 0, 1}, "H264", 5, false, true, true},
+		{"avc inter frame", []byte{0x27, AVCNALU, 0, 0, 0, 1}, "H264", 5, false, false, true},
+		{"hevc keyframe", []byte{0x1c, AVCNALU, 0, 0, 0}, "HEVC", 5, false, true, true},
+		{"vp6 inter frame", []byte{0x24, 0}, "VP6", 1, false, false, true},
+		{"command frame", []byte{0x57, AVCNALU, 0, 0, 0}, "H264", 5, false, false, false},
+		{"enhanced sequence start", []byte{0x90 | PacketSequenceStart, 'h', 'v', 'c', '1'}, "HEVC", 5, true, true, false},
+		{"enhanced coded frames", []byte{0x90 | PacketCodedFrames, 'h', 'v', 'c', '1', 0, 0, 0}, "HEVC", 8, false, true, true},
+		{"enhanced coded frames x", []byte{0xa0 | PacketCodedFramesX, 'a', 'v', 'c', '1'}, "H264", 5, false, false, true},
+		{"enhanced av1 without composition time", []byte{0x90 | PacketCodedFrames, 'a', 'v', '0', '1'}, "AV1", 5, false, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tag, ok := ParseVideoTag(tt.payload)
+			if !ok {
+				t.Fatal("ParseVideoTag failed")
+			}
+			if tag.Codec() != tt.codec {
+				t.Errorf("codec = %s, want %s", tag.Codec(), tt.codec)
+			}
+			if tag.HeaderSize != tt.header {
+				t.Errorf("header size = %d, want %d", tag.HeaderSize, tt.header)
+			}
+			if tag.IsSequenceHeader() != tt.sequence {
+				t.Errorf("sequence header = %v, want %v", tag.IsSequenceHeader(), tt.sequence)
+			}
+			if tag.IsKeyframe() != tt.keyframe {
+				t.Errorf("keyframe = %v, want %v", tag.IsKeyframe(), tt.keyframe)
+			}
+			if tag.IsFrame() != tt.frame {
+				t.Errorf("frame = %v, want %v", tag.IsFrame(), tt.frame)
+			}
+		})
+	}
+}
+
+func TestParseVideoTagShort(t *testing.T) {
+	for _, payload := range [][]byte{nil, {0x17, 1}, {0x90, 'h', 'v'}} {
+		if _, ok := ParseVideoTag(payload); ok {
+			t.Errorf("ParseVideoTag(%x) succeeded", payload)
+		}
+	}
+}
+
+func TestParseAudioTag(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  []byte
+		codec    string
+		header   int
+		sequence bool
+		frame    bool
+	}{
+		{"aac sequence header", []byte{0xaf, AACSequenceHeader, 0x12, 0x10}, "AAC", 2, true, false},
+		{"aac raw", []byte{0xaf, AACRaw, 0x21}, "AAC", 2, false, true},
+		{"mp3", []byte{0x2f, 0xff}, "MP3", 1, false, true},
+		{"g711a", []byte{0x72}, "G711A", 1, false, true},
+		{"enhanced opus sequence start", []byte{0x90 | PacketSequenceStart, 'O', 'p', 'u', 's'}, "Opus", 5, true, false},
+		{"enhanced opus coded frames", []byte{0x90 | PacketCodedFrames, 'O', 'p', 'u', 's', 0}, "Opus", 5, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tag, ok := ParseAudioTag(tt.payload)
+			if !ok {
+				t.Fatal("ParseAudioTag failed")
+			}
+			if tag.Codec() != tt.codec {
+				t.Errorf("codec = %s, want %s", tag.Codec(), tt.codec)
+			}
+			if tag.HeaderSize != tt.header {
+				t.Errorf("header size = %d, want %d", tag.HeaderSize, tt.header)
+			}
+			if tag.IsSequenceHeader() != tt.sequence {
+				t.Errorf("sequence header = %v, want %v", tag.IsSequenceHeader(), tt.sequence)
+			}
+			if tag.IsFrame() != tt.frame {
+				t.Errorf("frame = %v, want %v", tag.IsFrame(), tt.frame)
+			}
+		})
+	}
+}
+
+func TestParseAudioTagShort(t *testing.T) {
+	for _, payload := range [][]byte{nil, {0xaf}, {0x90, 'O', 'p'}} {
+		if _, ok := ParseAudioTag(payload); ok {
+			t.Errorf("ParseAudioTag(%x) succeeded", payload)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package flv
+
+// FLV文件的写入
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+// Continue 之后的tag与之前写入的最后一个时间戳之间的间隔，毫秒
+const continueGap = 40
+
+// Writer 按顺序写入FLV tag，可以在多个goroutine中使用
+type Writer struct {
+	mu      sync.Mutex
+	w       io.Writer
+	offset  uint32 // 写入时加到tag时间戳上的偏移，见 Continue
+	rebase  bool   // 下一个tag重新计算offset
+	last    uint32 // 已写入的最大时间戳
+	written bool
+}
+
+// NewWriter 写入FLV文件头，hasAudio/hasVideo 为文件头中的标记
+func NewWriter(w io.Writer, hasAudio bool, hasVideo bool) (*Writer, error) {
+	var flags byte
+	if hasAudio {
+		flags |= 0x04
+	}
+	if hasVideo {
+		flags |= 0x01
+	}
+	// 文件头和 PreviousTagSize0
+	header := []byte{'F', 'L', 'V', 1, flags, 0, 0, 0, 9, 0, 0, 0, 0}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+// Continue 之后写入的tag接在已写入的最后一个时间戳之后，用于先后写入同一个文件的多路推流，
+// 每路推流的时间戳从0开始时文件中的时间戳仍然单调
+func (w *Writer) Continue() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.rebase = true
+}
+
+// WriteTag 写入一个tag，tag body 与 PreviousTagSize 在一次写入中完成
+func (w *Writer) WriteTag(tag *Tag) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.rebase {
+		w.rebase = false
+		if w.written {
+			w.offset = w.last + continueGap - tag.Timestamp
+		}
+	}
+	timestamp := tag.Timestamp + w.offset
+	if !w.written || int32(timestamp-w.last) > 0 {
+		w.last = timestamp
+	}
+	w.written = true
+
+	size := len(tag.Data)
+	buf := make([]byte, 11+size+4)
+	buf[0] = tag.Type
+	buf[1], buf[2], buf[3] = byte(size>>16), byte(size>>8), byte(size)
+	buf[4], buf[5], buf[6], buf[7] = byte(timestamp>>16), byte(timestamp>>8), byte(timestamp), byte(timestamp>>24)
+	copy(buf[11:], tag.Data)
+	binary.BigEndian.PutUint32(buf[11+size:], uint32(11+size))
+	_, err := w.w.Write(buf)
+	return err
+}
@@ -0,0 +1,93 @@
+package flv
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func readTags(t *testing.T, data []byte) []*Tag {
+	t.Helper()
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.HasAudio || !r.HasVideo {
+		t.Errorf("header flags audio=%v video=%v", r.HasAudio, r.HasVideo)
+	}
+	var tags []*Tag
+	for {
+		tag, err := r.ReadTag()
+		if err == io.EOF {
+			return tags
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		tags = append(tags, tag)
+	}
+}
+
+func TestWriterReader(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Tag{
+		{Type: TagScript, Timestamp: 0, Data: []byte{0x02, 0x00, 0x00}},
+		{Type: TagVideo, Timestamp: 0x1000021, Data: []byte{0x17, 0x01, 0x00, 0x00, 0x00}},
+		{Type: TagAudio, Timestamp: 0x1000021, Data: []byte{0xaf, 0x01}},
+	}
+	for _, tag := range want {
+		if err = w.WriteTag(tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tags := readTags(t, buf.Bytes())
+	if len(tags) != len(want) {
+		t.Fatalf("read %d tags, want %d", len(tags), len(want))
+	}
+	for i, tag := range tags {
+		if tag.Type != want[i].Type || tag.Timestamp != want[i].Timestamp || !bytes.Equal(tag.Data, want[i].Data) {
+			t.Errorf("tag %d = type %d at %d, want type %d at %d", i, tag.Type, tag.Timestamp, want[i].Type, want[i].Timestamp)
+		}
+	}
+}
+
+func TestWriterContinue(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(timestamps ...uint32) {
+		for _, ts := range timestamps {
+			if err := w.WriteTag(&Tag{Type: TagVideo, Timestamp: ts, Data: []byte{0x27}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// 第一路推流之前调用 Continue 不改变时间戳
+	w.Continue()
+	write(0, 33, 66)
+	// 之后的推流时间戳从0开始，接在之前写入的之后
+	w.Continue()
+	write(0, 33)
+	w.Continue()
+	write(500, 533)
+
+	var got []uint32
+	for _, tag := range readTags(t, buf.Bytes()) {
+		got = append(got, tag.Timestamp)
+	}
+	want := []uint32{0, 33, 66, 106, 139, 179, 212}
+	if len(got) != len(want) {
+		t.Fatalf("timestamps = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("timestamps = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package logging
+
+// 基于 log/slog 的日志，输出前隐藏推流密钥、代理密码和Cookie等敏感信息
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 短于该长度的值不作为敏感信息，避免误替换普通文本
+const minSecretLength = 4
+
+const redacted = "***"
+
+// secrets 需要隐藏的字符串集合
+type secrets struct {
+	mu       sync.RWMutex
+	values   map[string]struct{}
+	replacer *strings.Replacer
+}
+
+func (s *secrets) add(values ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = make(map[string]struct{})
+	}
+	for _, v := range values {
+		if len(v) >= minSecretLength {
+			s.values[v] = struct{}{}
+		}
+	}
+	// 较长的值优先替换，避免只替换掉其中一部分
+	list := make([]string, 0, len(s.values))
+	for v := range s.values {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool { return len(list[i]) > len(list[j]) })
+	pairs := make([]string, 0, len(list)*2)
+	for _, v := range list {
+		pairs = append(pairs, v, redacted)
+	}
+	s.replacer = strings.NewReplacer(pairs...)
+}
+
+func (s *secrets) redact(v string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.replacer == nil {
+		return v
+	}
+	return s.replacer.Replace(v)
+}
+
+// 全局的敏感信息，例如配置的推流密钥和代理密码
+var global = &secrets{}
+
+// AddSecret 添加需要在所有日志中隐藏的值
+func AddSecret(values ...string) {
+	global.add(values...)
+}
+
+// AddLinkSecret 添加地址中的密码、streamName(推流密钥)和参数
+func AddLinkSecret(link string) {
+	global.add(linkSecrets(link)...)
+}
+
+// AddCookieSecret 添加Cookie字符串以及其中每一项的值
+func AddCookieSecret(cookies string) {
+	values := []string{cookies}
+	for _, item := range strings.Split(cookies, ";") {
+		if _, v, ok := strings.Cut(item, "="); ok {
+			values = append(values, strings.TrimSpace(v))
+		}
+	}
+	global.add(values...)
+}
+
+func linkSecrets(link string) []string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil
+	}
+	var values []string
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			values = append(values, pass)
+		}
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) > 1 {
+		values = append(values, strings.Join(parts[1:], "/"))
+	}
+	if u.RawQuery != "" {
+		values = append(values, u.RawQuery)
+	}
+	return values
+}
+
+// Handler 隐藏敏感信息后交给下一个 slog.Handler 输出
+type Handler struct {
+	next   slog.Handler
+	scoped *secrets // 只在当前logger中隐藏的值，例如单个会话的推流密钥
+}
+
+func NewHandler(next slog.Handler) *Handler {
+	return &Handler{next: next}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	record := slog.NewRecord(r.Time, r.Level, h.redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		record.AddAttrs(h.redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, record)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redactedAttrs := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redactedAttrs[i] = h.redactAttr(a)
+	}
+	return &Handler{next: h.next.WithAttrs(redactedAttrs), scoped: h.scoped}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name), scoped: h.scoped}
+}
+
+func (h *Handler) redact(v string) string {
+	v = global.redact(v)
+	if h.scoped != nil {
+		v = h.scoped.redact(v)
+	}
+	return v
+}
+
+// redactAttr 隐藏字符串、error以及其它值格式化后的文本中的敏感信息
+func (h *Handler) redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, h.redact(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		attrs := make([]any, len(group))
+		for i, ga := range group {
+			attrs[i] = h.redactAttr(ga)
+		}
+		return slog.Group(a.Key, attrs...)
+	case slog.KindAny:
+		var text string
+		if err, ok := v.Any().(error); ok {
+			text = err.Error()
+		} else {
+			text = fmt.Sprint(v.Any())
+		}
+		if r := h.redact(text); r != text {
+			return slog.String(a.Key, r)
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// Scoped 返回带有独立敏感信息集合的logger，以及向该集合添加值的函数
+func Scoped(l *slog.Logger) (*slog.Logger, func(values ...string)) {
+	s := &secrets{}
+	h, ok := l.Handler().(*Handler)
+	if !ok {
+		h = NewHandler(l.Handler())
+	}
+	return slog.New(&Handler{next: h.next, scoped: s}), s.add
+}
+
+// Setup 设置默认logger，format 为 text 或 json，level 为 debug/info/warn/error
+func Setup(w io.Writer, format string, level string) error {
+	var lv slog.Level
+	if err := lv.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lv}
+	var next slog.Handler
+	switch format {
+	case "text":
+		next = slog.NewTextHandler(w, opts)
+	case "json":
+		next = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+	slog.SetDefault(slog.New(NewHandler(next)))
+	return nil
+}
@@ -0,0 +1,122 @@
+package metrics
+
+// Prometheus 指标，通过 -metrics 指定的地址以 /metrics 暴露
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+const namespace = "rtmpproxy"
+
+var (
+	// ActiveSessions 正在推流的会话数
+	ActiveSessions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_sessions",
+		Help:      "Number of sessions currently publishing to a remote server.",
+	}, []string{"route", "remote_host"})
+
+	// Bytes 各方向传输的字节数，direction 为 client_in/client_out/remote_in/remote_out
+	Bytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bytes_total",
+		Help:      "Bytes transferred per direction.",
+	}, []string{"direction", "route", "remote_host"})
+
+	// Messages 客户端发来的RTMP消息数，按type id统计
+	Messages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_total",
+		Help:      "RTMP messages received from clients by message type id.",
+	}, []string{"type", "route", "remote_host"})
+
+	// PublishBitrate 发往远程服务器的码率
+	PublishBitrate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "publish_bitrate_bits",
+		Help:      "Current bitrate sent to remote servers in bits per second.",
+	}, []string{"route", "remote_host"})
+
+	// DialDuration 连接远程服务器(包括socks5代理)的耗时
+	DialDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "dial_duration_seconds",
+		Help:      "Time to establish TCP connections to remote servers.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"remote_host"})
+
+	// TLSDuration 与远程服务器TLS握手的耗时
+	TLSDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tls_handshake_duration_seconds",
+		Help:      "Time of TLS handshakes with remote servers.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"remote_host"})
+
+	// HandshakeDuration RTMP握手耗时，side 为client(握手)或remote(握手、connect和publish)
+	HandshakeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rtmp_handshake_duration_seconds",
+		Help:      "Time of RTMP handshakes with clients and remote servers.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"side"})
+
+	// StreamInfo 按编码参数统计正在推流的会话数
+	StreamInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "stream_info",
+		Help:      "Number of publishing sessions by codec parameters decoded from sequence headers.",
+	}, []string{"route", "video_codec", "resolution", "video_profile", "audio_codec", "sample_rate", "channels"})
+
+	// ProfileViolations 推流参数超出 -profile 限制的次数，每个会话的每条规则只统计一次
+	ProfileViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "profile_violations_total",
+		Help:      "Sessions whose stream parameters exceeded the configured platform profile.",
+	}, []string{"route", "rule"})
+
+	// HealthEvents 检测到的推流问题次数
+	HealthEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "health_events_total",
+		Help:      "Stream health problems detected by the watchdog.",
+	}, []string{"route", "kind"})
+
+	// UnhealthySessions 存在尚未恢复的问题的会话数
+	UnhealthySessions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "unhealthy_sessions",
+		Help:      "Sessions with an unresolved stream health problem.",
+	}, []string{"route", "kind"})
+
+	// UpstreamReconnects 会话中重新连接远程服务器的次数
+	UpstreamReconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_reconnects_total",
+		Help:      "Times a session re-established its remote server connection.",
+	}, []string{"route", "remote_host"})
+
+	// DroppedFrames 远程服务器拥塞时发送队列丢弃的视频帧数，reason 为 disposable 或 gop
+	DroppedFrames = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dropped_frames_total",
+		Help:      "Video frames dropped because the remote server could not keep up.",
+	}, []string{"route", "reason"})
+
+	// HookFailures 插件事件失败次数，包括重试
+	HookFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hook_failures_total",
+		Help:      "Plugin hook failures including retries.",
+	}, []string{"hook"})
+)
+
+// Serve 在addr上提供 /metrics
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(addr, mux)
+}
@@ -1,10 +1,13 @@
 package plugins
 
+import "rtmpproxy/internal"
+
 type Interceptor interface {
-	ApplicationStart() error             // 应用启动时的动作
-	BeforeEstablishTCPConnection() error // TCP连接前的动作
-	AfterRTMPHandshake() error           // RTMP握手后的动作
-	AfterCloseTCPConnection() error      // TCP连接断开后的动作
+	ApplicationStart() error                                // 应用启动时的动作
+	BeforeEstablishTCPConnection(s *internal.Session) error // TCP连接前的动作
+	AfterRTMPHandshake(s *internal.Session) error           // RTMP握手后的动作
+	AfterCloseTCPConnection(s *internal.Session) error      // TCP连接断开后的动作
+	ApplicationStop() error                                 // 应用退出前的动作，所有会话均已结束
 }
 
 type DefaultInterceptor struct{}
@@ -13,14 +16,88 @@ func (i *DefaultInterceptor) ApplicationStart() error {
 	return nil
 }
 
-func (i *DefaultInterceptor) BeforeEstablishTCPConnection() error {
+func (i *DefaultInterceptor) BeforeEstablishTCPConnection(s *internal.Session) error {
+	return nil
+}
+
+func (i *DefaultInterceptor) AfterRTMPHandshake(s *internal.Session) error {
+	return nil
+}
+
+func (i *DefaultInterceptor) AfterCloseTCPConnection(s *internal.Session) error {
+	return nil
+}
+
+func (i *DefaultInterceptor) ApplicationStop() error {
+	return nil
+}
+
+// Chain 按顺序调用多个插件，遇到第一个错误即返回
+type Chain []Interceptor
+
+func (c Chain) ApplicationStart() error {
+	for _, i := range c {
+		if err := i.ApplicationStart(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (i *DefaultInterceptor) AfterRTMPHandshake() error {
+func (c Chain) BeforeEstablishTCPConnection(s *internal.Session) error {
+	for _, i := range c {
+		if err := i.BeforeEstablishTCPConnection(s); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (i *DefaultInterceptor) AfterCloseTCPConnection() error {
+func (c Chain) AfterRTMPHandshake(s *internal.Session) error {
+	for _, i := range c {
+		if err := i.AfterRTMPHandshake(s); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// AfterCloseTCPConnection 连接已断开，所有插件都会被调用，返回第一个错误
+func (c Chain) AfterCloseTCPConnection(s *internal.Session) error {
+	var first error
+	for _, i := range c {
+		if err := i.AfterCloseTCPConnection(s); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// ApplicationStop 应用即将退出，所有插件都会被调用，返回第一个错误
+func (c Chain) ApplicationStop() error {
+	var first error
+	for _, i := range c {
+		if err := i.ApplicationStop(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// OnStats 转发给实现了 StatsObserver 的插件
+func (c Chain) OnStats(s *internal.Session, stats internal.StreamStats) {
+	for _, i := range c {
+		if o, ok := i.(StatsObserver); ok {
+			o.OnStats(s, stats)
+		}
+	}
+}
+
+// OnHealth 转发给实现了 HealthObserver 的插件
+func (c Chain) OnHealth(s *internal.Session, event internal.HealthEvent) {
+	for _, i := range c {
+		if o, ok := i.(HealthObserver); ok {
+			o.OnHealth(s, event)
+		}
+	}
+}
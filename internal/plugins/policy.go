@@ -0,0 +1,104 @@
+package plugins
+
+// 插件事件失败时的处理策略
+
+import (
+	"fmt"
+	"log/slog"
+	"rtmpproxy/internal/metrics"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 使用处理策略的插件事件，也是事件失败时会话错误的阶段
+const (
+	StageBeforeEstablishTCPConnection = "BeforeEstablishTCPConnection"
+	StageAfterRTMPHandshake           = "AfterRTMPHandshake"
+	StageAfterCloseTCPConnection      = "AfterCloseTCPConnection"
+)
+
+type Action int
+
+const (
+	ActionAbort    Action = iota // 结束当前会话
+	ActionContinue               // 记录错误后继续
+	ActionRetry                  // 重试，全部失败后结束当前会话
+)
+
+// 重试间隔，第n次重试等待 n*retryDelay
+const retryDelay = time.Second
+
+type Policy struct {
+	Action  Action
+	Retries int // ActionRetry 的重试次数
+}
+
+// Policies 事件名称到处理策略，未配置的事件使用 ActionAbort
+type Policies map[string]Policy
+
+// ParsePolicies 解析 "事件=abort|continue|retry[:次数]" 的逗号分隔列表，
+// 例如 "BeforeEstablishTCPConnection=retry:3,AfterRTMPHandshake=continue"，事件名称写错时返回错误
+func ParsePolicies(s string) (Policies, error) {
+	policies := Policies{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		hook, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid hook policy: %q", item)
+		}
+		hook = strings.TrimSpace(hook)
+		switch hook {
+		case StageBeforeEstablishTCPConnection, StageAfterRTMPHandshake, StageAfterCloseTCPConnection:
+		default:
+			return nil, fmt.Errorf("unknown hook in policy: %q", item)
+		}
+		action, retries, _ := strings.Cut(value, ":")
+		var p Policy
+		switch action {
+		case "abort":
+			p.Action = ActionAbort
+		case "continue":
+			p.Action = ActionContinue
+		case "retry":
+			p.Action, p.Retries = ActionRetry, 3
+			if retries != "" {
+				n, err := strconv.Atoi(retries)
+				if err != nil || n < 1 {
+					return nil, fmt.Errorf("invalid retry count: %q", item)
+				}
+				p.Retries = n
+			}
+		default:
+			return nil, fmt.Errorf("unknown hook policy action: %q", item)
+		}
+		policies[hook] = p
+	}
+	return policies, nil
+}
+
+// Call 按照事件的处理策略调用fn，只有需要结束会话时才返回错误
+func (p Policies) Call(logger *slog.Logger, hook string, fn func() error) error {
+	policy := p[hook]
+	call := func() error {
+		err := fn()
+		if err != nil {
+			metrics.HookFailures.WithLabelValues(hook).Inc()
+		}
+		return err
+	}
+	err := call()
+	for attempt := 1; err != nil && policy.Action == ActionRetry && attempt <= policy.Retries; attempt++ {
+		logger.Warn("Interceptor failed, retrying", "hook", hook, "err", err, "attempt", attempt, "retries", policy.Retries)
+		time.Sleep(time.Duration(attempt) * retryDelay)
+		err = call()
+	}
+	if err != nil && policy.Action == ActionContinue {
+		logger.Warn("Interceptor failed, continuing", "hook", hook, "err", err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package plugins
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestParsePolicies(t *testing.T) {
+	got, err := ParsePolicies(" BeforeEstablishTCPConnection=retry:2, AfterRTMPHandshake=continue,AfterCloseTCPConnection=retry,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Policies{
+		StageBeforeEstablishTCPConnection: {Action: ActionRetry, Retries: 2},
+		StageAfterRTMPHandshake:           {Action: ActionContinue},
+		StageAfterCloseTCPConnection:      {Action: ActionRetry, Retries: 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParsePolicies = %v, want %v", got, want)
+	}
+	if got, err = ParsePolicies(""); err != nil || len(got) != 0 {
+		t.Errorf("empty policies = %v, %v", got, err)
+	}
+
+	for _, s := range []string{
+		"AfterRTMPHandshake",
+		"AfterRTMPHandshake=skip",
+		"AfterRTMPHandshake=retry:0",
+		"AfterRTMPHandshake=retry:x",
+		"AfterRtmpHandshake=continue",
+		"ApplicationStop=continue",
+	} {
+		if _, err := ParsePolicies(s); err == nil {
+			t.Errorf("ParsePolicies(%q) succeeded", s)
+		}
+	}
+	if _, err := ParsePolicies("AfterRtmpHandshake=continue"); err == nil || !strings.Contains(err.Error(), "unknown hook") {
+		t.Errorf("misspelled hook error = %v", err)
+	}
+}
+
+func TestPoliciesCall(t *testing.T) {
+	failure := errors.New("platform API error")
+	policies := Policies{
+		StageAfterRTMPHandshake:           {Action: ActionContinue},
+		StageBeforeEstablishTCPConnection: {Action: ActionRetry, Retries: 1},
+	}
+	calls := 0
+	fail := func() error {
+		calls++
+		return failure
+	}
+
+	// 未配置的事件默认结束会话，不重试
+	if err := policies.Call(discard, StageAfterCloseTCPConnection, fail); !errors.Is(err, failure) || calls != 1 {
+		t.Errorf("abort: err = %v after %d calls", err, calls)
+	}
+	calls = 0
+	if err := policies.Call(discard, StageAfterRTMPHandshake, fail); err != nil || calls != 1 {
+		t.Errorf("continue: err = %v after %d calls", err, calls)
+	}
+	calls = 0
+	if err := policies.Call(discard, StageBeforeEstablishTCPConnection, fail); !errors.Is(err, failure) || calls != 2 {
+		t.Errorf("retry: err = %v after %d calls", err, calls)
+	}
+
+	// 重试成功后继续
+	calls = 0
+	err := policies.Call(discard, StageBeforeEstablishTCPConnection, func() error {
+		calls++
+		if calls == 1 {
+			return failure
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("retry then succeed: err = %v after %d calls", err, calls)
+	}
+}
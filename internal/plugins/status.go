@@ -0,0 +1,44 @@
+package plugins
+
+// 插件状态，用于管理接口
+
+import "rtmpproxy/internal"
+
+// StatusReporter 插件可选实现的接口，返回可以序列化为JSON的状态
+type StatusReporter interface {
+	Status() interface{}
+}
+
+// Named 已加载的插件和名称
+type Named struct {
+	Name        string
+	Interceptor Interceptor
+}
+
+type Status struct {
+	Name   string      `json:"name"`
+	Status interface{} `json:"status,omitempty"`
+}
+
+// Statuses 返回已加载插件的状态，未实现 StatusReporter 的插件只包含名称
+func Statuses(loaded []Named) []Status {
+	list := make([]Status, 0, len(loaded))
+	for _, p := range loaded {
+		status := Status{Name: p.Name}
+		if reporter, ok := p.Interceptor.(StatusReporter); ok {
+			status.Status = reporter.Status()
+		}
+		list = append(list, status)
+	}
+	return list
+}
+
+// StatsObserver 插件可选实现的接口，推流过程中按 -statsInterval 定期收到会话的统计信息
+type StatsObserver interface {
+	OnStats(s *internal.Session, stats internal.StreamStats)
+}
+
+// HealthObserver 插件可选实现的接口，推流出现问题或恢复时收到事件，见 -stallTimeout
+type HealthObserver interface {
+	OnHealth(s *internal.Session, event internal.HealthEvent)
+}
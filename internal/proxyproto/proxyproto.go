@@ -0,0 +1,195 @@
+package proxyproto
+
+// PROXY protocol v1/v2 的解析与发送，用于在负载均衡之后获取真实的客户端地址
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// v2Signature PROXY protocol v2 的12字节签名
+var v2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+const (
+	v1MaxLength   = 107
+	headerTimeout = 10 * time.Second
+)
+
+// Conn 解析PROXY头之后的连接，RemoteAddr/LocalAddr 返回头中携带的地址
+type Conn struct {
+	net.Conn
+	r   *bufio.Reader
+	src net.Addr
+	dst net.Addr
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	if c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+// ReadHeader 读取连接开头的PROXY头(v1或v2)，没有PROXY头时返回错误
+func ReadHeader(conn net.Conn) (*Conn, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(headerTimeout))
+	defer func() {
+		_ = conn.SetReadDeadline(time.Time{})
+	}()
+
+	c := &Conn{Conn: conn, r: bufio.NewReader(conn)}
+	sig, err := c.r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(sig, v2Signature) {
+		err = c.readV2()
+	} else if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		err = c.readV1()
+	} else {
+		err = fmt.Errorf("missing PROXY protocol header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readV1 PROXY TCP4|TCP6|UNKNOWN src dst sport dport\r\n
+func (c *Conn) readV1() error {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return fmt.Errorf("invalid PROXY v1 header")
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("invalid PROXY v1 header: %q", line)
+	}
+	src, err := tcpAddr(fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	dst, err := tcpAddr(fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+	c.src, c.dst = src, dst
+	return nil
+}
+
+// readV2 二进制格式，只解析TCP over IPv4/IPv6，其它类型保留原始地址
+func (c *Conn) readV2() error {
+	var hdr [16]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[12]>>4 != 2 {
+		return fmt.Errorf("unsupported PROXY v2 version: %d", hdr[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return err
+	}
+	// LOCAL命令(健康检查等)使用原始连接地址
+	if hdr[12]&0x0f == 0 {
+		return nil
+	}
+	switch hdr[13] {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return fmt.Errorf("short PROXY v2 IPv4 address block")
+		}
+		c.src = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:]))}
+		c.dst = &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:]))}
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return fmt.Errorf("short PROXY v2 IPv6 address block")
+		}
+		c.src = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:]))}
+		c.dst = &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:]))}
+	}
+	return nil
+}
+
+// WriteHeader 向上游发送PROXY头，version 为1或2，地址不是TCP地址时发送UNKNOWN/LOCAL
+func WriteHeader(w io.Writer, version int, src net.Addr, dst net.Addr) error {
+	s, _ := src.(*net.TCPAddr)
+	d, _ := dst.(*net.TCPAddr)
+	ipv4 := s != nil && d != nil && s.IP.To4() != nil && d.IP.To4() != nil
+	known := s != nil && d != nil && (ipv4 || (s.IP.To4() == nil && d.IP.To4() == nil))
+
+	switch version {
+	case 1:
+		line := "PROXY UNKNOWN\r\n"
+		if known {
+			proto := "TCP6"
+			if ipv4 {
+				proto = "TCP4"
+			}
+			line = fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, s.IP, d.IP, s.Port, d.Port)
+		}
+		_, err := io.WriteString(w, line)
+		return err
+	case 2:
+		buf := bytes.NewBuffer(append([]byte(nil), v2Signature...))
+		switch {
+		case !known:
+			buf.Write([]byte{0x20, 0x00, 0x00, 0x00})
+		case ipv4:
+			buf.Write([]byte{0x21, 0x11, 0x00, 12})
+			buf.Write(s.IP.To4())
+			buf.Write(d.IP.To4())
+		default:
+			buf.Write([]byte{0x21, 0x21, 0x00, 36})
+			buf.Write(s.IP.To16())
+			buf.Write(d.IP.To16())
+		}
+		if known {
+			_ = binary.Write(buf, binary.BigEndian, uint16(s.Port))
+			_ = binary.Write(buf, binary.BigEndian, uint16(d.Port))
+		}
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+	return fmt.Errorf("unsupported PROXY protocol version: %d", version)
+}
+
+func tcpAddr(host string, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	if ip == nil || err != nil || p < 0 || p > 65535 {
+		return nil, fmt.Errorf("invalid PROXY address: %s:%s", host, port)
+	}
+	return &net.TCPAddr{IP: ip, Port: p}, nil
+}
@@ -0,0 +1,160 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// readHeader 在连接的另一端写入data后读取PROXY头，返回解析后的连接
+func readHeader(t *testing.T, data []byte) (*Conn, error) {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	go func() {
+		_, _ = client.Write(data)
+	}()
+	return ReadHeader(server)
+}
+
+func v2Header(command byte, family byte, addrs []byte) []byte {
+	b := append([]byte(nil), v2Signature...)
+	b = append(b, 0x20|command, family)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(addrs)))
+	return append(b, addrs...)
+}
+
+// 握手的前几个字节，检查PROXY头之后的数据没有被多读
+var rtmpC0 = []byte{0x03, 0x00, 0x00, 0x00}
+
+func TestReadHeader(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 2, 0x30, 0x39, 0x07, 0x8f}
+	ipv6 := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0x30, 0x39, 0x07, 0x8f)
+	tests := []struct {
+		name     string
+		header   []byte
+		src, dst string // 为空时保留原始地址
+	}{
+		{"v1 TCP4", []byte("PROXY TCP4 192.0.2.1 198.51.100.2 12345 1935\r\n"), "192.0.2.1:12345", "198.51.100.2:1935"},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 12345 1935\r\n"), "[2001:db8::1]:12345", "[2001:db8::2]:1935"},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\n"), "", ""},
+		{"v1 UNKNOWN with addresses", []byte("PROXY UNKNOWN 192.0.2.1 198.51.100.2 12345 1935\r\n"), "", ""},
+		{"v2 TCP4", v2Header(1, 0x11, ipv4), "192.0.2.1:12345", "198.51.100.2:1935"},
+		{"v2 TCP6", v2Header(1, 0x21, ipv6), "[2001:db8::1]:12345", "[2001:db8::2]:1935"},
+		{"v2 TCP4 with TLVs", v2Header(1, 0x11, append(append([]byte(nil), ipv4...), 0x04, 0x00, 0x01, 0xff)), "192.0.2.1:12345", "198.51.100.2:1935"},
+		{"v2 LOCAL", v2Header(0, 0x00, nil), "", ""},
+		{"v2 LOCAL with addresses", v2Header(0, 0x11, ipv4), "", ""},
+		{"v2 UDP", v2Header(1, 0x12, ipv4), "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := readHeader(t, append(append([]byte(nil), tt.header...), rtmpC0...))
+			if err != nil {
+				t.Fatalf("ReadHeader: %v", err)
+			}
+			if tt.src == "" {
+				if c.RemoteAddr() != c.Conn.RemoteAddr() || c.LocalAddr() != c.Conn.LocalAddr() {
+					t.Errorf("addresses replaced: %v -> %v", c.RemoteAddr(), c.LocalAddr())
+				}
+			} else if c.RemoteAddr().String() != tt.src || c.LocalAddr().String() != tt.dst {
+				t.Errorf("addresses = %v -> %v, want %s -> %s", c.RemoteAddr(), c.LocalAddr(), tt.src, tt.dst)
+			}
+			rest := make([]byte, len(rtmpC0))
+			if _, err := io.ReadFull(c, rest); err != nil || !bytes.Equal(rest, rtmpC0) {
+				t.Errorf("data after header = %x, %v, want %x", rest, err, rtmpC0)
+			}
+		})
+	}
+}
+
+func TestReadHeaderMalformed(t *testing.T) {
+	long := append([]byte("PROXY TCP4 "), bytes.Repeat([]byte("1"), v1MaxLength)...)
+	tests := []struct {
+		name   string
+		header []byte
+	}{
+		{"no header", append([]byte{0x03}, make([]byte, 15)...)},
+		{"v1 missing CRLF", []byte("PROXY TCP4 192.0.2.1 198.51.100.2 12345 1935\n")},
+		{"v1 too long", append(long, '\r', '\n')},
+		{"v1 missing fields", []byte("PROXY TCP4 192.0.2.1 198.51.100.2 12345\r\n")},
+		{"v1 bad protocol", []byte("PROXY UDP4 192.0.2.1 198.51.100.2 12345 1935\r\n")},
+		{"v1 bad address", []byte("PROXY TCP4 192.0.2.x 198.51.100.2 12345 1935\r\n")},
+		{"v1 bad port", []byte("PROXY TCP4 192.0.2.1 198.51.100.2 123456 1935\r\n")},
+		{"v2 bad version", append(append([]byte(nil), v2Signature...), 0x11, 0x11, 0x00, 0x00)},
+		{"v2 short IPv4 block", v2Header(1, 0x11, make([]byte, 8))},
+		{"v2 short IPv6 block", v2Header(1, 0x21, make([]byte, 12))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readHeader(t, append(tt.header, make([]byte, 64)...)); err == nil {
+				t.Errorf("ReadHeader succeeded")
+			}
+		})
+	}
+
+	// 头部声明的长度超过实际数据时读取失败，不会阻塞到超时
+	t.Run("v2 truncated", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer func() {
+			_ = server.Close()
+		}()
+		data := v2Header(1, 0x11, []byte{192, 0, 2, 1})
+		binary.BigEndian.PutUint16(data[14:], 12)
+		go func() {
+			_, _ = client.Write(data)
+			_ = client.Close()
+		}()
+		if _, err := ReadHeader(server); err == nil {
+			t.Errorf("ReadHeader succeeded")
+		}
+	})
+}
+
+func TestWriteHeaderRoundTrip(t *testing.T) {
+	v4src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 12345}
+	v4dst := &net.TCPAddr{IP: net.ParseIP("198.51.100.2"), Port: 1935}
+	v6src := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 12345}
+	v6dst := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 1935}
+	unix := &net.UnixAddr{Name: "/tmp/sock", Net: "unix"}
+	tests := []struct {
+		name     string
+		src, dst net.Addr
+		known    bool
+	}{
+		{"IPv4", v4src, v4dst, true},
+		{"IPv6", v6src, v6dst, true},
+		{"mixed families", v4src, v6dst, false},
+		{"non TCP", unix, v4dst, false},
+		{"nil", nil, nil, false},
+	}
+	for _, version := range []int{1, 2} {
+		for _, tt := range tests {
+			var buf bytes.Buffer
+			if err := WriteHeader(&buf, version, tt.src, tt.dst); err != nil {
+				t.Fatalf("v%d %s: WriteHeader: %v", version, tt.name, err)
+			}
+			c, err := readHeader(t, append(buf.Bytes(), rtmpC0...))
+			if err != nil {
+				t.Fatalf("v%d %s: ReadHeader(%q): %v", version, tt.name, buf.Bytes(), err)
+			}
+			if !tt.known {
+				if c.src != nil || c.dst != nil {
+					t.Errorf("v%d %s: addresses = %v -> %v, want unknown", version, tt.name, c.src, c.dst)
+				}
+				continue
+			}
+			if c.RemoteAddr().String() != tt.src.String() || c.LocalAddr().String() != tt.dst.String() {
+				t.Errorf("v%d %s: addresses = %v -> %v, want %v -> %v", version, tt.name, c.RemoteAddr(), c.LocalAddr(), tt.src, tt.dst)
+			}
+		}
+	}
+
+	if err := WriteHeader(io.Discard, 3, v4src, v4dst); err == nil {
+		t.Errorf("WriteHeader version 3 succeeded")
+	}
+}
@@ -0,0 +1,176 @@
+package pull
+
+// 拉流转推：作为客户端播放远程RTMP流，同时推流到一个或多个远程服务器
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	amf "github.com/zhangpeihao/goamf"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"rtmpproxy/internal"
+	"rtmpproxy/internal/rtmp"
+	"rtmpproxy/utils"
+	"time"
+)
+
+// 连接源站和握手的超时时间
+const handshakeTimeout = 10 * time.Second
+
+// 播放时服务端发送的数据消息，不需要转发
+var sampleAccess = []byte("\x02\x00\x11|RtmpSampleAccess")
+
+// Options 拉流转推的参数
+type Options struct {
+	Source  string       // 播放地址，例如 rtmp://host/app/stream
+	Targets []string     // 推流地址
+	Logger  *slog.Logger // 为nil时使用默认logger
+}
+
+// target 一个推流目标
+type target struct {
+	index    int
+	upstream *rtmp.Upstream
+	src      *rtmp.Source
+}
+
+// Run 播放Source并推流到所有Targets，连接使用cfg的代理和TLS设置，直到源站结束播放、所有目标断开或ctx取消，
+// 任一目标推流失败时不开始转发
+func Run(ctx context.Context, cfg *internal.Config, opts Options) error {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if len(opts.Targets) == 0 {
+		return utils.RemoteAddrRequired
+	}
+	// 向源站播放时不发送PROXY头
+	sourceCfg := *cfg
+	sourceCfg.SendProxyProtocol = 0
+	conn, sourceURL, err := sourceCfg.ConnectRemoteAddress(opts.Source, nil, logger)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	player := rtmp.NewConn(conn)
+	streamID, err := play(player, conn, sourceURL)
+	if err != nil {
+		return fmt.Errorf("play source: %w", err)
+	}
+	logger.Info("Playing source stream", "stream_id", streamID)
+
+	var targets []*target
+	defer func() {
+		for _, t := range targets {
+			t.upstream.Unpublish()
+		}
+	}()
+	for i, addr := range opts.Targets {
+		upstream, err := publish(cfg, addr, conn.RemoteAddr(), logger.With("target", i))
+		if err != nil {
+			return fmt.Errorf("publish target %d: %w", i, err)
+		}
+		// 每个目标的时间戳修复和切换状态保存在各自的来源中
+		t := &target{index: i, upstream: upstream, src: rtmp.NewSource("pull")}
+		upstream.Activate(t.src)
+		targets = append(targets, t)
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+	for {
+		msg, err := player.ReadMedia()
+		if err != nil {
+			if ctx.Err() != nil {
+				logger.Info("Pull stopped")
+				return nil
+			}
+			if errors.Is(err, io.EOF) {
+				logger.Info("Source stream ended")
+				return nil
+			}
+			return err
+		}
+		if msg.TypeID == rtmp.TypeDataAMF0 {
+			if bytes.HasPrefix(msg.Payload, sampleAccess) {
+				continue
+			}
+			msg.Payload = rtmp.WithSetDataFrame(msg.Payload)
+		}
+		// 依次写入各个目标，没有 QueueWrites 时一个目标写入缓慢会阻塞其它目标
+		live := targets[:0]
+		for _, t := range targets {
+			if err := t.upstream.Write(t.src, msg); err != nil {
+				logger.Warn("Target disconnected", "target", t.index, "err", err)
+				t.upstream.Close()
+				continue
+			}
+			live = append(live, t)
+		}
+		targets = live
+		if len(targets) == 0 {
+			return errors.New("all targets disconnected")
+		}
+	}
+}
+
+// play 在源站上握手、connect并播放
+func play(player *rtmp.Conn, conn net.Conn, sourceURL *url.URL) (uint32, error) {
+	appName, streamName, host, err := utils.GetLinkParams(sourceURL)
+	if err != nil {
+		return 0, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer func() {
+		_ = conn.SetDeadline(time.Time{})
+	}()
+	if err = player.ClientHandshake(); err != nil {
+		return 0, fmt.Errorf("handshake error: %w", err)
+	}
+	err = player.Connect(amf.Object{
+		"app":      appName,
+		"flashVer": "LNX 9,0,124,2",
+		"tcUrl":    sourceURL.Scheme + "://" + host + "/" + appName,
+		"fpad":     false,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return player.Play(streamName)
+}
+
+// publish 连接addr并推流，之后写入的消息按配置修复时间戳和排队发送
+func publish(cfg *internal.Config, addr string, sourceAddr net.Addr, logger *slog.Logger) (*rtmp.Upstream, error) {
+	conn, remoteURL, err := cfg.ConnectRemoteAddress(addr, sourceAddr, logger)
+	if err != nil {
+		return nil, err
+	}
+	appName, streamName, playUrl, err := utils.GetLinkParams(remoteURL)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	connect := amf.Object{"type": "nonprivate", "flashVer": "FMLE/3.0 (compatible; rtmpproxy)"}
+	if cfg.FlashVer != "" {
+		connect["flashVer"] = cfg.FlashVer
+	}
+	if cfg.RTMPType != "" {
+		connect["type"] = cfg.RTMPType
+	}
+	upstream := rtmp.NewUpstream(connect, "live", logger)
+	if err = upstream.Publish(conn, appName, playUrl, streamName); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	upstream.RepairJumps(cfg.TimestampJump)
+	upstream.QueueWrites(cfg.SendQueue)
+	return upstream, nil
+}
@@ -0,0 +1,449 @@
+package pull
+
+import (
+	"bytes"
+	"context"
+	amf "github.com/zhangpeihao/goamf"
+	"io"
+	"log/slog"
+	"net"
+	"rtmpproxy/internal"
+	"rtmpproxy/internal/rtmp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const timeout = 5 * time.Second
+
+var (
+	metadata    = []byte("\x02\x00\x0aonMetaData\x03\x00\x00\x09")
+	videoHeader = []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01, 0x64, 0x00, 0x1f}
+	audioHeader = []byte{0xaf, 0x00, 0x12, 0x10}
+)
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	return listener
+}
+
+// source 模拟播放源站：响应connect、createStream和play后发送messages，之后发送 NetStream.Play.Stop
+type source struct {
+	listener net.Listener
+	messages chan *rtmp.Message
+}
+
+func startSource(t *testing.T) *source {
+	s := &source{listener: listen(t), messages: make(chan *rtmp.Message, 1024)}
+	go s.serve(t)
+	return s
+}
+
+func (s *source) url(stream string) string {
+	return "rtmp://" + s.listener.Addr().String() + "/live/" + stream
+}
+
+// send 在播放的流上发送音视频，nil 结束播放
+func (s *source) send(msgs ...*rtmp.Message) {
+	for _, msg := range msgs {
+		s.messages <- msg
+	}
+}
+
+func (s *source) serve(t *testing.T) {
+	c, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	conn := rtmp.NewConn(c)
+	playing := make(chan struct{})
+	conn.ObserveCommands(func(cmd *rtmp.Command) {
+		var reply *rtmp.Command
+		switch cmd.Name {
+		case "connect":
+			reply = &rtmp.Command{Name: "_result", TransID: cmd.TransID, Args: []interface{}{nil, status("NetConnection.Connect.Success")}}
+		case "createStream":
+			reply = &rtmp.Command{Name: "_result", TransID: cmd.TransID, Args: []interface{}{nil, 1}}
+		case "play":
+			_ = conn.WriteCommand(1, &rtmp.Command{Name: "onStatus", Args: []interface{}{nil, status("NetStream.Play.Start")}})
+			// 源站在音视频之前发送的数据消息，不应转发
+			_ = conn.WriteMessage(&rtmp.Message{TypeID: rtmp.TypeDataAMF0, StreamID: 1, Payload: sampleAccess})
+			close(playing)
+			return
+		default:
+			return
+		}
+		_ = conn.WriteCommand(0, reply)
+	})
+	if err = conn.ServerHandshake(); err != nil {
+		t.Errorf("source handshake: %v", err)
+		return
+	}
+	go func() {
+		for {
+			if _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case <-playing:
+	case <-time.After(timeout):
+		t.Errorf("play not received")
+		return
+	}
+	for msg := range s.messages {
+		if msg == nil {
+			_ = conn.WriteCommand(1, &rtmp.Command{Name: "onStatus", Args: []interface{}{nil, status("NetStream.Play.Stop")}})
+			return
+		}
+		out := *msg
+		out.StreamID = 1
+		if err := conn.WriteMessage(&out); err != nil {
+			return
+		}
+	}
+}
+
+func status(code string) amf.Object {
+	return amf.Object{"level": "status", "code": code, "description": code}
+}
+
+// sink 模拟推流目标，记录收到的消息和推流参数
+type sink struct {
+	listener net.Listener
+	dropAt   int // 收到这么多条消息后断开连接，0 为不断开
+
+	mu       sync.Mutex
+	req      *rtmp.PublishRequest
+	messages []*rtmp.Message
+	ended    bool // 收到FCUnpublish
+	changed  chan struct{}
+	dropped  chan struct{} // 按 dropAt 断开后关闭
+}
+
+func startSink(t *testing.T, dropAt int) *sink {
+	s := &sink{listener: listen(t), dropAt: dropAt, changed: make(chan struct{}, 1), dropped: make(chan struct{})}
+	go s.serve()
+	return s
+}
+
+func (s *sink) url(stream string) string {
+	return "rtmp://" + s.listener.Addr().String() + "/app/" + stream
+}
+
+func (s *sink) serve() {
+	c, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = c.Close()
+		s.notify()
+	}()
+	conn := rtmp.NewConn(c)
+	conn.ObserveCommands(func(cmd *rtmp.Command) {
+		if cmd.Name == "FCUnpublish" {
+			s.mu.Lock()
+			s.ended = true
+			s.mu.Unlock()
+		}
+	})
+	if err = conn.ServerHandshake(); err != nil {
+		return
+	}
+	req, err := conn.ReadPublish(nil)
+	if err != nil {
+		return
+	}
+	if err = conn.AcceptPublish(req); err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.req = req
+	s.mu.Unlock()
+	for {
+		msg, err := conn.ReadMedia()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.messages = append(s.messages, msg)
+		drop := s.dropAt > 0 && len(s.messages) >= s.dropAt
+		s.mu.Unlock()
+		s.notify()
+		if drop {
+			_ = c.Close()
+			close(s.dropped)
+			return
+		}
+	}
+}
+
+func (s *sink) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// wait 等待收到n条消息，返回收到的消息
+func (s *sink) wait(t *testing.T, n int) []*rtmp.Message {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		messages := append([]*rtmp.Message(nil), s.messages...)
+		s.mu.Unlock()
+		if len(messages) >= n {
+			return messages
+		}
+		select {
+		case <-s.changed:
+		case <-deadline:
+			t.Fatalf("received %d messages, want %d", len(messages), n)
+		}
+	}
+}
+
+func waitDropped(t *testing.T, s *sink) {
+	t.Helper()
+	select {
+	case <-s.dropped:
+	case <-time.After(timeout):
+		t.Fatal("target not dropped")
+	}
+}
+
+// waitEnded 等待目标收到FCUnpublish
+func waitEnded(t *testing.T, s *sink) {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		ended := s.ended
+		s.mu.Unlock()
+		if ended {
+			return
+		}
+		select {
+		case <-s.changed:
+		case <-deadline:
+			t.Fatal("target not unpublished")
+		}
+	}
+}
+
+func testConfig(timestampJump time.Duration) *internal.Config {
+	remote, proxyAddr := "", ""
+	return &internal.Config{RemoteAddr: &remote, ProxyAddr: &proxyAddr, FlashVer: "X/1", TimestampJump: timestampJump}
+}
+
+func video(timestamp uint32, keyframe bool) *rtmp.Message {
+	payload := []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0x00}
+	if keyframe {
+		payload[0] = 0x17
+	}
+	return &rtmp.Message{TypeID: rtmp.TypeVideo, Timestamp: timestamp, Payload: payload}
+}
+
+func audio(timestamp uint32) *rtmp.Message {
+	return &rtmp.Message{TypeID: rtmp.TypeAudio, Timestamp: timestamp, Payload: []byte{0xaf, 0x01, 0x00}}
+}
+
+// frames 返回从start开始的n帧视频和音频，第一帧为关键帧
+func frames(start uint32, n int) []*rtmp.Message {
+	var list []*rtmp.Message
+	for i := 0; i < n; i++ {
+		ts := start + uint32(i)*33
+		list = append(list, video(ts, i == 0), audio(ts))
+	}
+	return list
+}
+
+func run(t *testing.T, cfg *internal.Config, opts Options) chan error {
+	done := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		done <- Run(ctx, cfg, opts)
+	}()
+	return done
+}
+
+func result(t *testing.T, done chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		t.Fatal("Run did not return")
+		return nil
+	}
+}
+
+func TestPullFanOut(t *testing.T) {
+	src := startSource(t)
+	first, second := startSink(t, 0), startSink(t, 0)
+	done := run(t, testConfig(0), Options{Source: src.url("stream"), Targets: []string{first.url("a"), second.url("b?token=1")}})
+
+	// 源站已经播放了一段时间，时间戳不从0开始
+	src.send(&rtmp.Message{TypeID: rtmp.TypeDataAMF0, Timestamp: 90000, Payload: metadata},
+		&rtmp.Message{TypeID: rtmp.TypeVideo, Timestamp: 90000, Payload: videoHeader},
+		&rtmp.Message{TypeID: rtmp.TypeAudio, Timestamp: 90000, Payload: audioHeader})
+	src.send(frames(90000, 10)...)
+	src.send(nil)
+	if err := result(t, done); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	for i, target := range []*sink{first, second} {
+		messages := target.wait(t, 23)
+		if len(messages) != 23 {
+			t.Errorf("target %d received %d messages, want 23", i, len(messages))
+		}
+		// 数据消息加上@setDataFrame，|RtmpSampleAccess 不转发
+		if msg := messages[0]; msg.TypeID != rtmp.TypeDataAMF0 || !bytes.HasPrefix(msg.Payload, []byte("\x02\x00\x0d@setDataFrame\x02\x00\x0aonMetaData")) {
+			t.Errorf("target %d first message = type %d % x", i, msg.TypeID, msg.Payload)
+		}
+		if !bytes.Equal(messages[1].Payload, videoHeader) || !bytes.Equal(messages[2].Payload, audioHeader) {
+			t.Errorf("target %d sequence headers not forwarded", i)
+		}
+		if messages[3].Timestamp != 0 || messages[len(messages)-1].Timestamp != 9*33 {
+			t.Errorf("target %d timestamps %d..%d, want 0..%d", i, messages[3].Timestamp, messages[len(messages)-1].Timestamp, 9*33)
+		}
+	}
+	waitEnded(t, first)
+	waitEnded(t, second)
+	first.mu.Lock()
+	second.mu.Lock()
+	defer first.mu.Unlock()
+	defer second.mu.Unlock()
+	if first.req.App != "app" || first.req.StreamName != "a" || first.req.FlashVer != "X/1" {
+		t.Errorf("first target publish %s/%s flashVer %s", first.req.App, first.req.StreamName, first.req.FlashVer)
+	}
+	if second.req.StreamName != "b" || second.req.Args.Get("token") != "1" {
+		t.Errorf("second target publish %s?%s", second.req.StreamName, second.req.Args.Encode())
+	}
+}
+
+func TestPullTargetDropped(t *testing.T) {
+	src := startSource(t)
+	// 第二个目标收到sequence header和2帧后断开
+	first, second := startSink(t, 0), startSink(t, 6)
+	done := run(t, testConfig(0), Options{Source: src.url("stream"), Targets: []string{first.url("a"), second.url("b")}})
+
+	src.send(&rtmp.Message{TypeID: rtmp.TypeVideo, Payload: videoHeader}, &rtmp.Message{TypeID: rtmp.TypeAudio, Payload: audioHeader})
+	src.send(frames(0, 2)...)
+	// 第二个目标断开后继续发送
+	waitDropped(t, second)
+	src.send(frames(66, 20)...)
+	first.wait(t, 46)
+	select {
+	case err := <-done:
+		t.Fatalf("Run returned after one target dropped: %v", err)
+	default:
+	}
+
+	src.send(nil)
+	if err := result(t, done); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if n := len(second.wait(t, 6)); n != 6 {
+		t.Errorf("dropped target received %d messages", n)
+	}
+	messages := first.wait(t, 46)
+	checkMonotonic(t, "first", messages)
+	if last := messages[len(messages)-1].Timestamp; last != 21*33 {
+		t.Errorf("first target last timestamp %d, want %d", last, 21*33)
+	}
+}
+
+func TestPullAllTargetsDropped(t *testing.T) {
+	src := startSource(t)
+	target := startSink(t, 3)
+	done := run(t, testConfig(0), Options{Source: src.url("stream"), Targets: []string{target.url("a")}})
+	src.send(frames(0, 2)...)
+	waitDropped(t, target)
+	// 直到写入失败发现目标断开
+	var err error
+	for err == nil {
+		src.send(frames(66, 2)...)
+		select {
+		case err = <-done:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if err == nil || !strings.Contains(err.Error(), "all targets disconnected") {
+		t.Errorf("Run = %v, want all targets disconnected", err)
+	}
+}
+
+// TestPullTimestamps 源站时间戳回退时每个目标的时间戳仍然单调，且各目标相同
+func TestPullTimestamps(t *testing.T) {
+	src := startSource(t)
+	first, second := startSink(t, 0), startSink(t, 0)
+	done := run(t, testConfig(time.Second), Options{Source: src.url("stream"), Targets: []string{first.url("a"), second.url("b")}})
+
+	src.send(frames(50000, 10)...)
+	// 源站重启，时间戳从0重新开始
+	src.send(frames(0, 10)...)
+	src.send(nil)
+	if err := result(t, done); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	a, b := first.wait(t, 40), second.wait(t, 40)
+	checkMonotonic(t, "first", a)
+	checkMonotonic(t, "second", b)
+	for i := range a {
+		if a[i].Timestamp != b[i].Timestamp {
+			t.Fatalf("message %d: first target at %d, second at %d", i, a[i].Timestamp, b[i].Timestamp)
+		}
+	}
+	if a[0].Timestamp != 0 {
+		t.Errorf("first timestamp %d, want 0", a[0].Timestamp)
+	}
+}
+
+func TestPullLargeFrame(t *testing.T) {
+	src := startSource(t)
+	target := startSink(t, 0)
+	done := run(t, testConfig(0), Options{Source: src.url("stream"), Targets: []string{target.url("a")}})
+
+	// 播放开始后接收超过命令长度上限的关键帧
+	keyframe := video(0, true)
+	keyframe.Payload = append(keyframe.Payload, make([]byte, 256<<10)...)
+	src.send(keyframe, video(33, false))
+	src.send(nil)
+	if err := result(t, done); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if messages := target.wait(t, 2); len(messages[0].Payload) != len(keyframe.Payload) {
+		t.Errorf("keyframe length %d, want %d", len(messages[0].Payload), len(keyframe.Payload))
+	}
+}
+
+// checkMonotonic 检查视频和音频各自的时间戳不回退
+func checkMonotonic(t *testing.T, name string, messages []*rtmp.Message) {
+	t.Helper()
+	last := map[uint32]uint32{}
+	for i, msg := range messages {
+		if prev, ok := last[msg.TypeID]; ok && msg.Timestamp < prev {
+			t.Errorf("%s target message %d type %d went back from %d to %d", name, i, msg.TypeID, prev, msg.Timestamp)
+		}
+		last[msg.TypeID] = msg.Timestamp
+	}
+}
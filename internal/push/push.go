@@ -0,0 +1,245 @@
+package push
+
+// 作为客户端将FLV文件或管道中的FLV实时推送到RTMP服务器，用于不借助OBS测试代理的完整流程
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	amf "github.com/zhangpeihao/goamf"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"rtmpproxy/internal/flv"
+	"rtmpproxy/internal/rtmp"
+	"time"
+)
+
+// 只有一帧时循环的间隔(毫秒)
+const defaultFrameInterval = 40
+
+// 连接和握手的超时时间
+const dialTimeout = 10 * time.Second
+
+// ctx 取消后结束推流的超时时间
+const closeTimeout = 2 * time.Second
+
+// Options 推流参数
+type Options struct {
+	App        string // connect 的app
+	StreamName string
+	Loop       bool         // 文件结束后从头循环，时间戳继续增加
+	Logger     *slog.Logger // 为nil时使用默认logger
+}
+
+// File 连接addr上的RTMP服务器，按时间戳实时推送path中的音视频，文件结束(不循环时)或ctx取消后结束推流
+func File(ctx context.Context, addr string, path string, opts Options) error {
+	p, err := dial(ctx, addr, opts)
+	if err != nil {
+		return err
+	}
+	defer p.close()
+	for loop := 0; ; loop++ {
+		if err := p.playFile(path, loop > 0); err != nil {
+			return err
+		}
+		if !opts.Loop {
+			return nil
+		}
+		p.logger.Info("Looping FLV file", "loop", loop+1)
+	}
+}
+
+// Stream 连接addr上的RTMP服务器，推送r中的FLV，例如ffmpeg输出到管道的FLV，r 结束或ctx取消后结束推流
+func Stream(ctx context.Context, addr string, r io.Reader, opts Options) error {
+	reader, err := flv.NewReader(r)
+	if err != nil {
+		return err
+	}
+	p, err := dial(ctx, addr, opts)
+	if err != nil {
+		return err
+	}
+	defer p.close()
+	return p.play(reader, false)
+}
+
+// publisher 一路客户端推流
+type publisher struct {
+	ctx        context.Context
+	conn       *rtmp.Conn
+	streamName string
+	streamID   uint32
+	logger     *slog.Logger
+
+	start    time.Time // 第一条消息的发送时间
+	base     uint32    // 之前循环的总时长
+	duration uint32    // 当前循环的时长
+}
+
+// dial 连接并推流，ctx 取消后关闭连接
+func dial(ctx context.Context, addr string, opts Options) (*publisher, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	p := &publisher{ctx: ctx, conn: rtmp.NewConn(conn), streamName: opts.StreamName, logger: logger}
+	_ = conn.SetDeadline(time.Now().Add(dialTimeout))
+	if err = p.publish(addr, opts.App); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	logger.Info("Publishing FLV", "addr", addr, "app", opts.App, "stream_id", p.streamID)
+
+	// 服务端的状态和控制消息，读取失败时服务端已经断开
+	go func() {
+		for {
+			if _, err := p.conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		_ = conn.SetDeadline(time.Now().Add(closeTimeout))
+	}()
+	return p, nil
+}
+
+func (p *publisher) publish(addr string, app string) error {
+	err := p.conn.ClientHandshake()
+	if err != nil {
+		return fmt.Errorf("handshake error: %w", err)
+	}
+	err = p.conn.Connect(amf.Object{
+		"app":      app,
+		"type":     "nonprivate",
+		"flashVer": "FMLE/3.0 (compatible; rtmpproxy)",
+		"tcUrl":    "rtmp://" + addr + "/" + app,
+	})
+	if err != nil {
+		return err
+	}
+	p.streamID, err = p.conn.Publish(p.streamName, "live")
+	return err
+}
+
+// close 结束推流并关闭连接
+func (p *publisher) close() {
+	_ = p.conn.Unpublish(p.streamName, p.streamID)
+	_ = p.conn.Close()
+}
+
+// playFile 推送一次文件，again 见 play
+func (p *publisher) playFile(path string, again bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	reader, err := flv.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err = p.play(reader, again); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// play 推送reader中的tag，again 为true时跳过开头的metadata和sequence header
+func (p *publisher) play(reader *flv.Reader, again bool) error {
+	p.base += p.duration
+	p.duration = 0
+	var (
+		zero        = -1   // 文件中第一个tag的时间戳，之后的时间戳从0开始
+		first, last uint32 // 视频帧的时间戳
+		videoFrames int
+		started     bool // 已经读到音视频帧
+	)
+	for {
+		tag, err := reader.ReadTag()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if zero < 0 {
+			zero = int(tag.Timestamp)
+		}
+		tag.Timestamp = uint32(max(int(tag.Timestamp)-zero, 0))
+		frame := false
+		switch tag.Type {
+		case flv.TagVideo:
+			video, ok := flv.ParseVideoTag(tag.Data)
+			frame = ok && video.IsFrame()
+			if frame {
+				if videoFrames == 0 {
+					first = tag.Timestamp
+				}
+				last = tag.Timestamp
+				videoFrames++
+			}
+		case flv.TagAudio:
+			audio, ok := flv.ParseAudioTag(tag.Data)
+			frame = ok && audio.IsFrame()
+		case flv.TagScript:
+		default:
+			continue
+		}
+		started = started || frame
+		if again && !started {
+			continue
+		}
+		if err := p.write(tag); err != nil {
+			return err
+		}
+		if frame {
+			p.duration = max(p.duration, tag.Timestamp)
+		}
+	}
+	// 最后一帧的时长按平均帧间隔计算
+	interval := uint32(defaultFrameInterval)
+	if videoFrames > 1 && last > first {
+		interval = (last - first) / uint32(videoFrames-1)
+	}
+	p.duration += interval
+	return nil
+}
+
+// write 等到tag的时间戳后发送
+func (p *publisher) write(tag *flv.Tag) error {
+	timestamp := p.base + tag.Timestamp
+	if p.start.IsZero() {
+		p.start = time.Now().Add(-time.Duration(timestamp) * time.Millisecond)
+	}
+	if wait := time.Until(p.start.Add(time.Duration(timestamp) * time.Millisecond)); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-p.ctx.Done():
+			timer.Stop()
+			return p.ctx.Err()
+		}
+	}
+	payload := tag.Data
+	if tag.Type == flv.TagScript {
+		payload = rtmp.WithSetDataFrame(payload)
+	}
+	return p.conn.WriteMessage(&rtmp.Message{
+		TypeID:    uint32(tag.Type),
+		StreamID:  p.streamID,
+		Timestamp: timestamp,
+		Payload:   payload,
+	})
+}
@@ -0,0 +1,226 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	amf "github.com/zhangpeihao/goamf"
+	"net"
+	"os"
+	"path/filepath"
+	"rtmpproxy/internal/flv"
+	"rtmpproxy/internal/rtmp"
+	"testing"
+	"time"
+)
+
+// writeFLV 将tags写入临时的FLV文件
+func writeFLV(t *testing.T, tags ...flv.Tag) string {
+	t.Helper()
+	var b bytes.Buffer
+	b.Write([]byte{'F', 'L', 'V', 1, 0x05, 0, 0, 0, 9, 0, 0, 0, 0})
+	for _, tag := range tags {
+		size := len(tag.Data)
+		b.Write([]byte{tag.Type, byte(size >> 16), byte(size >> 8), byte(size), byte(tag.Timestamp >> 16), byte(tag.Timestamp >> 8), byte(tag.Timestamp), 0, 0, 0, 0})
+		b.Write(tag.Data)
+		b.Write([]byte{0, 0, 0, byte(size + 11)})
+	}
+	path := filepath.Join(t.TempDir(), "test.flv")
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func metadata() []byte {
+	var buf bytes.Buffer
+	_, _ = amf.WriteString(&buf, "onMetaData")
+	_, _ = amf.WriteValue(&buf, amf.Object{"width": 1280.0})
+	return buf.Bytes()
+}
+
+var (
+	videoHeader = []byte{0x17, 0, 0, 0, 0}
+	keyframe    = []byte{0x17, 1, 0, 0, 0}
+	interFrame  = []byte{0x27, 1, 0, 0, 0}
+	audioFrame  = []byte{0xaf, 1, 0x21}
+)
+
+// received 测试服务器收到的一条消息
+type received struct {
+	typeID    uint32
+	timestamp uint32
+	payload   []byte
+	command   string
+}
+
+// serve 接受一个推流，将收到的消息写入ch，收到deleteStream或连接断开后关闭ch
+func serve(t *testing.T, app string, streamName string) (string, <-chan received) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	ch := make(chan received, 64)
+	go func() {
+		defer close(ch)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		c := rtmp.NewConn(conn)
+		if c.ServerHandshake() != nil {
+			return
+		}
+		req, err := c.ReadPublish(nil)
+		if err != nil {
+			return
+		}
+		if req.App != app || req.StreamName != streamName {
+			t.Errorf("publish %s/%s, want %s/%s", req.App, req.StreamName, app, streamName)
+		}
+		if c.AcceptPublish(req) != nil {
+			return
+		}
+		for {
+			msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			r := received{typeID: msg.TypeID, timestamp: msg.Timestamp, payload: msg.Payload}
+			if msg.TypeID == rtmp.TypeCommandAMF0 {
+				r.command, _ = amf.ReadString(bytes.NewReader(msg.Payload))
+			}
+			ch <- r
+			if r.command == "deleteStream" {
+				return
+			}
+		}
+	}()
+	return listener.Addr().String(), ch
+}
+
+func next(t *testing.T, ch <-chan received) received {
+	t.Helper()
+	select {
+	case r, ok := <-ch:
+		if !ok {
+			t.Fatal("server connection closed")
+		}
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	return received{}
+}
+
+func TestFile(t *testing.T) {
+	path := writeFLV(t,
+		flv.Tag{Type: flv.TagScript, Timestamp: 1000, Data: metadata()},
+		flv.Tag{Type: flv.TagVideo, Timestamp: 1000, Data: videoHeader},
+		flv.Tag{Type: flv.TagVideo, Timestamp: 1000, Data: keyframe},
+		flv.Tag{Type: flv.TagAudio, Timestamp: 1020, Data: audioFrame},
+		flv.Tag{Type: flv.TagVideo, Timestamp: 1040, Data: interFrame},
+	)
+	addr, ch := serve(t, "live", "key")
+	start := time.Now()
+	if err := File(context.Background(), addr, path, Options{App: "live", StreamName: "key"}); err != nil {
+		t.Fatalf("File: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("pushed in %s, want real time", elapsed)
+	}
+
+	// 推流时metadata加上@setDataFrame，时间戳从0开始
+	r := next(t, ch)
+	name, _ := amf.ReadString(bytes.NewReader(r.payload))
+	if r.typeID != rtmp.TypeDataAMF0 || name != "@setDataFrame" || !bytes.HasSuffix(r.payload, metadata()) {
+		t.Errorf("first message type %d %q, want @setDataFrame metadata", r.typeID, name)
+	}
+	want := []struct {
+		typeID    uint32
+		timestamp uint32
+		payload   []byte
+	}{
+		{rtmp.TypeVideo, 0, videoHeader},
+		{rtmp.TypeVideo, 0, keyframe},
+		{rtmp.TypeAudio, 20, audioFrame},
+		{rtmp.TypeVideo, 40, interFrame},
+	}
+	for _, w := range want {
+		r := next(t, ch)
+		if r.typeID != w.typeID || r.timestamp != w.timestamp || !bytes.Equal(r.payload, w.payload) {
+			t.Errorf("message type %d at %d %x, want type %d at %d %x", r.typeID, r.timestamp, r.payload, w.typeID, w.timestamp, w.payload)
+		}
+	}
+	if r := next(t, ch); r.command != "FCUnpublish" {
+		t.Errorf("command %q, want FCUnpublish", r.command)
+	}
+	if r := next(t, ch); r.command != "deleteStream" {
+		t.Errorf("command %q, want deleteStream", r.command)
+	}
+}
+
+func TestFileLoop(t *testing.T) {
+	path := writeFLV(t,
+		flv.Tag{Type: flv.TagVideo, Data: videoHeader},
+		flv.Tag{Type: flv.TagVideo, Data: keyframe},
+		flv.Tag{Type: flv.TagVideo, Timestamp: 40, Data: interFrame},
+	)
+	addr, ch := serve(t, "live", "key")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- File(ctx, addr, path, Options{App: "live", StreamName: "key", Loop: true})
+	}()
+
+	// 循环时跳过sequence header，时间戳按文件时长继续增加
+	want := []struct {
+		timestamp uint32
+		payload   []byte
+	}{
+		{0, videoHeader}, {0, keyframe}, {40, interFrame},
+		{80, keyframe}, {120, interFrame},
+		{160, keyframe},
+	}
+	for _, w := range want {
+		r := next(t, ch)
+		if r.timestamp != w.timestamp || !bytes.Equal(r.payload, w.payload) {
+			t.Errorf("message at %d %x, want at %d %x", r.timestamp, r.payload, w.timestamp, w.payload)
+		}
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("File = %v, want canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("File did not return after cancel")
+	}
+}
+
+func TestFileErrors(t *testing.T) {
+	addr, _ := serve(t, "live", "key")
+	err := File(context.Background(), addr, filepath.Join(t.TempDir(), "missing.flv"), Options{App: "live", StreamName: "key"})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("File with missing file = %v, want not exist", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr = listener.Addr().String()
+	_ = listener.Close()
+	if err := File(context.Background(), addr, writeFLV(t), Options{App: "live", StreamName: "key"}); err == nil {
+		t.Error("File succeeded without server")
+	}
+}
@@ -1,12 +1,10 @@
 package rtmp
 
 import (
-	"bytes"
-	"encoding/binary"
-	"fmt"
-	amf "github.com/zhangpeihao/goamf"
+	"errors"
 	"io"
-	"log"
+	"net"
+	"time"
 )
 
 type rtmpChunkHeader struct {
@@ -16,139 +14,95 @@ type rtmpChunkHeader struct {
 	length    uint32
 	typeid    uint32
 	streamid  uint32
+	extended  bool // 使用了扩展时间戳，之后同一消息的fmt 3 chunk也带有扩展时间戳
 }
 
-// handleMessages 处理Client数据包，修改后的直接转发给Server
-func (c *RTMPConnection) HandleMessages() error {
-	var (
-		maxChunkSize = 128
-		usecopy      = false
-
-		lastch  rtmpChunkHeader
-		payload []byte
-		nread   int
-	)
-
-	for !usecopy || c.forceHandle {
-		ch, err := rtmpReadHeader(c.ClientConn)
+// HandleMessages 处理Client消息，修改后转发给Server，返回客户端是否通过deleteStream结束推流
+func (c *RTMPConnection) HandleMessages() (bool, error) {
+	for {
+		msg, err := c.client.ReadMessage()
 		if err != nil {
-			return err
-		}
-		if nread != 0 && ch.csid != lastch.csid {
-			return fmt.Errorf("unsupport multi-chunkstream at a time")
-		}
-
-		switch ch.format {
-		case 1:
-			ch.streamid = lastch.streamid
-		case 2:
-			ch.length = lastch.length
-			ch.typeid = lastch.typeid
-			ch.streamid = lastch.streamid
-		case 3:
-			ch.timestamp = lastch.timestamp
-			ch.length = lastch.length
-			ch.typeid = lastch.typeid
-			ch.streamid = lastch.streamid
-		}
-		lastch = *ch
-
-		if len(payload) != int(ch.length) {
-			payload = make([]byte, ch.length)
-		}
-
-		n := maxChunkSize
-		if rem := len(payload) - nread; rem < maxChunkSize {
-			n = rem
-		}
-
-		_, err = io.ReadFull(c.ClientConn, payload[nread:nread+n])
-		if err != nil {
-			return err
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return false, nil
+			}
+			return false, err
 		}
-		nread += n
-		if nread < len(payload) {
-			continue
+		for _, receive := range c.receivers {
+			receive(msg)
 		}
-
-		switch ch.typeid {
-		case 1:
-			if len(payload) != 4 {
-				return fmt.Errorf("invalid type 0 payload size: %d", len(payload))
-			}
-			maxChunkSize = int(binary.BigEndian.Uint32(payload))
-			if maxChunkSize <= 0 {
-				return fmt.Errorf("invalid chunk size: %d", maxChunkSize)
+		var done bool
+		switch msg.TypeID {
+		case TypeAudio, TypeVideo, TypeDataAMF0, TypeDataAMF3:
+			if msg.TypeID == TypeAudio || msg.TypeID == TypeVideo {
+				c.lastMedia.Store(time.Now().UnixMilli())
 			}
-		case 20:
-			payload, usecopy, err = c.handleRtmpCommand(payload)
-			if err != nil {
-				return err
+			if c.delay != nil {
+				err = c.delay.push(c, msg)
+			} else {
+				err = c.forward(msg)
 			}
+		case TypeCommandAMF0, TypeCommandAMF3:
+			done, err = c.handleRtmpCommand(msg)
+			c.observe(msg)
+		}
+		if done {
+			return true, err
 		}
-		err = writeRtmpMessage(c.ServerConn, ch, payload, maxChunkSize)
 		if err != nil {
-			return err
+			return false, err
 		}
-		payload = nil
-		nread = 0
 	}
-	return nil
 }
 
-// handleRtmpCommand 处理并修改rtmp命令
-func (c *RTMPConnection) handleRtmpCommand(payload []byte) ([]byte, bool, error) {
-	br := bytes.NewReader(payload)
-	command, err := amf.ReadString(br)
-	if err != nil {
-		return nil, false, err
+// forward 将客户端的音视频或数据消息写入远程服务器的推流，写入后通知观察者
+func (c *RTMPConnection) forward(msg *Message) error {
+	timestamp, written, err := c.upstream.write(c.source, msg)
+	if written {
+		c.notify(msg, timestamp)
 	}
-	transid, err := amf.ReadDouble(br)
-	if err != nil {
-		return nil, false, err
+	return err
+}
+
+// observe 通知观察者客户端的命令，只通知正在转发的客户端的消息
+func (c *RTMPConnection) observe(msg *Message) {
+	if len(c.observers) == 0 {
+		return
 	}
-	args := make([]interface{}, 0, 1)
-	for br.Len() > 0 {
-		v, err := amf.ReadValue(br)
-		if err != nil {
-			return nil, false, err
-		}
-		args = append(args, v)
+	if timestamp, ok := c.upstream.timestamp(c.source, msg.Timestamp); ok {
+		c.notify(msg, timestamp)
 	}
-	usecopy := false
-	switch command {
-	case "connect":
-		obj := args[0].(amf.Object)
-		obj["app"] = c.appName
-		obj["swfUrl"] = c.playUrl
-		obj["tcUrl"] = c.playUrl
-		if c.flashVer != "" {
-			obj["flashVer"] = c.flashVer // "flashVer -> FMLE/3.0 (compatible; FMSc/1.0)" obs默认值
-		}
-		if c.rtmpType != "" {
-			fmt.Printf("rtmpType: %s\n", c.rtmpType)
-			obj["rtmpType"] = c.rtmpType
+}
+
+// notify 通知观察者，时间戳改为发往远程服务器的时间戳，切换客户端后保持连续
+func (c *RTMPConnection) notify(msg *Message, timestamp uint32) {
+	if len(c.observers) == 0 {
+		return
+	}
+	observed := *msg
+	observed.Timestamp = timestamp
+	for _, observe := range c.observers {
+		observe(&observed)
+	}
+}
+
+// handleRtmpCommand 处理推流开始后客户端的命令，返回客户端是否结束推流
+func (c *RTMPConnection) handleRtmpCommand(msg *Message) (bool, error) {
+	cmd, err := decodeCommand(msg)
+	if err != nil {
+		return false, err
+	}
+	switch cmd.Name {
+	case "FCUnpublish":
+		if c.managed {
+			return false, nil
 		}
-		// log输出
-		keys := []string{"app", "flashVer", "swfUrl", "tcUrl", "type"}
-		var output string
-		for _, k := range keys {
-			output += fmt.Sprintf("%s=%s ", k, obj[k])
+		return false, c.upstream.command("FCUnpublish", cmd.TransID)
+	case "deleteStream", "closeStream":
+		c.logger.Info("Client closed stream", "command", cmd.Name)
+		if c.managed {
+			return true, nil
 		}
-		log.Println("RTMP Connect Params:", output)
-	case "releaseStream", "FCPublish":
-		args[1] = c.streamName
-	case "publish":
-		args[1] = c.streamName
-		usecopy = true
-	case "FCUnpublish":
-		args[1] = c.streamName // 如果不forcehandle的话这里不会处理，不过也无所谓，TCP流也会关，只是减少特征
-	}
-	buf := bytes.NewBuffer(nil)
-	_, _ = amf.WriteString(buf, command)
-	_, _ = amf.WriteDouble(buf, transid)
-	for _, arg := range args {
-		_, _ = amf.WriteValue(buf, arg)
+		return true, c.upstream.command("deleteStream", cmd.TransID)
 	}
-	return buf.Bytes(), usecopy, nil
+	return false, nil
 }
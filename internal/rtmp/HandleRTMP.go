@@ -5,6 +5,7 @@ import (
 	"io"
 )
 
+// asBytes 编码chunk header，时间戳超过24位时使用扩展时间戳，同一消息的fmt 3 chunk也会带上扩展时间戳
 func (h *rtmpChunkHeader) asBytes() []byte {
 	n := 1
 	csid := h.csid
@@ -23,21 +24,24 @@ func (h *rtmpChunkHeader) asBytes() []byte {
 	case 2:
 		n += 3
 	}
-	if h.timestamp >= 0xffffff {
+	extended := h.timestamp >= 0xffffff
+	if extended {
 		n += 4
 	}
 	data := make([]byte, n)
 	data[0] = byte((h.format << 6) | csid)
 	p := 1
-	if csid <= 1 {
-		binary.BigEndian.PutUint16(data[1:], uint16(h.csid-64))
-		p += int(csid) + 1
+	switch csid {
+	case 0:
+		data[1] = byte(h.csid - 64)
+		p += 1
+	case 1:
+		binary.LittleEndian.PutUint16(data[1:], uint16(h.csid-64))
+		p += 2
 	}
 	ts := h.timestamp
-	exts := uint32(0)
-	if ts >= 0xffffff {
+	if extended {
 		ts = 0xffffff
-		exts = ts
 	}
 	switch h.format {
 	case 0, 1:
@@ -52,11 +56,11 @@ func (h *rtmpChunkHeader) asBytes() []byte {
 		}
 	case 2:
 		binary.BigEndian.PutUint16(data[p:], uint16(ts>>8))
-		data[p+2] = byte(h.timestamp & 0xff)
+		data[p+2] = byte(ts)
 		p += 3
 	}
-	if ts == 0xffffff && h.format != 3 {
-		binary.BigEndian.PutUint32(data[p:], exts)
+	if extended {
+		binary.BigEndian.PutUint32(data[p:], h.timestamp)
 	}
 	return data
 }
@@ -96,7 +100,7 @@ func rtmpReadHeader(r io.Reader) (*rtmpChunkHeader, error) {
 			ch.csid = uint32(buf[0]) + 64
 			p = 1
 		case 1:
-			ch.csid = uint32(binary.BigEndian.Uint16(buf[:])) + 64
+			ch.csid = uint32(binary.LittleEndian.Uint16(buf[:])) + 64
 			p = 2
 		}
 		hbuf := buf[p:]
@@ -117,6 +121,7 @@ func rtmpReadHeader(r io.Reader) (*rtmpChunkHeader, error) {
 			ch.timestamp = binary.BigEndian.Uint32(hbuf) >> 8
 		}
 		if ch.timestamp == 0xffffff {
+			ch.extended = true
 			_, err := io.ReadFull(r, buf[:4])
 			if err != nil {
 				return nil, err
@@ -0,0 +1,193 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	amf "github.com/zhangpeihao/goamf"
+	"io"
+)
+
+// 作为客户端发送命令时使用的transaction id
+const (
+	transConnect       = 1
+	transReleaseStream = 2
+	transFCPublish     = 3
+	transCreateStream  = 4
+)
+
+// 播放时通知服务端的缓冲时长(毫秒)
+const playBuffer = 3000
+
+// Connect 作为客户端发送connect命令并等待服务端的_result
+func (c *Conn) Connect(obj amf.Object) error {
+	err := c.SetChunkSize(4096)
+	if err != nil {
+		return err
+	}
+	err = c.WriteCommand(0, &Command{Name: "connect", TransID: transConnect, Args: []interface{}{obj}})
+	if err != nil {
+		return err
+	}
+	cmd, err := c.waitResult(transConnect)
+	if err != nil {
+		return err
+	}
+	_, code, description := cmd.status()
+	if cmd.Name != "_result" {
+		return fmt.Errorf("connect rejected: %s %s", code, description)
+	}
+	return nil
+}
+
+// Publish 作为客户端创建流并推流，返回服务端分配的流ID
+func (c *Conn) Publish(streamName string, publishType string) (uint32, error) {
+	err := c.WriteCommand(0, &Command{Name: "releaseStream", TransID: transReleaseStream, Args: []interface{}{nil, streamName}})
+	if err != nil {
+		return 0, err
+	}
+	err = c.WriteCommand(0, &Command{Name: "FCPublish", TransID: transFCPublish, Args: []interface{}{nil, streamName}})
+	if err != nil {
+		return 0, err
+	}
+	streamID, err := c.createStream()
+	if err != nil {
+		return 0, err
+	}
+
+	if publishType == "" {
+		publishType = "live"
+	}
+	err = c.WriteCommand(streamID, &Command{Name: "publish", Args: []interface{}{nil, streamName, publishType}})
+	if err != nil {
+		return 0, err
+	}
+	return streamID, c.waitStatus("publish", "NetStream.Publish.Start")
+}
+
+// Play 作为客户端创建流并播放streamName，返回服务端分配的流ID，之后通过 ReadMessage 读取音视频
+func (c *Conn) Play(streamName string) (uint32, error) {
+	streamID, err := c.createStream()
+	if err != nil {
+		return 0, err
+	}
+	// start 为-2时优先播放直播流
+	err = c.WriteCommand(streamID, &Command{Name: "play", Args: []interface{}{nil, streamName, -2}})
+	if err != nil {
+		return 0, err
+	}
+	payload := make([]byte, 10)
+	binary.BigEndian.PutUint16(payload, eventSetBuffer)
+	binary.BigEndian.PutUint32(payload[2:], streamID)
+	binary.BigEndian.PutUint32(payload[6:], playBuffer)
+	err = c.WriteMessage(&Message{CSID: csidControl, TypeID: TypeUserControl, Payload: payload})
+	if err != nil {
+		return 0, err
+	}
+	c.reader.acceptMedia()
+	return streamID, c.waitStatus("play", "NetStream.Play.Start")
+}
+
+// ReadMedia 读取下一条音视频或数据消息，播放时服务端结束播放、或作为服务端时客户端结束推流返回 io.EOF
+func (c *Conn) ReadMedia() (*Message, error) {
+	for {
+		msg, err := c.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		switch msg.TypeID {
+		case TypeAudio, TypeVideo, TypeDataAMF0, TypeDataAMF3:
+			return msg, nil
+		case TypeCommandAMF0, TypeCommandAMF3:
+			cmd, err := decodeCommand(msg)
+			if err != nil {
+				continue
+			}
+			switch cmd.Name {
+			case "deleteStream", "FCUnpublish", "closeStream":
+				return nil, io.EOF
+			case "onStatus":
+			default:
+				continue
+			}
+			level, code, description := cmd.status()
+			switch {
+			case code == "NetStream.Play.Stop" || code == "NetStream.Play.Complete" || code == "NetStream.Play.UnpublishNotify":
+				return nil, io.EOF
+			case level == "error":
+				return nil, fmt.Errorf("play failed: %s %s", code, description)
+			}
+		}
+	}
+}
+
+// createStream 创建消息流，返回流ID
+func (c *Conn) createStream() (uint32, error) {
+	err := c.WriteCommand(0, &Command{Name: "createStream", TransID: transCreateStream, Args: []interface{}{nil}})
+	if err != nil {
+		return 0, err
+	}
+	cmd, err := c.waitResult(transCreateStream)
+	if err != nil {
+		return 0, err
+	}
+	id, ok := cmd.arg(1).(float64)
+	if cmd.Name != "_result" || !ok {
+		return 0, fmt.Errorf("createStream failed: %v", cmd)
+	}
+	return uint32(id), nil
+}
+
+// waitStatus 等待onStatus返回code，返回error级别的状态时command失败
+func (c *Conn) waitStatus(command string, code string) error {
+	for {
+		cmd, err := c.readCommand()
+		if err != nil {
+			return err
+		}
+		if cmd.Name != "onStatus" {
+			continue
+		}
+		level, status, description := cmd.status()
+		if status == code {
+			return nil
+		}
+		if level == "error" {
+			return fmt.Errorf("%s rejected: %s %s", command, status, description)
+		}
+	}
+}
+
+// Unpublish 作为客户端结束推流，发送FCUnpublish和deleteStream
+func (c *Conn) Unpublish(streamName string, streamID uint32) error {
+	err := c.WriteCommand(0, &Command{Name: "FCUnpublish", Args: []interface{}{nil, streamName}})
+	if err != nil {
+		return err
+	}
+	return c.WriteCommand(0, &Command{Name: "deleteStream", Args: []interface{}{nil, streamID}})
+}
+
+// waitResult 等待指定transaction id的_result或_error
+func (c *Conn) waitResult(transID float64) (*Command, error) {
+	for {
+		cmd, err := c.readCommand()
+		if err != nil {
+			return nil, err
+		}
+		if (cmd.Name == "_result" || cmd.Name == "_error") && cmd.TransID == transID {
+			return cmd, nil
+		}
+	}
+}
+
+// readCommand 读取下一条命令消息，忽略其它消息
+func (c *Conn) readCommand() (*Command, error) {
+	for {
+		msg, err := c.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if msg.TypeID == TypeCommandAMF0 || msg.TypeID == TypeCommandAMF3 {
+			return decodeCommand(msg)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package rtmp
+
+import (
+	"bytes"
+	"fmt"
+	amf "github.com/zhangpeihao/goamf"
+)
+
+// Command AMF0命令消息，Args的第一个元素通常是command object
+type Command struct {
+	Name    string
+	TransID float64
+	Args    []interface{}
+}
+
+// decodeCommand 解析命令消息，AMF3命令的首字节为0，后续仍为AMF0编码
+func decodeCommand(msg *Message) (*Command, error) {
+	payload := msg.Payload
+	if msg.TypeID == TypeCommandAMF3 && len(payload) > 0 {
+		payload = payload[1:]
+	}
+	br := bytes.NewReader(payload)
+	name, err := amf.ReadString(br)
+	if err != nil {
+		return nil, err
+	}
+	transid, err := amf.ReadDouble(br)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]interface{}, 0, 2)
+	for br.Len() > 0 {
+		v, err := amf.ReadValue(br)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	return &Command{Name: name, TransID: transid, Args: args}, nil
+}
+
+// encode 编码为AMF0命令消息的payload
+func (c *Command) encode() []byte {
+	buf := bytes.NewBuffer(nil)
+	_, _ = amf.WriteString(buf, c.Name)
+	_, _ = amf.WriteDouble(buf, c.TransID)
+	for _, arg := range c.Args {
+		_, _ = amf.WriteValue(buf, arg)
+	}
+	return buf.Bytes()
+}
+
+// arg 获取第i个参数，不存在时返回nil
+func (c *Command) arg(i int) interface{} {
+	if i < len(c.Args) {
+		return c.Args[i]
+	}
+	return nil
+}
+
+// object 获取第i个参数并作为AMF对象返回
+func (c *Command) object(i int) amf.Object {
+	obj, _ := c.arg(i).(amf.Object)
+	return obj
+}
+
+// stringArg 获取第i个参数并作为字符串返回
+func (c *Command) stringArg(i int) string {
+	s, _ := c.arg(i).(string)
+	return s
+}
+
+// status onStatus/_result/_error中info对象的level、code和description
+func (c *Command) status() (level string, code string, description string) {
+	for i := len(c.Args) - 1; i >= 0; i-- {
+		if obj, ok := c.Args[i].(amf.Object); ok {
+			level, _ = obj["level"].(string)
+			code, _ = obj["code"].(string)
+			description, _ = obj["description"].(string)
+			return
+		}
+	}
+	return
+}
+
+func (c *Command) String() string {
+	return fmt.Sprintf("%s(%v) %v", c.Name, c.TransID, c.Args)
+}
+
+// statusObject 构造onStatus使用的info对象
+func statusObject(level string, code string, description string) amf.Object {
+	return amf.Object{
+		"level":       level,
+		"code":        code,
+		"description": description,
+	}
+}
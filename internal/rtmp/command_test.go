@@ -0,0 +1,182 @@
+package rtmp
+
+import (
+	"errors"
+	amf "github.com/zhangpeihao/goamf"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommandEncodeDecode(t *testing.T) {
+	cmd := &Command{
+		Name:    "connect",
+		TransID: 1,
+		Args:    []interface{}{amf.Object{"app": "live", "tcUrl": "rtmp://example.com/live"}, "extra", 2.0},
+	}
+	payload := cmd.encode()
+	got, err := decodeCommand(&Message{TypeID: TypeCommandAMF0, Payload: payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, cmd) {
+		t.Errorf("decoded %v, want %v", got, cmd)
+	}
+	if got.object(0)["app"] != "live" || got.stringArg(1) != "extra" || got.arg(5) != nil || got.object(1) != nil {
+		t.Errorf("unexpected args %v", got.Args)
+	}
+
+	// AMF3命令的首字节为0，其余为AMF0
+	got, err = decodeCommand(&Message{TypeID: TypeCommandAMF3, Payload: append([]byte{0}, payload...)})
+	if err != nil || got.Name != "connect" || got.TransID != 1 {
+		t.Errorf("AMF3 command = %v, %v", got, err)
+	}
+
+	if _, err = decodeCommand(&Message{TypeID: TypeCommandAMF0, Payload: payload[:len(payload)-3]}); err == nil {
+		t.Errorf("truncated command decoded")
+	}
+}
+
+func TestCommandStatus(t *testing.T) {
+	cmd := &Command{Name: "onStatus", Args: []interface{}{nil, statusObject("error", "NetStream.Publish.BadName", "bad key")}}
+	level, code, description := cmd.status()
+	if level != "error" || code != "NetStream.Publish.BadName" || description != "bad key" {
+		t.Errorf("status = %s %s %s", level, code, description)
+	}
+	if _, code, _ = (&Command{Name: "_result", Args: []interface{}{nil, 1.0}}).status(); code != "" {
+		t.Errorf("status without info object = %s", code)
+	}
+}
+
+// handshaken 返回完成握手的客户端和服务端
+func handshaken(t *testing.T) (*Conn, *Conn) {
+	t.Helper()
+	client, server := loopback(t)
+	done := make(chan error, 1)
+	go func() {
+		done <- server.ServerHandshake()
+	}()
+	if err := client.ClientHandshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestPublishCommands(t *testing.T) {
+	client, server := handshaken(t)
+	published := make(chan *PublishRequest, 1)
+	go func() {
+		req, err := server.ReadPublish(nil)
+		if err != nil {
+			t.Errorf("ReadPublish: %v", err)
+			close(published)
+			return
+		}
+		published <- req
+		_ = server.AcceptPublish(req)
+	}()
+
+	err := client.Connect(amf.Object{
+		"app":      "live?vhost=a",
+		"tcUrl":    "rtmp://example.com/live?region=b",
+		"flashVer": "FMLE/3.0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamID, err := client.Publish("key?token=c", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streamID != clientStreamID {
+		t.Errorf("stream id = %d", streamID)
+	}
+	req := <-published
+	if req == nil {
+		t.FailNow()
+	}
+	if req.App != "live" || req.StreamName != "key" || req.Type != "live" || req.FlashVer != "FMLE/3.0" {
+		t.Errorf("request = %s/%s type %s flashVer %s", req.App, req.StreamName, req.Type, req.FlashVer)
+	}
+	want := map[string]string{"vhost": "a", "region": "b", "token": "c"}
+	for k, v := range want {
+		if req.Args.Get(k) != v {
+			t.Errorf("arg %s = %q, want %q", k, req.Args.Get(k), v)
+		}
+	}
+	if server.reader.maxSize != maxMessageSize {
+		t.Errorf("media messages not accepted after publish")
+	}
+
+	// 客户端结束推流
+	if err = client.Unpublish("key", streamID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.ReadMedia(); !errors.Is(err, io.EOF) {
+		t.Errorf("ReadMedia after unpublish = %v, want EOF", err)
+	}
+}
+
+func TestReadPublishRejectConnect(t *testing.T) {
+	client, server := handshaken(t)
+	done := make(chan error, 1)
+	go func() {
+		_, err := server.ReadPublish(func(req *PublishRequest) error {
+			return errors.New("app " + req.App + " not allowed")
+		})
+		done <- err
+	}()
+	err := client.Connect(amf.Object{"app": "other"})
+	if err == nil || !strings.Contains(err.Error(), "NetConnection.Connect.Rejected") {
+		t.Errorf("connect err = %v, want rejected", err)
+	}
+	if err = <-done; err == nil || err.Error() != "app other not allowed" {
+		t.Errorf("ReadPublish err = %v", err)
+	}
+}
+
+func TestReadPublishErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cmds []*Command
+		want string
+	}{
+		{"publish before connect", []*Command{{Name: "publish", Args: []interface{}{nil, "key"}}}, "publish before connect"},
+		{"play", []*Command{{Name: "connect", TransID: 1, Args: []interface{}{amf.Object{"app": "live"}}}, {Name: "play", Args: []interface{}{nil, "key"}}}, "play is not supported"},
+		{"closed", []*Command{{Name: "connect", TransID: 1, Args: []interface{}{amf.Object{"app": "live"}}}, {Name: "deleteStream", Args: []interface{}{nil, 1.0}}}, "closed stream before publish"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := handshaken(t)
+			go func() {
+				for _, cmd := range tt.cmds {
+					_ = client.WriteCommand(0, cmd)
+				}
+			}()
+			_, err := server.ReadPublish(nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPublishRejected(t *testing.T) {
+	client, server := handshaken(t)
+	go func() {
+		if _, err := server.ReadPublish(nil); err == nil {
+			_ = server.RejectPublish("NetStream.Publish.BadName", "invalid key")
+		}
+	}()
+	if err := client.Connect(amf.Object{"app": "live"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := client.Publish("key", "live")
+	if err == nil || !strings.Contains(err.Error(), "NetStream.Publish.BadName invalid key") {
+		t.Errorf("publish err = %v, want BadName", err)
+	}
+}
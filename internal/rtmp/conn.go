@@ -0,0 +1,207 @@
+package rtmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// 用户控制消息事件类型
+const (
+	eventStreamBegin  = 0
+	eventStreamEOF    = 1
+	eventSetBuffer    = 3
+	eventPingRequest  = 6
+	eventPingResponse = 7
+)
+
+const defaultWindowAckSize = 2500000
+
+// Conn 在net.Conn之上按RTMP消息收发，并处理协议控制消息
+type Conn struct {
+	net.Conn
+	reader *chunkReader
+	bw     *bufio.Writer
+	wmu    sync.Mutex
+
+	chunkSize  int    // 发送方向的chunk大小
+	windowSize uint32 // 对端设置的确认窗口大小
+	received   *countReader
+	written    *countWriter
+	acked      uint64
+	onCommand  func(cmd *Command)
+}
+
+// countReader 统计从连接读取的字节数，用于发送Acknowledgement和流量统计
+type countReader struct {
+	r io.Reader
+	n atomic.Uint64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(uint64(n))
+	return n, err
+}
+
+// countWriter 统计写入连接的字节数
+type countWriter struct {
+	w io.Writer
+	n atomic.Uint64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(uint64(n))
+	return n, err
+}
+
+// NewConn 包装一个已建立的连接，握手需要单独调用
+func NewConn(conn net.Conn) *Conn {
+	cr := &countReader{r: conn}
+	cw := &countWriter{w: conn}
+	return &Conn{
+		Conn:      conn,
+		reader:    newChunkReader(bufio.NewReaderSize(cr, 64*1024)),
+		bw:        bufio.NewWriterSize(cw, 64*1024),
+		chunkSize: defaultChunkSize,
+		received:  cr,
+		written:   cw,
+	}
+}
+
+// Traffic 返回已读取和已写入的字节数
+func (c *Conn) Traffic() (in uint64, out uint64) {
+	return c.received.n.Load(), c.written.n.Load()
+}
+
+// ReadMessage 读取下一条非协议控制消息，协议控制消息在内部处理
+func (c *Conn) ReadMessage() (*Message, error) {
+	for {
+		msg, err := c.reader.readMessage()
+		if err != nil {
+			return nil, err
+		}
+		if err = c.sendAck(); err != nil {
+			return nil, err
+		}
+		switch msg.TypeID {
+		case TypeSetChunkSize:
+			if len(msg.Payload) < 4 {
+				return nil, fmt.Errorf("invalid set chunk size payload size: %d", len(msg.Payload))
+			}
+			size := int(binary.BigEndian.Uint32(msg.Payload) & maxChunkSize)
+			if size <= 0 {
+				return nil, fmt.Errorf("invalid chunk size: %d", size)
+			}
+			c.reader.chunkSize = size
+		case TypeAbort:
+			if len(msg.Payload) >= 4 {
+				c.reader.abort(binary.BigEndian.Uint32(msg.Payload))
+			}
+		case TypeWindowAckSize:
+			if len(msg.Payload) >= 4 {
+				c.windowSize = binary.BigEndian.Uint32(msg.Payload)
+			}
+		case TypeUserControl:
+			if len(msg.Payload) >= 6 && binary.BigEndian.Uint16(msg.Payload) == eventPingRequest {
+				err = c.writeUserControl(eventPingResponse, binary.BigEndian.Uint32(msg.Payload[2:]))
+				if err != nil {
+					return nil, err
+				}
+			}
+		case TypeAck, TypeSetPeerBandwidth:
+		default:
+			if c.onCommand != nil && (msg.TypeID == TypeCommandAMF0 || msg.TypeID == TypeCommandAMF3) {
+				if cmd, err := decodeCommand(msg); err == nil {
+					c.onCommand(cmd)
+				}
+			}
+			return msg, nil
+		}
+	}
+}
+
+// ObserveCommands 读取到命令消息时调用fn，包括 ReadPublish 等内部读取的命令，需要在读取前设置
+func (c *Conn) ObserveCommands(fn func(cmd *Command)) {
+	c.onCommand = fn
+}
+
+// sendAck 接收的字节数超过对端设置的窗口时发送Acknowledgement
+func (c *Conn) sendAck() error {
+	received := c.received.n.Load()
+	if c.windowSize == 0 || received-c.acked < uint64(c.windowSize) {
+		return nil
+	}
+	c.acked = received
+	return c.writeControl(TypeAck, uint32(c.acked))
+}
+
+// WriteMessage 发送一条完整的消息
+func (c *Conn) WriteMessage(msg *Message) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	ch := &rtmpChunkHeader{
+		csid:      msg.CSID,
+		timestamp: msg.Timestamp,
+		typeid:    msg.TypeID,
+		streamid:  msg.StreamID,
+	}
+	if ch.csid == 0 {
+		ch.csid = csidByType(msg.TypeID)
+	}
+	err := writeRtmpMessage(c.bw, ch, msg.Payload, c.chunkSize)
+	if err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+// WriteCommand 在指定的消息流上发送AMF0命令
+func (c *Conn) WriteCommand(streamID uint32, cmd *Command) error {
+	return c.WriteMessage(&Message{
+		CSID:     csidCommand,
+		TypeID:   TypeCommandAMF0,
+		StreamID: streamID,
+		Payload:  cmd.encode(),
+	})
+}
+
+// SetChunkSize 通知对端并修改发送方向的chunk大小
+func (c *Conn) SetChunkSize(size int) error {
+	err := c.writeControl(TypeSetChunkSize, uint32(size))
+	if err != nil {
+		return err
+	}
+	c.wmu.Lock()
+	c.chunkSize = size
+	c.wmu.Unlock()
+	return nil
+}
+
+// writeControl 发送payload为单个uint32的协议控制消息
+func (c *Conn) writeControl(typeID uint32, value uint32) error {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, value)
+	return c.WriteMessage(&Message{CSID: csidControl, TypeID: typeID, Payload: payload})
+}
+
+// writeSetPeerBandwidth 发送Set Peer Bandwidth，limitType 2 为dynamic
+func (c *Conn) writeSetPeerBandwidth(size uint32, limitType byte) error {
+	payload := make([]byte, 5)
+	binary.BigEndian.PutUint32(payload, size)
+	payload[4] = limitType
+	return c.WriteMessage(&Message{CSID: csidControl, TypeID: TypeSetPeerBandwidth, Payload: payload})
+}
+
+// writeUserControl 发送用户控制消息
+func (c *Conn) writeUserControl(event uint16, value uint32) error {
+	payload := make([]byte, 6)
+	binary.BigEndian.PutUint16(payload, event)
+	binary.BigEndian.PutUint32(payload[2:], value)
+	return c.WriteMessage(&Message{CSID: csidControl, TypeID: TypeUserControl, Payload: payload})
+}
@@ -2,82 +2,225 @@ package rtmp
 
 import (
 	"fmt"
-	"io"
-	"log"
+	amf "github.com/zhangpeihao/goamf"
+	"log/slog"
 	"net"
+	"sync/atomic"
+	"time"
 )
 
+// 与客户端握手并等待publish的超时时间
+const handshakeTimeout = 30 * time.Second
+
 type RTMPConnection struct {
-	ClientConn  net.Conn
-	ServerConn  net.Conn
-	appName     string
-	playUrl     string
-	streamName  string
-	forceHandle bool
-	flashVer    string
-	rtmpType    string
+	ClientConn net.Conn
+	client     *Conn // 面向客户端，作为服务端
+	publish    *PublishRequest
+	flashVer   string
+	rtmpType   string
+	upstream   *Upstream // 远程服务器上的推流
+	source     *Source   // 客户端在 upstream 中的来源
+	delay      *Delay    // 不为nil时消息经过延迟缓冲区写入 upstream
+	managed    bool
+	joined     bool
+	ended      bool
+	lastMedia  atomic.Int64 // 毫秒时间戳
+	observers  []func(msg *Message)
+	receivers  []func(msg *Message)
+	logger     *slog.Logger
+}
+
+// Traffic 各方向的字节数
+type Traffic struct {
+	ClientIn  uint64
+	ClientOut uint64
+	RemoteIn  uint64
+	RemoteOut uint64
 }
 
-type copyErr struct {
-	dir string
-	err error
+// Observe 添加客户端消息的观察者，在 Serve 之前调用，观察者不能修改消息
+func (c *RTMPConnection) Observe(fn func(msg *Message)) {
+	c.observers = append(c.observers, fn)
 }
 
+// ObserveReceived 添加客户端消息的观察者，推流开始后读取的每条消息都会通知，包括被过滤、丢弃或没有转发的消息，
+// 时间戳为客户端的原始时间戳，在 Serve 之前调用，观察者不能修改消息
+func (c *RTMPConnection) ObserveReceived(fn func(msg *Message)) {
+	c.receivers = append(c.receivers, fn)
+}
+
+// Traffic 返回当前各方向的字节数
+func (c *RTMPConnection) Traffic() Traffic {
+	var t Traffic
+	t.ClientIn, t.ClientOut = c.client.Traffic()
+	if c.upstream != nil {
+		t.RemoteIn, t.RemoteOut = c.upstream.Traffic()
+	}
+	return t
+}
+
+// RTMPHandshake 与客户端完成RTMP握手
 func (c *RTMPConnection) RTMPHandshake() error {
-	errs := make(chan copyErr, 2)
+	c.logger.Debug("Starting RTMP handshake")
+	_ = c.ClientConn.SetDeadline(time.Now().Add(handshakeTimeout))
+	err := c.client.ServerHandshake()
+	if err != nil {
+		return fmt.Errorf("client handshake error: %w", err)
+	}
+	c.logger.Debug("RTMP handshake finished")
+	return nil
+}
+
+// ReadPublish 响应客户端的命令直到收到publish，返回客户端的推流参数，checkConnect 可在connect阶段拒绝连接
+func (c *RTMPConnection) ReadPublish(checkConnect func(req *PublishRequest) error) (*PublishRequest, error) {
+	req, err := c.client.ReadPublish(checkConnect)
+	if err != nil {
+		return nil, err
+	}
+	_ = c.ClientConn.SetDeadline(time.Time{})
+	c.publish = req
+	return req, nil
+}
+
+// Reject 拒绝客户端的推流请求
+func (c *RTMPConnection) Reject(code string, description string) error {
+	return c.client.RejectPublish(code, description)
+}
+
+// ConnectServer 与远程服务器握手，并使用修改后的connect参数推流
+func (c *RTMPConnection) ConnectServer(ServerConn net.Conn, appName string, playUrl string, streamName string) error {
+	c.upstream = NewUpstream(c.connectObject(), c.publish.Type, c.logger)
+	return c.upstream.Publish(ServerConn, appName, playUrl, streamName)
+}
 
-	copyfn := func(dir string, dst, src net.Conn) {
-		_, err := io.CopyN(dst, src, 1+1536+1536)
-		errs <- copyErr{dir, err}
+// SwitchServer 在推流过程中切换到新的远程服务器，见 Upstream.Publish
+func (c *RTMPConnection) SwitchServer(ServerConn net.Conn, appName string, playUrl string, streamName string) error {
+	if c.upstream == nil || c.upstream.Closed() {
+		return fmt.Errorf("stream is not publishing")
 	}
-	log.Printf("Starting RTMP handshake...")
+	return c.upstream.Publish(ServerConn, appName, playUrl, streamName)
+}
+
+// Manage 由调用者管理远程服务器的推流：Serve 不会切换到客户端的来源，客户端断开或结束推流时不结束远程服务器的推流，
+// 客户端的FCUnpublish和deleteStream也不转发，name 为客户端来源的名称，在 Serve 之前调用
+func (c *RTMPConnection) Manage(name string) {
+	c.managed = true
+	c.source.name = name
+}
+
+// Join 作为owner的远程服务器推流的另一个来源，使用owner的观察者、延迟缓冲区和logger，在 Manage 之后、Serve 之前调用
+func (c *RTMPConnection) Join(owner *RTMPConnection) {
+	c.upstream = owner.upstream
+	c.observers = owner.observers
+	c.receivers = owner.receivers
+	c.delay = owner.delay
+	c.logger = owner.logger
+	c.joined = true
+}
+
+// DelayOutput 客户端的音视频延迟 delay 后再写入远程服务器，在 ConnectServer 之后、Serve 之前调用
+func (c *RTMPConnection) DelayOutput(delay time.Duration) {
+	c.delay = NewDelay(c.upstream, delay)
+}
+
+// Delay 返回延迟缓冲区，未调用 DelayOutput 时为nil
+func (c *RTMPConnection) Delay() *Delay {
+	return c.delay
+}
 
-	go copyfn("C→S", c.ServerConn, c.ClientConn)
-	go copyfn("S→C", c.ClientConn, c.ServerConn)
+// Source 返回客户端在远程服务器推流中的来源
+func (c *RTMPConnection) Source() *Source {
+	return c.source
+}
 
-	cf1 := <-errs
-	if cf1.err != nil {
-		return fmt.Errorf("%s handshake error: %w", cf1.dir, cf1.err)
+// Ended 客户端是否通过deleteStream结束推流，Serve 返回后调用
+func (c *RTMPConnection) Ended() bool {
+	return c.ended
+}
+
+// LastMedia 最近一次收到客户端音视频的时间
+func (c *RTMPConnection) LastMedia() time.Time {
+	return time.UnixMilli(c.lastMedia.Load())
+}
+
+// Upstream 返回远程服务器上的推流
+func (c *RTMPConnection) Upstream() *Upstream {
+	return c.upstream
+}
+
+// connectObject 根据客户端的connect参数修改得到发往远程服务器的connect参数，不含app和tcUrl
+func (c *RTMPConnection) connectObject() amf.Object {
+	obj := make(amf.Object, len(c.publish.Connect))
+	for k, v := range c.publish.Connect {
+		obj[k] = v
 	}
-	cf2 := <-errs
-	if cf2.err != nil {
-		return fmt.Errorf("%s handshake error: %w", cf2.dir, cf2.err)
+	if c.flashVer != "" {
+		obj["flashVer"] = c.flashVer // "flashVer -> FMLE/3.0 (compatible; FMSc/1.0)" obs默认值
 	}
-	log.Printf("RTMP handshake finished.")
-	return nil
+	if c.rtmpType != "" {
+		obj["type"] = c.rtmpType
+	}
+	return obj
 }
 
+// Serve 通知客户端推流开始并转发消息，直到客户端结束推流或任意一端断开
 func (c *RTMPConnection) Serve() error {
-	defer func(ServerConn net.Conn) {
-		_ = ServerConn.Close()
-	}(c.ServerConn)
+	err := c.client.AcceptPublish(c.publish)
+	if err != nil {
+		c.Close()
+		return err
+	}
 
+	c.lastMedia.Store(time.Now().UnixMilli())
+	if !c.managed {
+		c.upstream.Activate(c.source)
+	}
+	stop := make(chan struct{})
 	go func() {
-		_, _ = io.Copy(c.ClientConn, c.ServerConn)
-		c.ClientConn.Close()
-		c.ServerConn.Close()
+		// 远程服务器断开时结束读取客户端
+		select {
+		case <-c.upstream.Done():
+			_ = c.ClientConn.Close()
+		case <-stop:
+		}
 	}()
-
-	err := c.HandleMessages()
-	if err != nil {
-		return err
+	ended, err := c.HandleMessages()
+	c.ended = ended
+	close(stop)
+	_ = c.ClientConn.Close()
+	if !c.managed {
+		c.upstream.Close()
 	}
-	// 剩余的字节直接转发
-	_, err = io.Copy(c.ServerConn, c.ClientConn)
-	c.ServerConn.Close()
-	c.ClientConn.Close()
 	return err
 }
 
-func CreateRTMPInstance(ClientConn net.Conn, ServerConn net.Conn, appName string, playUrl string, streamName string, forceHandle bool, flashVer string, RTMPType string) *RTMPConnection {
+// Unpublish 通知远程服务器结束推流后关闭连接，用于主动结束会话
+func (c *RTMPConnection) Unpublish() {
+	if c.upstream != nil {
+		c.upstream.Unpublish()
+	}
+	c.Close()
+}
+
+// Close 关闭客户端和远程服务器的连接，Join 的连接只关闭客户端
+func (c *RTMPConnection) Close() {
+	_ = c.ClientConn.Close()
+	if c.upstream != nil && !c.joined {
+		c.upstream.Close()
+	}
+}
+
+// CreateRTMPInstance 创建RTMP连接，logger 为nil时使用默认logger
+func CreateRTMPInstance(ClientConn net.Conn, flashVer string, RTMPType string, logger *slog.Logger) *RTMPConnection {
+	if logger == nil {
+		logger = slog.Default()
+	}
 	return &RTMPConnection{
-		ClientConn:  ClientConn,
-		ServerConn:  ServerConn,
-		appName:     appName,
-		playUrl:     playUrl,
-		streamName:  streamName,
-		forceHandle: forceHandle,
-		flashVer:    flashVer,
-		rtmpType:    RTMPType,
+		ClientConn: ClientConn,
+		client:     NewConn(ClientConn),
+		flashVer:   flashVer,
+		rtmpType:   RTMPType,
+		source:     NewSource("client"),
+		logger:     logger,
 	}
 }
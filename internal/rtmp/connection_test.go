@@ -0,0 +1,108 @@
+package rtmp_test
+
+import (
+	"rtmpproxy/internal/rtmp"
+	"rtmpproxy/internal/rtmptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// proxy 通过 Pipe 连接推流客户端、RTMPConnection 和推流服务器，setup 在 Serve 之前调用
+func proxy(t *testing.T, setup func(conn *rtmp.RTMPConnection)) (*rtmptest.Publisher, *rtmptest.Ingest, <-chan error) {
+	t.Helper()
+	ingest := rtmptest.NewIngest()
+	t.Cleanup(ingest.Close)
+	remoteClient, remoteServer := rtmptest.Pipe(t)
+	go func() {
+		_ = ingest.ServeConn(remoteServer)
+	}()
+
+	client, server := rtmptest.Pipe(t)
+	conn := rtmp.CreateRTMPInstance(server, "", "", nil)
+	served := make(chan error, 1)
+	ready := make(chan error, 1)
+	go func() {
+		if err := conn.RTMPHandshake(); err != nil {
+			ready <- err
+			return
+		}
+		if _, err := conn.ReadPublish(nil); err != nil {
+			ready <- err
+			return
+		}
+		if err := conn.ConnectServer(remoteClient, "live", remoteClient.RemoteAddr().String(), "remotekey"); err != nil {
+			ready <- err
+			return
+		}
+		setup(conn)
+		ready <- nil
+		served <- conn.Serve()
+	}()
+	p, err := rtmptest.Publish(client, "live", "key", nil)
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if err = <-ready; err != nil {
+		t.Fatalf("proxy: %v", err)
+	}
+	return p, ingest, served
+}
+
+// counter 按消息类型计数
+type counter struct {
+	mu     sync.Mutex
+	counts map[uint32]int
+}
+
+func (c *counter) observe(msg *rtmp.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[uint32]int)
+	}
+	c.counts[msg.TypeID]++
+}
+
+func (c *counter) get(typeID uint32) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[typeID]
+}
+
+func TestObserveReceived(t *testing.T) {
+	var received, forwarded counter
+	p, ingest, served := proxy(t, func(conn *rtmp.RTMPConnection) {
+		conn.Upstream().FilterTracks(rtmp.TrackFilter{NoVideo: true})
+		conn.ObserveReceived(received.observe)
+		conn.Observe(forwarded.observe)
+	})
+
+	// sequence header 和10帧，视频被过滤
+	if err := p.WriteFrames(10); err != nil {
+		t.Fatal(err)
+	}
+	ingest.WaitMessages(t, 11, 5*time.Second)
+	if err := p.Unpublish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+
+	if got := received.get(rtmp.TypeVideo); got != 11 {
+		t.Errorf("received video = %d, want 11", got)
+	}
+	if got := received.get(rtmp.TypeAudio); got != 11 {
+		t.Errorf("received audio = %d, want 11", got)
+	}
+	if got := received.get(rtmp.TypeCommandAMF0); got < 2 {
+		t.Errorf("received commands = %d, want FCUnpublish and deleteStream", got)
+	}
+	if got := forwarded.get(rtmp.TypeVideo); got != 0 {
+		t.Errorf("forwarded video = %d, want 0", got)
+	}
+	if got := forwarded.get(rtmp.TypeAudio); got != 11 {
+		t.Errorf("forwarded audio = %d, want 11", got)
+	}
+}
@@ -0,0 +1,181 @@
+package rtmp
+
+// 延迟转发：客户端的音视频先在缓冲区中保存一段时间再写入远程服务器，需要时可以在到达平台之前丢弃
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// delayed 缓冲区中的一条消息
+type delayed struct {
+	conn *RTMPConnection
+	msg  *Message
+	at   time.Time // 写入远程服务器的时间
+}
+
+// Delay 远程服务器推流前的延迟缓冲区，同一路推流的客户端连接共用，消息收到 delay 之后按顺序写入 Upstream
+type Delay struct {
+	upstream *Upstream
+	delay    time.Duration
+	wake     chan struct{}
+
+	mu    sync.Mutex
+	queue []*delayed
+	skip  map[*Source]bool // Dump 之后等待下一个关键帧的来源
+	idle  chan struct{}    // 缓冲区清空后关闭
+}
+
+// NewDelay 创建延迟缓冲区，推流结束时停止，之后缓冲区中的消息不再发送
+func NewDelay(upstream *Upstream, delay time.Duration) *Delay {
+	d := &Delay{
+		upstream: upstream,
+		delay:    delay,
+		wake:     make(chan struct{}, 1),
+		skip:     make(map[*Source]bool),
+	}
+	go d.run()
+	return d
+}
+
+// push 将客户端的音视频或数据消息放入缓冲区，同一来源的音视频帧按时间戳排序
+func (d *Delay) push(conn *RTMPConnection, msg *Message) error {
+	if d.upstream.Closed() {
+		return net.ErrClosed
+	}
+	src := conn.source
+	d.mu.Lock()
+	if d.skip[src] && isFrame(msg) {
+		if !switchPoint(src, msg) {
+			d.mu.Unlock()
+			return nil
+		}
+		delete(d.skip, src)
+	}
+	i := len(d.queue)
+	if isFrame(msg) {
+		for i > 0 {
+			prev := d.queue[i-1]
+			if prev.conn.source != src || !isFrame(prev.msg) || int32(msg.Timestamp-prev.msg.Timestamp) >= 0 {
+				break
+			}
+			i--
+		}
+	}
+	d.queue = append(d.queue, nil)
+	copy(d.queue[i+1:], d.queue[i:])
+	d.queue[i] = &delayed{conn: conn, msg: msg, at: time.Now().Add(d.delay)}
+	d.mu.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// isFrame msg是否是音视频帧，不包括sequence header
+func isFrame(msg *Message) bool {
+	switch msg.TypeID {
+	case TypeVideo:
+		return !isVideoSequenceHeader(msg.Payload)
+	case TypeAudio:
+		return !isAudioSequenceHeader(msg.Payload)
+	}
+	return false
+}
+
+// run 按时间写入到期的消息，直到推流结束
+func (d *Delay) run() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+	for {
+		d.mu.Lock()
+		var next *delayed
+		wait := time.Duration(-1)
+		if len(d.queue) > 0 {
+			if wait = time.Until(d.queue[0].at); wait <= 0 {
+				next = d.queue[0]
+				d.queue[0] = nil
+				d.queue = d.queue[1:]
+				d.checkIdle()
+			}
+		}
+		d.mu.Unlock()
+
+		if next != nil {
+			if err := next.conn.forward(next.msg); err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					next.conn.logger.Warn("Failed to send delayed message", "err", err)
+				}
+				d.upstream.Close()
+				return
+			}
+			continue
+		}
+		var expired <-chan time.Time
+		if wait > 0 {
+			timer.Reset(wait)
+			expired = timer.C
+		}
+		select {
+		case <-expired:
+		case <-d.wake:
+			timer.Stop()
+		case <-d.upstream.Done():
+			return
+		}
+	}
+}
+
+// checkIdle 缓冲区清空后通知 Idle 的调用者，调用时需持有d.mu
+func (d *Delay) checkIdle() {
+	if len(d.queue) == 0 && d.idle != nil {
+		close(d.idle)
+		d.idle = nil
+	}
+}
+
+// Idle 返回的channel在缓冲区清空后关闭，用于客户端断开后先发送完缓冲区中的消息
+func (d *Delay) Idle() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.queue) == 0 {
+		idle := make(chan struct{})
+		close(idle)
+		return idle
+	}
+	if d.idle == nil {
+		d.idle = make(chan struct{})
+	}
+	return d.idle
+}
+
+// Buffered 返回缓冲区中等待发送的时长
+func (d *Delay) Buffered() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.queue) == 0 {
+		return 0
+	}
+	return d.queue[len(d.queue)-1].at.Sub(d.queue[0].at)
+}
+
+// Dump 丢弃缓冲区中的所有消息，返回丢弃的时长，之后每个来源从下一个视频关键帧开始缓冲
+func (d *Delay) Dump() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var dropped time.Duration
+	if len(d.queue) > 0 {
+		dropped = d.queue[len(d.queue)-1].at.Sub(d.queue[0].at)
+	}
+	for _, e := range d.queue {
+		d.skip[e.conn.source] = true
+	}
+	d.queue = nil
+	d.checkIdle()
+	return dropped
+}
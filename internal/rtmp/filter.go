@@ -0,0 +1,159 @@
+package rtmp
+
+// 发往远程服务器的轨道过滤：去掉视频(纯音频转发)、去掉音频或丢弃数据消息，onMetaData 中对应的字段同时删除
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	amf "github.com/zhangpeihao/goamf"
+	"io"
+	"strings"
+)
+
+// TrackFilter 需要去掉的轨道，零值为不过滤
+type TrackFilter struct {
+	NoVideo bool // 去掉视频，只转发音频
+	NoAudio bool // 去掉音频
+	NoData  bool // 丢弃onMetaData以外的数据消息，例如onCuePoint、onTextData
+}
+
+// ParseTrackFilter 解析 "novideo+nodata" 形式的过滤选项，空字符串为不过滤
+func ParseTrackFilter(s string) (TrackFilter, error) {
+	var f TrackFilter
+	for _, option := range strings.Split(s, "+") {
+		switch strings.ToLower(strings.TrimSpace(option)) {
+		case "":
+		case "novideo":
+			f.NoVideo = true
+		case "noaudio":
+			f.NoAudio = true
+		case "nodata":
+			f.NoData = true
+		default:
+			return TrackFilter{}, fmt.Errorf("unknown track filter %q", option)
+		}
+	}
+	if f.NoVideo && f.NoAudio {
+		return TrackFilter{}, fmt.Errorf("track filter %q removes both audio and video", s)
+	}
+	return f, nil
+}
+
+// String 返回 ParseTrackFilter 的格式，不过滤时为空字符串
+func (f TrackFilter) String() string {
+	var options []string
+	if f.NoVideo {
+		options = append(options, "novideo")
+	}
+	if f.NoAudio {
+		options = append(options, "noaudio")
+	}
+	if f.NoData {
+		options = append(options, "nodata")
+	}
+	return strings.Join(options, "+")
+}
+
+// drops msg是否需要丢弃
+func (f TrackFilter) drops(msg *Message) bool {
+	switch msg.TypeID {
+	case TypeVideo:
+		return f.NoVideo
+	case TypeAudio:
+		return f.NoAudio
+	case TypeDataAMF0, TypeDataAMF3:
+		return f.NoData && !isMetadata(msg)
+	}
+	return false
+}
+
+// rewrite 从metadata中删除去掉的轨道的字段，msg 不是metadata或无法解析时原样返回
+func (f TrackFilter) rewrite(msg *Message) *Message {
+	if msg == nil || (!f.NoVideo && !f.NoAudio) || !isMetadata(msg) {
+		return msg
+	}
+	payload, err := stripMetadata(msg.Payload, msg.TypeID == TypeDataAMF3, func(key string) bool {
+		key = strings.ToLower(key)
+		if f.NoVideo && (strings.HasPrefix(key, "video") || videoMetadataKeys[key]) {
+			return true
+		}
+		return f.NoAudio && (strings.HasPrefix(key, "audio") || audioMetadataKeys[key])
+	})
+	if err != nil {
+		return msg
+	}
+	out := *msg
+	out.Payload = payload
+	return &out
+}
+
+// 不以video/audio开头的轨道相关metadata字段(小写)
+var (
+	videoMetadataKeys = map[string]bool{"width": true, "height": true, "framerate": true, "fps": true, "hasvideo": true, "haskeyframes": true, "keyframes": true}
+	audioMetadataKeys = map[string]bool{"stereo": true, "hasaudio": true}
+)
+
+// stripMetadata 删除metadata中remove返回true的字段，其它字段的顺序和编码保持不变
+func stripMetadata(payload []byte, amf3 bool, remove func(key string) bool) ([]byte, error) {
+	var out bytes.Buffer
+	if amf3 && len(payload) > 0 && payload[0] == 0 {
+		out.WriteByte(0)
+		payload = payload[1:]
+	}
+	r := bytes.NewReader(payload)
+	pos := func() int {
+		return len(payload) - r.Len()
+	}
+	// @setDataFrame、onMetaData 等名称
+	for r.Len() > 0 && payload[pos()] == amf.AMF0_STRING_MARKER {
+		if _, err := amf.ReadValue(r); err != nil {
+			return nil, err
+		}
+	}
+	if r.Len() == 0 {
+		return nil, fmt.Errorf("metadata has no properties")
+	}
+	out.Write(payload[:pos()])
+	marker, _ := r.ReadByte()
+	switch marker {
+	case amf.AMF0_ECMA_ARRAY_MARKER:
+		if _, err := r.Seek(4, io.SeekCurrent); err != nil || r.Len() == 0 {
+			return nil, fmt.Errorf("truncated metadata")
+		}
+	case amf.AMF0_OBJECT_MARKER:
+	default:
+		return nil, fmt.Errorf("unexpected metadata marker %d", marker)
+	}
+	var props bytes.Buffer
+	count := uint32(0)
+	for {
+		start := pos()
+		key, err := amf.ReadObjectName(r)
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			if end, err := r.ReadByte(); err != nil || end != amf.AMF0_OBJECT_END_MARKER {
+				return nil, fmt.Errorf("metadata object not terminated")
+			}
+			break
+		}
+		if _, err := amf.ReadValue(r); err != nil {
+			return nil, err
+		}
+		if remove(key) {
+			continue
+		}
+		props.Write(payload[start:pos()])
+		count++
+	}
+	out.WriteByte(marker)
+	if marker == amf.AMF0_ECMA_ARRAY_MARKER {
+		_ = binary.Write(&out, binary.BigEndian, count)
+	}
+	out.Write(props.Bytes())
+	out.Write([]byte{0, 0, amf.AMF0_OBJECT_END_MARKER})
+	out.Write(payload[pos():])
+	return out.Bytes(), nil
+}
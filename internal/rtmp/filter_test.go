@@ -0,0 +1,193 @@
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	amf "github.com/zhangpeihao/goamf"
+	"reflect"
+	"testing"
+)
+
+// metadataProps OBS发送的onMetaData字段，按发送顺序
+var metadataProps = []struct {
+	key   string
+	value interface{}
+}{
+	{"duration", 0.0},
+	{"fileSize", 0.0},
+	{"width", 1280.0},
+	{"height", 720.0},
+	{"videocodecid", 7.0},
+	{"videodatarate", 2500.0},
+	{"framerate", 30.0},
+	{"audiocodecid", 10.0},
+	{"audiodatarate", 160.0},
+	{"audiosamplerate", 48000.0},
+	{"audiosamplesize", 16.0},
+	{"audiochannels", 2.0},
+	{"stereo", true},
+	{"2.1", false},
+	{"encoder", "obs-output module"},
+}
+
+// metadataMessage 构造 @setDataFrame onMetaData 消息，ecma 为true时使用ECMA array，否则使用object
+func metadataMessage(t *testing.T, ecma bool, amf3 bool) *Message {
+	t.Helper()
+	var b bytes.Buffer
+	if amf3 {
+		b.WriteByte(0)
+	}
+	_, _ = amf.WriteString(&b, "@setDataFrame")
+	_, _ = amf.WriteString(&b, "onMetaData")
+	if ecma {
+		b.WriteByte(amf.AMF0_ECMA_ARRAY_MARKER)
+		_ = binary.Write(&b, binary.BigEndian, uint32(len(metadataProps)))
+	} else {
+		b.WriteByte(amf.AMF0_OBJECT_MARKER)
+	}
+	for _, p := range metadataProps {
+		if _, err := amf.WriteObjectName(&b, p.key); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := amf.WriteValue(&b, p.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.Write([]byte{0, 0, amf.AMF0_OBJECT_END_MARKER})
+	typeID := uint32(TypeDataAMF0)
+	if amf3 {
+		typeID = TypeDataAMF3
+	}
+	return &Message{TypeID: typeID, Payload: b.Bytes()}
+}
+
+// metadataKeys 返回metadata中的字段名，ECMA array的数量字段与实际字段数不同时测试失败
+func metadataKeys(t *testing.T, msg *Message) []string {
+	t.Helper()
+	var keys []string
+	payload, err := stripMetadata(msg.Payload, msg.TypeID == TypeDataAMF3, func(key string) bool {
+		keys = append(keys, key)
+		return false
+	})
+	if err != nil {
+		t.Fatalf("invalid metadata: %v", err)
+	}
+	if !bytes.Equal(payload, msg.Payload) {
+		t.Fatalf("metadata changed when no field is removed")
+	}
+	// ECMA array 的数量字段在两个名称之后
+	p := payload
+	if msg.TypeID == TypeDataAMF3 {
+		p = p[1:]
+	}
+	offset := 3 + len("@setDataFrame") + 3 + len("onMetaData")
+	if p[offset] == amf.AMF0_ECMA_ARRAY_MARKER {
+		if count := binary.BigEndian.Uint32(p[offset+1:]); int(count) != len(keys) {
+			t.Errorf("ECMA array count = %d, want %d", count, len(keys))
+		}
+	}
+	return keys
+}
+
+func TestTrackFilterRewrite(t *testing.T) {
+	audioOnly := []string{"duration", "fileSize", "audiocodecid", "audiodatarate", "audiosamplerate", "audiosamplesize",
+		"audiochannels", "stereo", "2.1", "encoder"}
+	videoOnly := []string{"duration", "fileSize", "width", "height", "videocodecid", "videodatarate", "framerate", "2.1", "encoder"}
+	tests := []struct {
+		name   string
+		filter TrackFilter
+		want   []string
+	}{
+		{"novideo", TrackFilter{NoVideo: true}, audioOnly},
+		{"noaudio", TrackFilter{NoAudio: true}, videoOnly},
+		{"novideo+nodata", TrackFilter{NoVideo: true, NoData: true}, audioOnly},
+	}
+	for _, tt := range tests {
+		for _, format := range []struct {
+			name       string
+			ecma, amf3 bool
+		}{{"ecma", true, false}, {"object", false, false}, {"amf3", true, true}} {
+			t.Run(tt.name+"/"+format.name, func(t *testing.T) {
+				msg := metadataMessage(t, format.ecma, format.amf3)
+				original := append([]byte(nil), msg.Payload...)
+				out := tt.filter.rewrite(msg)
+				if got := metadataKeys(t, out); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("keys = %v, want %v", got, tt.want)
+				}
+				if !bytes.Equal(msg.Payload, original) {
+					t.Errorf("original message was modified")
+				}
+			})
+		}
+	}
+}
+
+func TestTrackFilterRewriteUnchanged(t *testing.T) {
+	msg := metadataMessage(t, true, false)
+	// 不去掉音视频时不修改metadata
+	if out := (TrackFilter{NoData: true}).rewrite(msg); out != msg {
+		t.Errorf("nodata rewrote metadata")
+	}
+	// 不是metadata的数据消息原样返回
+	var b bytes.Buffer
+	_, _ = amf.WriteString(&b, "onCuePoint")
+	cue := &Message{TypeID: TypeDataAMF0, Payload: b.Bytes()}
+	if out := (TrackFilter{NoVideo: true}).rewrite(cue); out != cue {
+		t.Errorf("rewrote non-metadata message")
+	}
+	// 无法解析的metadata原样返回
+	broken := &Message{TypeID: TypeDataAMF0, Payload: msg.Payload[:len(msg.Payload)-10]}
+	if out := (TrackFilter{NoVideo: true}).rewrite(broken); out != broken {
+		t.Errorf("rewrote truncated metadata")
+	}
+	if out := (TrackFilter{NoVideo: true}).rewrite(nil); out != nil {
+		t.Errorf("rewrite(nil) = %v", out)
+	}
+}
+
+func TestTrackFilterDrops(t *testing.T) {
+	var b bytes.Buffer
+	_, _ = amf.WriteString(&b, "onCuePoint")
+	cue := &Message{TypeID: TypeDataAMF0, Payload: b.Bytes()}
+	metadata := metadataMessage(t, true, false)
+	video := &Message{TypeID: TypeVideo}
+	audio := &Message{TypeID: TypeAudio}
+
+	f := TrackFilter{NoVideo: true, NoData: true}
+	for _, tt := range []struct {
+		msg  *Message
+		want bool
+	}{{video, true}, {audio, false}, {cue, true}, {metadata, false}} {
+		if got := f.drops(tt.msg); got != tt.want {
+			t.Errorf("drops(type %d) = %v, want %v", tt.msg.TypeID, got, tt.want)
+		}
+	}
+	if (TrackFilter{NoAudio: true}).drops(video) || !(TrackFilter{NoAudio: true}).drops(audio) {
+		t.Errorf("noaudio drops wrong track")
+	}
+}
+
+func TestParseTrackFilter(t *testing.T) {
+	tests := []struct {
+		in   string
+		want TrackFilter
+	}{
+		{"", TrackFilter{}},
+		{"novideo", TrackFilter{NoVideo: true}},
+		{"NoAudio + nodata", TrackFilter{NoAudio: true, NoData: true}},
+	}
+	for _, tt := range tests {
+		got, err := ParseTrackFilter(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseTrackFilter(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+		if back, _ := ParseTrackFilter(got.String()); back != got {
+			t.Errorf("String round trip of %+v = %+v", got, back)
+		}
+	}
+	for _, in := range []string{"novideo+noaudio", "video", "novideo,nodata"} {
+		if _, err := ParseTrackFilter(in); err == nil {
+			t.Errorf("ParseTrackFilter(%q) succeeded", in)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package rtmp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	handshakeVersion = 3
+	handshakeSize    = 1536
+)
+
+// newHandshakePacket 生成C1/S1：4字节时间戳、4字节0和随机数据
+func newHandshakePacket() []byte {
+	p := make([]byte, handshakeSize)
+	binary.BigEndian.PutUint32(p, uint32(time.Now().Unix()))
+	_, _ = rand.Read(p[8:])
+	return p
+}
+
+// ServerHandshake 作为服务端与客户端完成简单握手
+func (c *Conn) ServerHandshake() error {
+	c0c1 := make([]byte, 1+handshakeSize)
+	_, err := io.ReadFull(c.reader.r, c0c1)
+	if err != nil {
+		return fmt.Errorf("read C0C1: %w", err)
+	}
+	if c0c1[0] != handshakeVersion {
+		return fmt.Errorf("unsupported rtmp version: %d", c0c1[0])
+	}
+	// S0 + S1 + S2(回显C1)
+	s := make([]byte, 0, 1+2*handshakeSize)
+	s = append(s, handshakeVersion)
+	s = append(s, newHandshakePacket()...)
+	s = append(s, c0c1[1:]...)
+	_, err = c.Conn.Write(s)
+	if err != nil {
+		return fmt.Errorf("write S0S1S2: %w", err)
+	}
+	_, err = io.ReadFull(c.reader.r, c0c1[1:])
+	if err != nil {
+		return fmt.Errorf("read C2: %w", err)
+	}
+	return nil
+}
+
+// ClientHandshake 作为客户端与服务端完成简单握手
+func (c *Conn) ClientHandshake() error {
+	c0c1 := append([]byte{handshakeVersion}, newHandshakePacket()...)
+	_, err := c.Conn.Write(c0c1)
+	if err != nil {
+		return fmt.Errorf("write C0C1: %w", err)
+	}
+	s0s1 := make([]byte, 1+handshakeSize)
+	_, err = io.ReadFull(c.reader.r, s0s1)
+	if err != nil {
+		return fmt.Errorf("read S0S1: %w", err)
+	}
+	if s0s1[0] != handshakeVersion {
+		return fmt.Errorf("unsupported rtmp version: %d", s0s1[0])
+	}
+	// C2 回显 S1
+	_, err = c.Conn.Write(s0s1[1:])
+	if err != nil {
+		return fmt.Errorf("write C2: %w", err)
+	}
+	_, err = io.ReadFull(c.reader.r, s0s1[1:])
+	if err != nil {
+		return fmt.Errorf("read S2: %w", err)
+	}
+	return nil
+}
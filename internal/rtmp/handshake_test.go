@@ -0,0 +1,74 @@
+package rtmp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestHandshake(t *testing.T) {
+	client, server := loopback(t)
+	done := make(chan error, 1)
+	go func() {
+		done <- server.ServerHandshake()
+	}()
+	if err := client.ClientHandshake(); err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server: %v", err)
+	}
+
+	// 握手后可以收发消息
+	go func() {
+		_ = client.WriteCommand(0, &Command{Name: "connect", TransID: 1})
+	}()
+	msg, err := server.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd, err := decodeCommand(msg); err != nil || cmd.Name != "connect" {
+		t.Errorf("read %v, %v after handshake", cmd, err)
+	}
+}
+
+func TestServerHandshakeEchoesC1(t *testing.T) {
+	client, server := loopback(t)
+	done := make(chan error, 1)
+	go func() {
+		done <- server.ServerHandshake()
+	}()
+	c1 := payloadOf(handshakeSize)
+	if _, err := client.Conn.Write(append([]byte{handshakeVersion}, c1...)); err != nil {
+		t.Fatal(err)
+	}
+	s := make([]byte, 1+2*handshakeSize)
+	if _, err := io.ReadFull(client.Conn, s); err != nil {
+		t.Fatal(err)
+	}
+	if s[0] != handshakeVersion {
+		t.Errorf("S0 = %d", s[0])
+	}
+	if !bytes.Equal(s[1+handshakeSize:], c1) {
+		t.Errorf("S2 does not echo C1")
+	}
+	// C2 回显 S1
+	if _, err := client.Conn.Write(s[1 : 1+handshakeSize]); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerHandshakeVersion(t *testing.T) {
+	client, server := loopback(t)
+	go func() {
+		_, _ = client.Conn.Write(append([]byte{6}, payloadOf(handshakeSize)...))
+	}()
+	err := server.ServerHandshake()
+	if err == nil || !strings.Contains(err.Error(), "unsupported rtmp version: 6") {
+		t.Errorf("err = %v, want unsupported version", err)
+	}
+}
@@ -0,0 +1,71 @@
+package rtmp
+
+// 音视频消息payload(FLV tag body)的判断
+
+import (
+	"bytes"
+	"rtmpproxy/internal/flv"
+)
+
+// isKeyframe 视频消息是否为关键帧
+func isKeyframe(payload []byte) bool {
+	tag, ok := flv.ParseVideoTag(payload)
+	return ok && tag.IsKeyframe()
+}
+
+// isVideoSequenceHeader 视频消息是否为sequence header
+func isVideoSequenceHeader(payload []byte) bool {
+	tag, ok := flv.ParseVideoTag(payload)
+	return ok && tag.IsSequenceHeader()
+}
+
+// isAudioSequenceHeader 音频消息是否为sequence header
+func isAudioSequenceHeader(payload []byte) bool {
+	tag, ok := flv.ParseAudioTag(payload)
+	return ok && tag.IsSequenceHeader()
+}
+
+// isMetadata 数据消息是否为@setDataFrame或onMetaData
+func isMetadata(msg *Message) bool {
+	payload := msg.Payload
+	if msg.TypeID == TypeDataAMF3 && len(payload) > 0 && payload[0] == 0 {
+		payload = payload[1:]
+	}
+	// AMF0 string marker + uint16长度
+	if len(payload) < 3 || payload[0] != 0x02 {
+		return false
+	}
+	n := int(payload[1])<<8 | int(payload[2])
+	if len(payload) < 3+n {
+		return false
+	}
+	name := string(payload[3 : 3+n])
+	return name == "@setDataFrame" || name == "onMetaData"
+}
+
+// AMF0编码的@setDataFrame和onMetaData，推流时metadata带有@setDataFrame前缀，FLV文件和播放时收到的metadata没有
+var (
+	setDataFrame = []byte("\x02\x00\x0d@setDataFrame")
+	onMetaData   = []byte("\x02\x00\x0aonMetaData")
+)
+
+// WithSetDataFrame 为onMetaData加上@setDataFrame前缀，用于发布FLV文件或播放时收到的metadata
+func WithSetDataFrame(payload []byte) []byte {
+	if !bytes.HasPrefix(payload, onMetaData) {
+		return payload
+	}
+	return append(append([]byte(nil), setDataFrame...), payload...)
+}
+
+// FLVTag 转换为FLV tag，数据消息去掉@setDataFrame前缀，AMF3数据消息转换为AMF0
+func FLVTag(msg *Message) *flv.Tag {
+	tag := &flv.Tag{Type: uint8(msg.TypeID), Timestamp: msg.Timestamp, Data: msg.Payload}
+	if msg.TypeID == TypeDataAMF0 || msg.TypeID == TypeDataAMF3 {
+		if msg.TypeID == TypeDataAMF3 && len(tag.Data) > 0 && tag.Data[0] == 0 {
+			tag.Data = tag.Data[1:]
+		}
+		tag.Type = flv.TagScript
+		tag.Data = bytes.TrimPrefix(tag.Data, setDataFrame)
+	}
+	return tag
+}
@@ -0,0 +1,45 @@
+package rtmp
+
+import (
+	"bytes"
+	"rtmpproxy/internal/flv"
+	"testing"
+)
+
+func TestWithSetDataFrame(t *testing.T) {
+	metadata := append(append([]byte(nil), onMetaData...), 0x03, 0x00, 0x00, 0x09)
+	payload := WithSetDataFrame(metadata)
+	if !bytes.Equal(payload, append(append([]byte(nil), setDataFrame...), metadata...)) {
+		t.Errorf("payload = %q, want @setDataFrame prefix", payload)
+	}
+	if !isMetadata(&Message{TypeID: TypeDataAMF0, Payload: payload}) {
+		t.Error("prefixed payload is not metadata")
+	}
+	// 已经带有前缀或其它数据消息不修改
+	for _, other := range [][]byte{payload, []byte("\x02\x00\x0aonCuePoint")} {
+		if got := WithSetDataFrame(other); !bytes.Equal(got, other) {
+			t.Errorf("WithSetDataFrame(%q) = %q", other, got)
+		}
+	}
+}
+
+func TestFLVTagMetadata(t *testing.T) {
+	metadata := append(append([]byte(nil), onMetaData...), 0x03, 0x00, 0x00, 0x09)
+	tests := []struct {
+		name string
+		msg  *Message
+	}{
+		{"amf0", &Message{TypeID: TypeDataAMF0, Timestamp: 10, Payload: WithSetDataFrame(metadata)}},
+		{"amf3", &Message{TypeID: TypeDataAMF3, Timestamp: 10, Payload: append([]byte{0}, WithSetDataFrame(metadata)...)}},
+	}
+	for _, tt := range tests {
+		tag := FLVTag(tt.msg)
+		if tag.Type != flv.TagScript || tag.Timestamp != 10 || !bytes.Equal(tag.Data, metadata) {
+			t.Errorf("%s: tag = %d at %d %q, want onMetaData script tag", tt.name, tag.Type, tag.Timestamp, tag.Data)
+		}
+	}
+	video := &Message{TypeID: TypeVideo, Timestamp: 20, Payload: []byte{0x17, 1, 0, 0, 0}}
+	if tag := FLVTag(video); tag.Type != flv.TagVideo || !bytes.Equal(tag.Data, video.Payload) {
+		t.Errorf("video tag = %+v", tag)
+	}
+}
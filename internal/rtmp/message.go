@@ -0,0 +1,190 @@
+package rtmp
+
+import (
+	"fmt"
+	"io"
+	"slices"
+)
+
+// RTMP消息类型
+const (
+	TypeSetChunkSize     = 1
+	TypeAbort            = 2
+	TypeAck              = 3
+	TypeUserControl      = 4
+	TypeWindowAckSize    = 5
+	TypeSetPeerBandwidth = 6
+	TypeAudio            = 8
+	TypeVideo            = 9
+	TypeDataAMF3         = 15
+	TypeCommandAMF3      = 17
+	TypeDataAMF0         = 18
+	TypeCommandAMF0      = 20
+)
+
+// 发送消息时使用的chunk stream id
+const (
+	csidControl = 2
+	csidCommand = 3
+	csidAudio   = 4
+	csidData    = 5
+	csidVideo   = 6
+)
+
+const (
+	defaultChunkSize = 128
+	maxChunkSize     = 0x7fffffff
+	maxChunkStreams  = 64       // 同时接收的chunk stream数量上限
+	maxCommandSize   = 64 << 10 // 推流或播放开始前的消息长度上限，此时只应收到命令
+	maxMessageSize   = 8 << 20  // 推流或播放开始后的消息长度上限
+	readStep         = 64 << 10 // 按实际收到的数据分段扩展消息缓冲区
+)
+
+// Message 一条完整的RTMP消息
+type Message struct {
+	CSID      uint32
+	TypeID    uint32
+	StreamID  uint32
+	Timestamp uint32
+	Payload   []byte
+}
+
+// chunkStream 单个chunk stream的接收状态
+type chunkStream struct {
+	header  rtmpChunkHeader
+	delta   uint32 // 上一条消息的时间戳增量，fmt 3开始新消息时使用
+	payload []byte
+	nread   int
+}
+
+// chunkReader 将多个交错的chunk stream组装成完整消息
+type chunkReader struct {
+	r         io.Reader
+	chunkSize int
+	maxSize   int // 消息长度上限，见 acceptMedia
+	streams   map[uint32]*chunkStream
+}
+
+func newChunkReader(r io.Reader) *chunkReader {
+	return &chunkReader{
+		r:         r,
+		chunkSize: defaultChunkSize,
+		maxSize:   maxCommandSize,
+		streams:   make(map[uint32]*chunkStream),
+	}
+}
+
+// acceptMedia 推流或播放开始后允许接收音视频大小的消息，之前只接收命令大小的消息
+func (cr *chunkReader) acceptMedia() {
+	cr.maxSize = maxMessageSize
+}
+
+// readMessage 读取chunk直到某个chunk stream上的消息完整
+func (cr *chunkReader) readMessage() (*Message, error) {
+	for {
+		ch, err := rtmpReadHeader(cr.r)
+		if err != nil {
+			return nil, err
+		}
+		cs := cr.streams[ch.csid]
+		if cs == nil {
+			if ch.format != 0 {
+				return nil, fmt.Errorf("chunk stream %d starts with format %d", ch.csid, ch.format)
+			}
+			if len(cr.streams) >= maxChunkStreams {
+				return nil, fmt.Errorf("too many chunk streams: %d", len(cr.streams)+1)
+			}
+			cs = &chunkStream{}
+			cr.streams[ch.csid] = cs
+		}
+
+		last := cs.header
+		switch ch.format {
+		case 0:
+			cs.delta = 0
+		case 1:
+			cs.delta = ch.timestamp
+			ch.timestamp = last.timestamp + cs.delta
+			ch.streamid = last.streamid
+		case 2:
+			cs.delta = ch.timestamp
+			ch.timestamp = last.timestamp + cs.delta
+			ch.length = last.length
+			ch.typeid = last.typeid
+			ch.streamid = last.streamid
+		case 3:
+			if last.extended {
+				// 扩展时间戳与chunk header中的相同，使用之前的值
+				var ext [4]byte
+				if _, err := io.ReadFull(cr.r, ext[:]); err != nil {
+					return nil, err
+				}
+				ch.extended = true
+			}
+			ch.timestamp = last.timestamp
+			if cs.nread == 0 {
+				// 新消息沿用上一条消息的时间戳增量
+				ch.timestamp += cs.delta
+			}
+			ch.length = last.length
+			ch.typeid = last.typeid
+			ch.streamid = last.streamid
+		}
+		if cs.nread != 0 && ch.format != 3 {
+			return nil, fmt.Errorf("chunk stream %d interrupted by format %d", ch.csid, ch.format)
+		}
+		if int(ch.length) > cr.maxSize {
+			return nil, fmt.Errorf("message length %d exceeds %d", ch.length, cr.maxSize)
+		}
+		cs.header = *ch
+
+		// 不按消息头中的长度预先分配，避免未发送数据的chunk占用内存
+		n := min(cr.chunkSize, int(ch.length)-cs.nread)
+		for n > 0 {
+			step := min(n, readStep)
+			cs.payload = slices.Grow(cs.payload, step)[:cs.nread+step]
+			if _, err = io.ReadFull(cr.r, cs.payload[cs.nread:]); err != nil {
+				return nil, err
+			}
+			cs.nread += step
+			n -= step
+		}
+		if cs.nread < int(ch.length) {
+			continue
+		}
+
+		msg := &Message{
+			CSID:      ch.csid,
+			TypeID:    ch.typeid,
+			StreamID:  ch.streamid,
+			Timestamp: ch.timestamp,
+			Payload:   cs.payload,
+		}
+		cs.payload = nil
+		cs.nread = 0
+		return msg, nil
+	}
+}
+
+// abort 丢弃chunk stream上未接收完整的消息
+func (cr *chunkReader) abort(csid uint32) {
+	if cs := cr.streams[csid]; cs != nil {
+		cs.payload = nil
+		cs.nread = 0
+	}
+}
+
+// csidByType 根据消息类型选择发送使用的chunk stream id
+func csidByType(typeID uint32) uint32 {
+	switch typeID {
+	case TypeAudio:
+		return csidAudio
+	case TypeVideo:
+		return csidVideo
+	case TypeDataAMF0, TypeDataAMF3:
+		return csidData
+	case TypeCommandAMF0, TypeCommandAMF3:
+		return csidCommand
+	}
+	return csidControl
+}
@@ -0,0 +1,268 @@
+package rtmp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// chunk 编码一个chunk header和数据
+func chunk(ch rtmpChunkHeader, data []byte) []byte {
+	return append(ch.asBytes(), data...)
+}
+
+func payloadOf(n int) []byte {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte(i)
+	}
+	return p
+}
+
+func readAll(t *testing.T, cr *chunkReader, n int) []*Message {
+	t.Helper()
+	var list []*Message
+	for i := 0; i < n; i++ {
+		msg, err := cr.readMessage()
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		list = append(list, msg)
+	}
+	return list
+}
+
+func TestChunkReaderInterleaved(t *testing.T) {
+	video, audio := payloadOf(300), payloadOf(10)
+	var buf bytes.Buffer
+	buf.Write(chunk(rtmpChunkHeader{format: 0, csid: csidVideo, timestamp: 40, length: 300, typeid: TypeVideo, streamid: 1}, video[:128]))
+	buf.Write(chunk(rtmpChunkHeader{format: 0, csid: csidAudio, timestamp: 23, length: 10, typeid: TypeAudio, streamid: 1}, audio))
+	buf.Write(chunk(rtmpChunkHeader{format: 3, csid: csidVideo}, video[128:256]))
+	buf.Write(chunk(rtmpChunkHeader{format: 3, csid: csidVideo}, video[256:]))
+
+	list := readAll(t, newChunkReader(&buf), 2)
+	if msg := list[0]; msg.TypeID != TypeAudio || msg.Timestamp != 23 || !bytes.Equal(msg.Payload, audio) {
+		t.Errorf("first message = type %d at %d", msg.TypeID, msg.Timestamp)
+	}
+	if msg := list[1]; msg.TypeID != TypeVideo || msg.Timestamp != 40 || msg.StreamID != 1 || !bytes.Equal(msg.Payload, video) {
+		t.Errorf("second message = type %d at %d, %d bytes", msg.TypeID, msg.Timestamp, len(msg.Payload))
+	}
+}
+
+func TestChunkReaderHeaderFormats(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(chunk(rtmpChunkHeader{format: 0, csid: csidAudio, timestamp: 1000, length: 4, typeid: TypeVideo, streamid: 1}, payloadOf(4)))
+	// fmt 1 修改长度和类型，时间戳为增量
+	buf.Write(chunk(rtmpChunkHeader{format: 1, csid: csidAudio, timestamp: 40, length: 5, typeid: TypeAudio}, payloadOf(5)))
+	// fmt 2 只有时间戳增量
+	buf.Write(chunk(rtmpChunkHeader{format: 2, csid: csidAudio, timestamp: 20}, payloadOf(5)))
+	// fmt 3 开始的新消息沿用上一条消息的增量
+	buf.Write(chunk(rtmpChunkHeader{format: 3, csid: csidAudio}, payloadOf(5)))
+
+	list := readAll(t, newChunkReader(&buf), 4)
+	want := []struct {
+		typeID    uint32
+		timestamp uint32
+		length    int
+	}{
+		{TypeVideo, 1000, 4},
+		{TypeAudio, 1040, 5},
+		{TypeAudio, 1060, 5},
+		{TypeAudio, 1080, 5},
+	}
+	for i, w := range want {
+		msg := list[i]
+		if msg.TypeID != w.typeID || msg.Timestamp != w.timestamp || len(msg.Payload) != w.length || msg.StreamID != 1 {
+			t.Errorf("message %d = type %d at %d, %d bytes on stream %d, want type %d at %d, %d bytes",
+				i, msg.TypeID, msg.Timestamp, len(msg.Payload), msg.StreamID, w.typeID, w.timestamp, w.length)
+		}
+	}
+}
+
+func TestChunkReaderExtendedTimestamp(t *testing.T) {
+	payload := payloadOf(300)
+	var buf bytes.Buffer
+	ch := &rtmpChunkHeader{csid: csidVideo, timestamp: 0x1000000, typeid: TypeVideo, streamid: 1}
+	if err := writeRtmpMessage(&buf, ch, payload, defaultChunkSize); err != nil {
+		t.Fatal(err)
+	}
+	ch.timestamp = 0x1000021
+	if err := writeRtmpMessage(&buf, ch, payload[:10], defaultChunkSize); err != nil {
+		t.Fatal(err)
+	}
+
+	list := readAll(t, newChunkReader(&buf), 2)
+	if list[0].Timestamp != 0x1000000 || !bytes.Equal(list[0].Payload, payload) {
+		t.Errorf("first message at %#x, %d bytes", list[0].Timestamp, len(list[0].Payload))
+	}
+	if list[1].Timestamp != 0x1000021 || len(list[1].Payload) != 10 {
+		t.Errorf("second message at %#x, %d bytes", list[1].Timestamp, len(list[1].Payload))
+	}
+}
+
+func TestChunkReaderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks [][]byte
+		want   string
+	}{
+		{
+			name:   "starts with fmt 1",
+			chunks: [][]byte{chunk(rtmpChunkHeader{format: 1, csid: csidAudio, length: 1, typeid: TypeAudio}, []byte{0})},
+			want:   "starts with format 1",
+		},
+		{
+			name: "interrupted",
+			chunks: [][]byte{
+				chunk(rtmpChunkHeader{format: 0, csid: csidAudio, length: 200, typeid: TypeAudio}, payloadOf(128)),
+				chunk(rtmpChunkHeader{format: 0, csid: csidAudio, length: 1, typeid: TypeAudio}, []byte{0}),
+			},
+			want: "interrupted by format 0",
+		},
+		{
+			name:   "command too long",
+			chunks: [][]byte{(&rtmpChunkHeader{format: 0, csid: csidCommand, length: maxCommandSize + 1, typeid: TypeCommandAMF0}).asBytes()},
+			want:   "exceeds",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newChunkReader(bytes.NewReader(bytes.Join(tt.chunks, nil)))
+			var err error
+			for err == nil {
+				_, err = cr.readMessage()
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkReaderStreamLimit(t *testing.T) {
+	var buf bytes.Buffer
+	for csid := uint32(csidControl); csid < csidControl+maxChunkStreams+1; csid++ {
+		// 每个chunk stream只发送一个chunk，消息都不完整
+		buf.Write(chunk(rtmpChunkHeader{format: 0, csid: csid, length: 200, typeid: TypeAudio}, payloadOf(128)))
+	}
+	_, err := newChunkReader(&buf).readMessage()
+	if err == nil || !strings.Contains(err.Error(), "too many chunk streams") {
+		t.Fatalf("err = %v, want too many chunk streams", err)
+	}
+}
+
+func TestChunkReaderAcceptMedia(t *testing.T) {
+	length := uint32(maxCommandSize * 4)
+	header := (&rtmpChunkHeader{format: 0, csid: csidVideo, length: length, typeid: TypeVideo}).asBytes()
+
+	cr := newChunkReader(bytes.NewReader(header))
+	if _, err := cr.readMessage(); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Fatalf("err = %v, want message length exceeds before publish", err)
+	}
+
+	// 开始推流后接受，缓冲区随收到的数据增长，不按消息头中的长度分配
+	cr = newChunkReader(bytes.NewReader(append(header, payloadOf(100)...)))
+	cr.acceptMedia()
+	cr.chunkSize = maxChunkSize
+	if _, err := cr.readMessage(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("err = %v, want unexpected EOF", err)
+	}
+	if size := cap(cr.streams[csidVideo].payload); size > readStep {
+		t.Errorf("allocated %d bytes for 100 received", size)
+	}
+
+	header = (&rtmpChunkHeader{format: 0, csid: csidVideo, length: maxMessageSize + 1, typeid: TypeVideo}).asBytes()
+	cr = newChunkReader(bytes.NewReader(header))
+	cr.acceptMedia()
+	if _, err := cr.readMessage(); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("err = %v, want message length exceeds", err)
+	}
+}
+
+func TestChunkReaderAbort(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(chunk(rtmpChunkHeader{format: 0, csid: csidVideo, length: 200, typeid: TypeVideo}, payloadOf(128)))
+	cr := newChunkReader(&buf)
+	if _, err := cr.readMessage(); !errors.Is(err, io.EOF) {
+		t.Fatalf("err = %v, want EOF", err)
+	}
+	cr.abort(csidVideo)
+	buf.Write(chunk(rtmpChunkHeader{format: 0, csid: csidVideo, length: 3, typeid: TypeVideo}, []byte{1, 2, 3}))
+	msg, err := cr.readMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg.Payload, []byte{1, 2, 3}) {
+		t.Errorf("payload = %v after abort", msg.Payload)
+	}
+}
+
+// loopback 返回通过TCP连接的两个 Conn，未握手
+func loopback(t *testing.T) (*Conn, *Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	if server == nil {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return NewConn(client), NewConn(server)
+}
+
+func TestConnControlMessages(t *testing.T) {
+	client, server := loopback(t)
+	payload := payloadOf(5000)
+	go func() {
+		_ = client.SetChunkSize(4096)
+		_ = client.writeControl(TypeWindowAckSize, 1000)
+		_ = client.writeUserControl(eventPingRequest, 7)
+		_ = client.WriteMessage(&Message{TypeID: TypeDataAMF0, Payload: payload})
+	}()
+	msg, err := server.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 控制消息在内部处理，只返回数据消息
+	if msg.TypeID != TypeDataAMF0 || !bytes.Equal(msg.Payload, payload) {
+		t.Errorf("read type %d, %d bytes", msg.TypeID, len(msg.Payload))
+	}
+	if server.reader.chunkSize != 4096 || server.windowSize != 1000 {
+		t.Errorf("chunk size %d, window %d", server.reader.chunkSize, server.windowSize)
+	}
+
+	// 对端收到Ping Response和超过窗口后的Acknowledgement
+	var ping, ack bool
+	for !ping || !ack {
+		msg, err := client.reader.readMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch msg.TypeID {
+		case TypeUserControl:
+			ping = bytes.Equal(msg.Payload, []byte{0, eventPingResponse, 0, 0, 0, 7})
+		case TypeAck:
+			ack = true
+		}
+	}
+}
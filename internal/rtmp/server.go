@@ -0,0 +1,157 @@
+package rtmp
+
+import (
+	"fmt"
+	amf "github.com/zhangpeihao/goamf"
+	"net/url"
+	"strings"
+)
+
+// 响应客户端createStream时分配的流ID
+const clientStreamID = 1
+
+// PublishRequest 客户端connect和publish命令中的推流参数
+type PublishRequest struct {
+	App        string     // connect中的app，不包含?后的参数
+	TcUrl      string     // connect中的tcUrl
+	SwfUrl     string     // connect中的swfUrl
+	PageUrl    string     // connect中的pageUrl
+	FlashVer   string     // connect中的flashVer
+	StreamName string     // publish中的streamName，不包含?后的参数
+	Type       string     // publish的类型，live/record/append
+	Args       url.Values // tcUrl、app和streamName中?后的参数
+	Connect    amf.Object // 原始的connect command object
+}
+
+// ReadPublish 作为服务端响应客户端的connect/createStream等命令，直到收到publish，
+// checkConnect 不为nil时在响应connect前调用，返回错误则以 NetConnection.Connect.Rejected 拒绝连接
+func (c *Conn) ReadPublish(checkConnect func(req *PublishRequest) error) (*PublishRequest, error) {
+	req := &PublishRequest{Args: url.Values{}}
+	for {
+		msg, err := c.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if msg.TypeID != TypeCommandAMF0 && msg.TypeID != TypeCommandAMF3 {
+			continue
+		}
+		cmd, err := decodeCommand(msg)
+		if err != nil {
+			return nil, err
+		}
+		switch cmd.Name {
+		case "connect":
+			obj := cmd.object(0)
+			if obj == nil {
+				return nil, fmt.Errorf("invalid connect command: %v", cmd)
+			}
+			req.Connect = obj
+			req.App, _ = obj["app"].(string)
+			req.TcUrl, _ = obj["tcUrl"].(string)
+			req.SwfUrl, _ = obj["swfUrl"].(string)
+			req.PageUrl, _ = obj["pageUrl"].(string)
+			req.FlashVer, _ = obj["flashVer"].(string)
+			req.App = splitArgs(req.App, req.Args)
+			if i := strings.IndexByte(req.TcUrl, '?'); i >= 0 {
+				splitArgs(req.TcUrl[i:], req.Args)
+			}
+			if checkConnect != nil {
+				if err = checkConnect(req); err != nil {
+					_ = c.rejectConnect(cmd.TransID, err.Error())
+					return nil, err
+				}
+			}
+			if err = c.acceptConnect(cmd.TransID); err != nil {
+				return nil, err
+			}
+		case "releaseStream", "FCPublish":
+			err = c.WriteCommand(0, &Command{Name: "_result", TransID: cmd.TransID, Args: []interface{}{nil, amf.Undefined{}}})
+			if err != nil {
+				return nil, err
+			}
+		case "createStream":
+			err = c.WriteCommand(0, &Command{Name: "_result", TransID: cmd.TransID, Args: []interface{}{nil, clientStreamID}})
+			if err != nil {
+				return nil, err
+			}
+		case "publish":
+			if req.Connect == nil {
+				return nil, fmt.Errorf("publish before connect")
+			}
+			req.StreamName = splitArgs(cmd.stringArg(1), req.Args)
+			req.Type = cmd.stringArg(2)
+			return req, nil
+		case "play":
+			return nil, fmt.Errorf("play is not supported")
+		case "deleteStream", "FCUnpublish", "closeStream":
+			return nil, fmt.Errorf("client closed stream before publish")
+		}
+	}
+}
+
+// acceptConnect 响应connect命令
+func (c *Conn) acceptConnect(transID float64) error {
+	if err := c.writeControl(TypeWindowAckSize, defaultWindowAckSize); err != nil {
+		return err
+	}
+	if err := c.writeSetPeerBandwidth(defaultWindowAckSize, 2); err != nil {
+		return err
+	}
+	if err := c.SetChunkSize(4096); err != nil {
+		return err
+	}
+	info := statusObject("status", "NetConnection.Connect.Success", "Connection succeeded.")
+	info["objectEncoding"] = 0
+	return c.WriteCommand(0, &Command{
+		Name:    "_result",
+		TransID: transID,
+		Args: []interface{}{
+			amf.Object{"fmsVer": "FMS/3,0,1,123", "capabilities": 31},
+			info,
+		},
+	})
+}
+
+// rejectConnect 以 NetConnection.Connect.Rejected 拒绝connect命令
+func (c *Conn) rejectConnect(transID float64, description string) error {
+	return c.WriteCommand(0, &Command{
+		Name:    "_error",
+		TransID: transID,
+		Args:    []interface{}{nil, statusObject("error", "NetConnection.Connect.Rejected", description)},
+	})
+}
+
+// AcceptPublish 通知客户端推流开始
+func (c *Conn) AcceptPublish(req *PublishRequest) error {
+	c.reader.acceptMedia()
+	if err := c.writeUserControl(eventStreamBegin, clientStreamID); err != nil {
+		return err
+	}
+	return c.WriteCommand(clientStreamID, &Command{
+		Name: "onStatus",
+		Args: []interface{}{nil, statusObject("status", "NetStream.Publish.Start", req.StreamName+" is now published.")},
+	})
+}
+
+// RejectPublish 拒绝客户端的推流请求，code 通常为 NetStream.Publish.BadName
+func (c *Conn) RejectPublish(code string, description string) error {
+	return c.WriteCommand(clientStreamID, &Command{
+		Name: "onStatus",
+		Args: []interface{}{nil, statusObject("error", code, description)},
+	})
+}
+
+// splitArgs 拆分name?args，参数追加到args中，返回name
+func splitArgs(name string, args url.Values) string {
+	i := strings.IndexByte(name, '?')
+	if i < 0 {
+		return name
+	}
+	query, err := url.ParseQuery(name[i+1:])
+	if err == nil {
+		for k, v := range query {
+			args[k] = append(args[k], v...)
+		}
+	}
+	return name[:i]
+}
@@ -0,0 +1,772 @@
+package rtmp
+
+// 远程服务器上的推流，可以由不同的来源(客户端、垫片等)轮流写入
+
+import (
+	"errors"
+	"fmt"
+	amf "github.com/zhangpeihao/goamf"
+	"log/slog"
+	"net"
+	"rtmpproxy/internal/flv"
+	"sync"
+	"time"
+)
+
+// 切换来源后新来源的第一条消息与之前最后一条消息的时间戳间隔(毫秒)
+const sourceGap = 40
+
+// 发送命令前等待发送队列清空的最长时间
+const flushTimeout = 2 * time.Second
+
+// Source 写入 Upstream 的一路媒体，缓存自己的metadata和sequence header，切换到该来源时重新发送
+type Source struct {
+	name        string
+	metadata    *Message
+	videoHeader *Message
+	audioHeader *Message
+	started     bool   // 激活后已写入音视频帧，offset 已确定
+	offset      uint32 // 来源时间戳到远程服务器时间戳的偏移
+	audio       track
+	video       track
+}
+
+// track 来源中音频或视频的时间戳，修复时间戳跳变后两个轨道的偏移可能暂时不同
+type track struct {
+	offset     uint32
+	last       uint32    // 最近一次写入的远程服务器时间戳
+	at         time.Time // 最近一次写入的时间
+	written    bool
+	correction uint32 // 另一个轨道修复跳变时的修正量，该轨道出现同样的跳变时使用，保持音视频对齐
+	corrected  bool
+}
+
+// NewSource 创建来源，name 用于日志
+func NewSource(name string) *Source {
+	return &Source{name: name}
+}
+
+func (s *Source) Name() string {
+	return s.name
+}
+
+// cache 缓存metadata和sequence header
+func (s *Source) cache(msg *Message) {
+	switch {
+	case msg.TypeID == TypeVideo && isVideoSequenceHeader(msg.Payload):
+		s.videoHeader = msg
+	case msg.TypeID == TypeAudio && isAudioSequenceHeader(msg.Payload):
+		s.audioHeader = msg
+	case (msg.TypeID == TypeDataAMF0 || msg.TypeID == TypeDataAMF3) && isMetadata(msg):
+		s.metadata = msg
+	}
+}
+
+// Upstream 远程服务器上的一路推流，同一时间只转发当前来源的消息，客户端断开后可以继续存在
+type Upstream struct {
+	connect     amf.Object // 发往远程服务器的connect参数，不含app和tcUrl
+	publishType string
+	logger      *slog.Logger
+
+	wmu sync.Mutex // 保证消息按顺序写入
+
+	mu           sync.Mutex
+	conn         net.Conn
+	server       *Conn
+	streamID     uint32
+	streamName   string
+	publishing   bool
+	closed       bool
+	done         chan struct{}
+	retired      Traffic // 切换前的远程服务器连接的流量
+	active       *Source
+	pending      *Source // 等待关键帧后切换的来源
+	resend       bool    // 下一条消息之前需要重新发送当前来源的metadata和sequence header
+	waitKeyframe bool
+	written      bool          // 已经写入过音视频帧
+	lastOut      uint32        // 写入的最大时间戳
+	jump         time.Duration // 修复超过该阈值的时间戳跳变，0 为不修复
+	filter       TrackFilter
+	tee          *flv.Writer           // 发往远程服务器的消息同时写入的FLV
+	backwards    func(from, to uint32) // 见 ObserveBackwards
+
+	// 发送队列，见 QueueWrites
+	queueLimit time.Duration
+	queue      []*outgoing
+	changed    *sync.Cond // 队列变化或推流结束时通知，使用mu
+	congested  bool       // 队列中的消息等待超过 queueLimit 的一半
+	dropping   bool       // 丢弃视频直到下一个关键帧
+	drops      Drops
+}
+
+// outgoing 发送队列中的消息
+type outgoing struct {
+	server *Conn
+	msg    Message
+	at     time.Time // 进入队列的时间
+}
+
+// Drops 发送队列拥塞时丢弃的视频帧数
+type Drops struct {
+	Disposable uint64 // 不被其它帧参考的帧
+	Inter      uint64 // 跳到下一个关键帧时丢弃的帧
+}
+
+// Total 丢弃的视频帧总数
+func (d Drops) Total() uint64 {
+	return d.Disposable + d.Inter
+}
+
+// NewUpstream 创建推流，connect 为发往远程服务器的connect参数，logger 为nil时使用默认logger
+func NewUpstream(connect amf.Object, publishType string, logger *slog.Logger) *Upstream {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	u := &Upstream{
+		connect:     connect,
+		publishType: publishType,
+		logger:      logger,
+		done:        make(chan struct{}),
+	}
+	u.changed = sync.NewCond(&u.mu)
+	return u
+}
+
+// RepairJumps 修复来源中超过threshold的时间戳跳变：时间戳回退或者比实际经过的时间多出threshold以上时，
+// 从之前的位置继续，同一来源的音视频使用相同的修正量，较小的回退保持为之前的时间戳，threshold 为0时不修复
+func (u *Upstream) RepairJumps(threshold time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.jump = threshold
+}
+
+// ObserveBackwards 来源的音频或视频帧时间戳回退时调用fn，from 和 to 为修复前远程服务器上的时间戳，
+// 无论是否启用 RepairJumps 都会调用，观察者得到的时间戳已经修复，无法自己检测回退。fn 在持有锁时调用，不能调用 Upstream 的方法
+func (u *Upstream) ObserveBackwards(fn func(from, to uint32)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.backwards = fn
+}
+
+// FilterTracks 之后写入的消息按f去掉音频、视频或数据消息，并从metadata中删除对应的字段
+func (u *Upstream) FilterTracks(f TrackFilter) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.filter = f
+}
+
+// Tee 之后发往远程服务器的音视频和数据消息同时写入w，写入失败后不再写入
+func (u *Upstream) Tee(w *flv.Writer) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.tee = w
+}
+
+// copyToTee 将发往远程服务器的msg写入 Tee 的FLV
+func (u *Upstream) copyToTee(msg *Message) {
+	u.mu.Lock()
+	w := u.tee
+	u.mu.Unlock()
+	if w == nil || (msg.TypeID != TypeAudio && msg.TypeID != TypeVideo && msg.TypeID != TypeDataAMF0 && msg.TypeID != TypeDataAMF3) {
+		return
+	}
+	if err := w.WriteTag(FLVTag(msg)); err != nil {
+		u.logger.Warn("Failed to write FLV output", "err", err)
+		u.mu.Lock()
+		if u.tee == w {
+			u.tee = nil
+		}
+		u.mu.Unlock()
+	}
+}
+
+// QueueWrites 通过发送队列写入远程服务器，远程服务器跟不上时丢弃视频帧以限制延迟：
+// 队列中的消息等待超过limit的一半时丢弃不被参考的帧，超过limit时丢弃之后的非关键帧直到下一个关键帧，
+// 超过limit的两倍时阻塞写入，音频、sequence header和数据消息不会被丢弃，只能调用一次
+func (u *Upstream) QueueWrites(limit time.Duration) {
+	if limit <= 0 {
+		return
+	}
+	u.mu.Lock()
+	u.queueLimit = limit
+	u.mu.Unlock()
+	go u.send()
+}
+
+// Drops 返回发送队列丢弃的视频帧数
+func (u *Upstream) Drops() Drops {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.drops
+}
+
+// Publish 在远程服务器上握手、connect并publish，已经在推流时切换到新的远程服务器，
+// 成功后结束旧连接上的推流，新连接会先收到缓存的metadata和sequence header，视频从下一个关键帧开始
+func (u *Upstream) Publish(conn net.Conn, appName string, playUrl string, streamName string) error {
+	server := NewConn(conn)
+	streamID, err := u.publishServer(server, appName, playUrl, streamName)
+	if err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	if u.closed {
+		u.mu.Unlock()
+		return net.ErrClosed
+	}
+	old, oldStreamID, oldStreamName := u.server, u.streamID, u.streamName
+	u.conn, u.server, u.streamID, u.streamName = conn, server, streamID, streamName
+	u.publishing = true
+	u.resend, u.waitKeyframe = true, true
+	u.discard(old)
+	var oldIn, oldOut uint64
+	if old != nil {
+		oldIn, oldOut = old.Traffic()
+		u.retired.RemoteIn += oldIn
+		u.retired.RemoteOut += oldOut
+	}
+	u.mu.Unlock()
+	go u.watch(server)
+	if old == nil {
+		u.logger.Info("Publishing to remote server", "stream_id", streamID)
+		return nil
+	}
+	u.logger.Info("Switched to new remote server", "stream_id", streamID)
+
+	_ = old.Unpublish(oldStreamName, oldStreamID)
+	_ = old.Close()
+	// 切换之后旧连接上写入的流量
+	in, out := old.Traffic()
+	u.mu.Lock()
+	u.retired.RemoteIn += in - oldIn
+	u.retired.RemoteOut += out - oldOut
+	u.mu.Unlock()
+	return nil
+}
+
+// publishServer 在server上握手、connect并publish，返回远程服务器分配的流ID
+func (u *Upstream) publishServer(server *Conn, appName string, playUrl string, streamName string) (uint32, error) {
+	_ = server.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer func() {
+		_ = server.SetDeadline(time.Time{})
+	}()
+	err := server.ClientHandshake()
+	if err != nil {
+		return 0, fmt.Errorf("server handshake error: %w", err)
+	}
+	err = server.Connect(u.connectObject(appName, playUrl))
+	if err != nil {
+		return 0, err
+	}
+	u.logger.Debug("RTMP connect to remote server succeeded")
+	return server.Publish(streamName, u.publishType)
+}
+
+// connectObject 在connect参数中设置远程服务器的app和地址
+func (u *Upstream) connectObject(appName string, playUrl string) amf.Object {
+	obj := make(amf.Object, len(u.connect)+3)
+	for k, v := range u.connect {
+		obj[k] = v
+	}
+	obj["app"] = appName
+	obj["swfUrl"] = playUrl
+	obj["tcUrl"] = playUrl
+	// log输出
+	keys := []string{"app", "flashVer", "swfUrl", "tcUrl", "type"}
+	attrs := make([]any, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, slog.Any(k, obj[k]))
+	}
+	u.logger.Debug("RTMP connect params", attrs...)
+	return obj
+}
+
+// watch 处理远程服务器的消息，当前的远程服务器断开时结束推流
+func (u *Upstream) watch(server *Conn) {
+	err := u.handleServerMessages(server)
+	u.mu.Lock()
+	current := u.server == server && !u.closed
+	u.mu.Unlock()
+	if !current {
+		// 切换后旧连接上的错误可以忽略
+		return
+	}
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		u.logger.Warn("Remote server connection closed", "err", err)
+	}
+	u.Close()
+}
+
+// handleServerMessages 处理Server消息，远程服务器返回错误状态时结束
+func (u *Upstream) handleServerMessages(server *Conn) error {
+	for {
+		cmd, err := server.readCommand()
+		if err != nil {
+			return err
+		}
+		if cmd.Name != "onStatus" {
+			continue
+		}
+		level, code, description := cmd.status()
+		u.logger.Info("Remote server status", "code", code, "description", description)
+		if level == "error" {
+			return fmt.Errorf("remote server error: %s %s", code, description)
+		}
+	}
+}
+
+// Activate 切换到src并取消等待中的 Switch，之后只转发src的消息，时间戳从之前写入的位置继续，视频从下一个关键帧开始
+func (u *Upstream) Activate(src *Source) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.active == src {
+		u.pending = nil
+		return
+	}
+	if u.active != nil {
+		u.logger.Info("Switched stream source", "from", u.active.name, "to", src.name)
+	}
+	u.active, u.pending = src, nil
+	src.started = false
+	u.resend, u.waitKeyframe = true, true
+}
+
+// Switch 在src的下一个视频关键帧切换到src，之前继续转发当前来源，src 没有视频时在下一个音频帧切换
+func (u *Upstream) Switch(src *Source) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.active == src {
+		u.pending = nil
+		return
+	}
+	u.pending = src
+}
+
+// switchPoint msg是否是src可以开始转发的位置
+func switchPoint(src *Source, msg *Message) bool {
+	switch msg.TypeID {
+	case TypeVideo:
+		return isKeyframe(msg.Payload) && !isVideoSequenceHeader(msg.Payload)
+	case TypeAudio:
+		return src.videoHeader == nil && !isAudioSequenceHeader(msg.Payload)
+	}
+	return false
+}
+
+// Active 返回当前的来源
+func (u *Upstream) Active() *Source {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.active
+}
+
+// Write 写入src的一条音视频或数据消息，src 不是当前来源时只缓存metadata和sequence header，
+// 直到 Switch 的切换位置，msg 不会被修改
+func (u *Upstream) Write(src *Source, msg *Message) error {
+	_, _, err := u.write(src, msg)
+	return err
+}
+
+// write 见 Write，返回msg在远程服务器上的时间戳，msg 没有写入时返回false
+func (u *Upstream) write(src *Source, msg *Message) (uint32, bool, error) {
+	u.wmu.Lock()
+	defer u.wmu.Unlock()
+
+	u.mu.Lock()
+	if u.closed {
+		u.mu.Unlock()
+		return 0, false, net.ErrClosed
+	}
+	if u.filter.drops(msg) {
+		// 不缓存去掉的轨道，只有音频的来源在音频帧切换
+		u.mu.Unlock()
+		return 0, false, nil
+	}
+	if u.active != src {
+		src.cache(msg)
+		if u.pending != src || !switchPoint(src, msg) {
+			u.mu.Unlock()
+			return 0, false, nil
+		}
+		if u.active != nil {
+			u.logger.Info("Switched stream source", "from", u.active.name, "to", src.name)
+		}
+		u.active, u.pending = src, nil
+		src.started = false
+		u.resend, u.waitKeyframe = true, false
+	}
+	if !src.started && isFrame(msg) {
+		// 第一个来源的时间戳从0开始，之后的来源从之前写入的位置继续
+		src.started = true
+		src.offset = -msg.Timestamp
+		if u.written {
+			src.offset = u.lastOut + sourceGap - msg.Timestamp
+		}
+		src.audio, src.video = track{}, track{}
+	}
+	if msg.TypeID == TypeVideo && u.waitKeyframe && !isVideoSequenceHeader(msg.Payload) {
+		if !isKeyframe(msg.Payload) {
+			u.mu.Unlock()
+			return 0, false, nil
+		}
+		u.waitKeyframe = false
+	}
+	timestamp := u.lastOut // 第一个音视频帧之前的metadata和sequence header
+	if src.started {
+		timestamp = u.retime(src, msg)
+	}
+	var resend []*Message
+	if u.resend {
+		u.resend = false
+		resend = []*Message{u.filter.rewrite(src.metadata), src.videoHeader, src.audioHeader}
+	}
+	src.cache(msg)
+	out := u.filter.rewrite(msg)
+	if isFrame(msg) {
+		if !u.written || int32(timestamp-u.lastOut) > 0 {
+			u.lastOut = timestamp
+		}
+		u.written = true
+	}
+	server, streamID := u.server, u.streamID
+	if u.queueLimit > 0 {
+		written := u.enqueue(server, streamID, timestamp, append(resend, out))
+		u.mu.Unlock()
+		return timestamp, written, nil
+	}
+	u.mu.Unlock()
+
+	for _, header := range resend {
+		if header == nil {
+			continue
+		}
+		if err := u.writeTo(server, header, streamID, timestamp); err != nil {
+			return 0, false, u.writeError(server, err)
+		}
+	}
+	err := u.writeError(server, u.writeTo(server, out, streamID, timestamp))
+	return timestamp, err == nil, err
+}
+
+// enqueue 将msgs放入发送队列，最后一条为写入的消息，之前为重新发送的metadata和sequence header，
+// 返回最后一条消息是否没有被丢弃，调用时需持有u.mu
+func (u *Upstream) enqueue(server *Conn, streamID uint32, timestamp uint32, msgs []*Message) bool {
+	now := time.Now()
+	msg := msgs[len(msgs)-1]
+	if msg.TypeID == TypeVideo && isFrame(msg) && !u.admit(msg, now) {
+		msgs = msgs[:len(msgs)-1]
+	}
+	for _, m := range msgs {
+		if m == nil {
+			continue
+		}
+		out := &outgoing{server: server, msg: *m, at: now}
+		out.msg.CSID = csidByType(m.TypeID)
+		out.msg.StreamID = streamID
+		out.msg.Timestamp = timestamp
+		u.queue = append(u.queue, out)
+	}
+	u.changed.Broadcast()
+	// 丢弃视频后仍然积压时阻塞客户端
+	for !u.closed && u.queueAge(time.Now()) > 2*u.queueLimit {
+		u.changed.Wait()
+	}
+	return len(msgs) > 0 && msgs[len(msgs)-1] == msg
+}
+
+// admit 根据发送队列的积压决定是否发送视频帧msg，丢弃时同时丢弃队列中可以丢弃的帧，调用时需持有u.mu
+func (u *Upstream) admit(msg *Message, now time.Time) bool {
+	keyframe := isKeyframe(msg.Payload)
+	age := u.queueAge(now)
+	if !u.congested && age > u.queueLimit/2 {
+		u.congested = true
+		u.logger.Warn("Remote server congested, dropping video frames", "latency", age.Round(time.Millisecond).String())
+	}
+	if u.dropping {
+		if !keyframe {
+			u.drops.Inter++
+			return false
+		}
+		u.dropping = false
+	}
+	if age > u.queueLimit {
+		// 丢弃队列中的非关键帧，之后从下一个关键帧开始，只丢弃GOP的结尾不影响解码
+		u.drop(&u.drops.Inter, func(m *Message) bool { return !isKeyframe(m.Payload) })
+		if !keyframe {
+			u.dropping = true
+			u.drops.Inter++
+			return false
+		}
+		return true
+	}
+	if age > u.queueLimit/2 {
+		lengthSize := u.lengthSize()
+		u.drop(&u.drops.Disposable, func(m *Message) bool { return flv.IsDisposable(m.Payload, lengthSize) })
+		if flv.IsDisposable(msg.Payload, lengthSize) {
+			u.drops.Disposable++
+			return false
+		}
+	}
+	return true
+}
+
+// drop 丢弃队列中满足match的视频帧，计入counter，调用时需持有u.mu
+func (u *Upstream) drop(counter *uint64, match func(m *Message) bool) {
+	kept := u.queue[:0]
+	for _, out := range u.queue {
+		if out.msg.TypeID == TypeVideo && isFrame(&out.msg) && match(&out.msg) {
+			*counter++
+			continue
+		}
+		kept = append(kept, out)
+	}
+	clear(u.queue[len(kept):])
+	u.queue = kept
+}
+
+// lengthSize 当前来源视频的NALU长度字段的字节数，调用时需持有u.mu
+func (u *Upstream) lengthSize() int {
+	if u.active == nil || u.active.videoHeader == nil {
+		return 4
+	}
+	return flv.NALULengthSize(u.active.videoHeader.Payload)
+}
+
+// queueAge 队列中最早的消息等待的时长，调用时需持有u.mu
+func (u *Upstream) queueAge(now time.Time) time.Duration {
+	if len(u.queue) == 0 {
+		return 0
+	}
+	return now.Sub(u.queue[0].at)
+}
+
+// discard 丢弃队列中发往server的消息，用于切换远程服务器，调用时需持有u.mu
+func (u *Upstream) discard(server *Conn) {
+	if server == nil {
+		return
+	}
+	kept := u.queue[:0]
+	for _, out := range u.queue {
+		if out.server != server {
+			kept = append(kept, out)
+		}
+	}
+	clear(u.queue[len(kept):])
+	u.queue = kept
+	u.changed.Broadcast()
+}
+
+// send 按顺序发送队列中的消息，直到推流结束或远程服务器写入失败
+func (u *Upstream) send() {
+	for {
+		u.mu.Lock()
+		for !u.closed && len(u.queue) == 0 {
+			u.changed.Wait()
+		}
+		if u.closed {
+			u.mu.Unlock()
+			return
+		}
+		out := u.queue[0]
+		u.queue[0] = nil
+		u.queue = u.queue[1:]
+		u.mu.Unlock()
+
+		err := u.writeError(out.server, out.server.WriteMessage(&out.msg))
+		if err == nil {
+			u.copyToTee(&out.msg)
+		}
+
+		u.mu.Lock()
+		if u.congested && len(u.queue) == 0 {
+			u.congested = false
+			u.logger.Info("Remote server congestion cleared", "dropped_disposable", u.drops.Disposable, "dropped_inter", u.drops.Inter)
+		}
+		u.changed.Broadcast()
+		u.mu.Unlock()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				u.logger.Warn("Failed to write to remote server", "err", err)
+			}
+			u.Close()
+			return
+		}
+	}
+}
+
+// flush 等待发送队列清空，最多等待timeout
+func (u *Upstream) flush(timeout time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.queueLimit <= 0 {
+		return
+	}
+	expired := false
+	timer := time.AfterFunc(timeout, func() {
+		u.mu.Lock()
+		expired = true
+		u.changed.Broadcast()
+		u.mu.Unlock()
+	})
+	defer timer.Stop()
+	for !u.closed && !expired && len(u.queue) > 0 {
+		u.changed.Wait()
+	}
+}
+
+// retime 返回msg在远程服务器上的时间戳，启用 RepairJumps 时修复跳变，调用时需持有u.mu
+func (u *Upstream) retime(src *Source, msg *Message) uint32 {
+	var t, other *track
+	kind := "video"
+	switch msg.TypeID {
+	case TypeVideo:
+		t, other = &src.video, &src.audio
+	case TypeAudio:
+		t, other, kind = &src.audio, &src.video, "audio"
+	default:
+		return msg.Timestamp + src.offset
+	}
+	now := time.Now()
+	if !t.written {
+		t.offset = src.offset
+	}
+	out := msg.Timestamp + t.offset
+	if t.written && int32(out-t.last) < 0 && u.backwards != nil && isFrame(msg) {
+		u.backwards(t.last, out)
+	}
+	if t.written && u.jump > 0 {
+		if jumped(t, out, now, u.jump) {
+			if t.corrected && !jumped(t, out+t.correction, now, u.jump) {
+				// 另一个轨道已经修复过同样的跳变
+				t.offset += t.correction
+			} else {
+				correction := t.last + sourceGap - out
+				t.offset += correction
+				other.correction, other.corrected = correction, true
+				u.logger.Warn("Repaired timestamp discontinuity", "source", src.name, "type", kind,
+					"jump_ms", int32(out-t.last))
+			}
+			t.corrected = false
+			src.offset = t.offset
+			out = msg.Timestamp + t.offset
+		} else if int32(out-t.last) < 0 {
+			// 较小的回退，保持时间戳单调
+			out = t.last
+		}
+	}
+	t.last, t.at, t.written = out, now, true
+	return out
+}
+
+// jumped out相对于轨道上一次写入的时间戳是否回退或者比实际经过的时间多出threshold以上
+func jumped(t *track, out uint32, now time.Time, threshold time.Duration) bool {
+	delta := int64(int32(out - t.last))
+	limit := threshold.Milliseconds()
+	return delta <= -limit || delta-now.Sub(t.at).Milliseconds() > limit
+}
+
+// timestamp 返回src的时间戳对应的远程服务器时间戳，src 不是当前来源或尚未写入音视频帧时返回false
+func (u *Upstream) timestamp(src *Source, timestamp uint32) (uint32, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.active != src || !src.started {
+		return 0, false
+	}
+	return timestamp + src.offset, true
+}
+
+func (u *Upstream) writeTo(server *Conn, msg *Message, streamID uint32, timestamp uint32) error {
+	out := *msg
+	out.CSID = csidByType(msg.TypeID)
+	out.StreamID = streamID
+	out.Timestamp = timestamp
+	if err := server.WriteMessage(&out); err != nil {
+		return err
+	}
+	u.copyToTee(&out)
+	return nil
+}
+
+// writeError 写入时远程服务器已经切换的错误可以忽略
+func (u *Upstream) writeError(server *Conn, err error) error {
+	if err == nil {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.server != server && !u.closed {
+		return nil
+	}
+	return err
+}
+
+// command 在远程服务器上发送命令，args 中的第二个参数替换为远程服务器的streamName或流ID
+func (u *Upstream) command(name string, transID float64) error {
+	// 命令在队列中的音视频之后发送
+	u.flush(flushTimeout)
+	u.mu.Lock()
+	server, streamID, streamName := u.server, u.streamID, u.streamName
+	u.mu.Unlock()
+	if server == nil {
+		return net.ErrClosed
+	}
+	var arg interface{} = streamName
+	if name == "deleteStream" {
+		arg = streamID
+	}
+	return server.WriteCommand(0, &Command{Name: name, TransID: transID, Args: []interface{}{nil, arg}})
+}
+
+// Traffic 返回远程服务器各方向的字节数，包括切换前的连接
+func (u *Upstream) Traffic() (in uint64, out uint64) {
+	u.mu.Lock()
+	server, retired := u.server, u.retired
+	u.mu.Unlock()
+	in, out = retired.RemoteIn, retired.RemoteOut
+	if server != nil {
+		serverIn, serverOut := server.Traffic()
+		in += serverIn
+		out += serverOut
+	}
+	return in, out
+}
+
+// Done 推流结束后关闭
+func (u *Upstream) Done() <-chan struct{} {
+	return u.done
+}
+
+// Closed 推流是否已经结束
+func (u *Upstream) Closed() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.closed
+}
+
+// Unpublish 通知远程服务器结束推流后关闭连接
+func (u *Upstream) Unpublish() {
+	u.mu.Lock()
+	publishing, streamID := u.publishing, u.streamID
+	u.mu.Unlock()
+	if publishing {
+		_ = u.command("FCUnpublish", 0)
+		_ = u.command("deleteStream", 0)
+		u.logger.Info("Unpublished stream on remote server", "stream_id", streamID)
+	}
+	u.Close()
+}
+
+// Close 关闭远程服务器的连接
+func (u *Upstream) Close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.closed {
+		return
+	}
+	u.closed = true
+	close(u.done)
+	u.changed.Broadcast()
+	if u.conn != nil {
+		_ = u.conn.Close()
+	}
+}
@@ -0,0 +1,214 @@
+package rtmp
+
+import (
+	"testing"
+	"time"
+)
+
+var (
+	videoFrame = []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0x00}
+	audioFrame = []byte{0xaf, 0x01, 0x00}
+)
+
+// retimer 直接调用 retime，模拟一个已经开始写入、偏移为0的来源
+type retimer struct {
+	t         *testing.T
+	u         *Upstream
+	src       *Source
+	backwards [][2]uint32
+}
+
+func newRetimer(t *testing.T, threshold time.Duration) *retimer {
+	r := &retimer{t: t, u: NewUpstream(nil, "live", nil), src: NewSource("test")}
+	r.u.RepairJumps(threshold)
+	r.u.ObserveBackwards(func(from, to uint32) {
+		r.backwards = append(r.backwards, [2]uint32{from, to})
+	})
+	r.src.started = true
+	return r
+}
+
+func (r *retimer) video(timestamp uint32, want uint32) {
+	r.t.Helper()
+	r.check(&Message{TypeID: TypeVideo, Timestamp: timestamp, Payload: videoFrame}, want)
+}
+
+func (r *retimer) audio(timestamp uint32, want uint32) {
+	r.t.Helper()
+	r.check(&Message{TypeID: TypeAudio, Timestamp: timestamp, Payload: audioFrame}, want)
+}
+
+func (r *retimer) check(msg *Message, want uint32) {
+	r.t.Helper()
+	r.u.mu.Lock()
+	got := r.u.retime(r.src, msg)
+	r.u.mu.Unlock()
+	if got != want {
+		r.t.Errorf("type %d timestamp %d: retimed to %d, want %d", msg.TypeID, msg.Timestamp, got, want)
+	}
+}
+
+func TestRetimeForwardJump(t *testing.T) {
+	r := newRetimer(t, time.Second)
+	r.video(0, 0)
+	r.audio(0, 0)
+	r.video(33, 33)
+	r.audio(23, 23)
+
+	// 没有经过实际时间的向前跳变，从之前的位置继续
+	r.video(60033, 33+sourceGap)
+	r.video(60066, 33+sourceGap+33)
+	// 音频出现同样的跳变时使用视频的修正量，保持音视频对齐
+	r.audio(60046, 46+sourceGap)
+	r.audio(60069, 69+sourceGap)
+	if len(r.backwards) != 0 {
+		t.Errorf("backwards = %v, want none", r.backwards)
+	}
+}
+
+func TestRetimeBackwardJump(t *testing.T) {
+	r := newRetimer(t, time.Second)
+	r.video(60000, 60000)
+	r.audio(60000, 60000)
+	r.video(60033, 60033)
+
+	r.video(0, 60033+sourceGap)
+	r.video(33, 60033+sourceGap+33)
+	// 音频使用视频的修正量，与视频保持原来的间隔
+	r.audio(10, 60033+sourceGap+10)
+	if len(r.backwards) != 2 || r.backwards[0] != [2]uint32{60033, 0} || r.backwards[1] != [2]uint32{60000, 10} {
+		t.Errorf("backwards = %v, want [60033 0] [60000 10]", r.backwards)
+	}
+}
+
+func TestRetimeSmallBackwards(t *testing.T) {
+	r := newRetimer(t, time.Second)
+	r.video(1000, 1000)
+	r.video(1033, 1033)
+	// 小于阈值的回退保持为之前的时间戳
+	r.video(1010, 1033)
+	r.video(1066, 1066)
+	if len(r.backwards) != 1 || r.backwards[0] != [2]uint32{1033, 1010} {
+		t.Errorf("backwards = %v, want [1033 1010]", r.backwards)
+	}
+}
+
+func TestRetimeWithoutRepair(t *testing.T) {
+	r := newRetimer(t, 0)
+	r.video(60000, 60000)
+	r.video(0, 0)
+	r.video(90000, 90000)
+	// 不修复时也报告回退
+	if len(r.backwards) != 1 || r.backwards[0] != [2]uint32{60000, 0} {
+		t.Errorf("backwards = %v, want [60000 0]", r.backwards)
+	}
+}
+
+func TestRetimeSequenceHeader(t *testing.T) {
+	r := newRetimer(t, time.Second)
+	r.video(1000, 1000)
+	// sequence header 不是帧，不报告回退
+	r.check(&Message{TypeID: TypeVideo, Timestamp: 900, Payload: []byte{0x17, 0x00, 0x00, 0x00, 0x00}}, 1000)
+	if len(r.backwards) != 0 {
+		t.Errorf("backwards = %v, want none", r.backwards)
+	}
+}
+
+func TestJumped(t *testing.T) {
+	now := time.Now()
+	threshold := time.Second
+	tests := []struct {
+		name    string
+		last    uint32
+		elapsed time.Duration
+		out     uint32
+		want    bool
+	}{
+		{"normal", 1000, 33 * time.Millisecond, 1033, false},
+		{"forward within elapsed time", 1000, 5 * time.Second, 6000, false},
+		{"forward beyond elapsed time", 1000, 0, 2001, true},
+		{"forward at threshold", 1000, 0, 2000, false},
+		{"small backwards", 1000, 0, 500, false},
+		{"backwards at threshold", 1000, 0, 0, true},
+		{"backwards across wrap", 10, 0, 0xffffffff - 2000, true},
+		{"forward across wrap", 0xffffffff - 10, 0, 22, false},
+	}
+	for _, tt := range tests {
+		tr := &track{last: tt.last, at: now.Add(-tt.elapsed), written: true}
+		if got := jumped(tr, tt.out, now, threshold); got != tt.want {
+			t.Errorf("%s: jumped = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+var (
+	keyframe        = []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x05, 0x88}
+	referenceFrame  = []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x41, 0x9a}
+	disposableFrame = []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x01, 0x9e}
+)
+
+// queuedUpstream 返回启用发送队列但不发送的推流，队列中已有等待了age的消息
+func queuedUpstream(limit time.Duration, age time.Duration, payloads ...[]byte) *Upstream {
+	u := NewUpstream(nil, "live", nil)
+	u.queueLimit = limit
+	at := time.Now().Add(-age)
+	for _, payload := range payloads {
+		u.queue = append(u.queue, &outgoing{msg: Message{TypeID: TypeVideo, Payload: payload}, at: at})
+	}
+	return u
+}
+
+func admit(u *Upstream, payload []byte) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.admit(&Message{TypeID: TypeVideo, Payload: payload}, time.Now())
+}
+
+func TestAdmitUncongested(t *testing.T) {
+	u := queuedUpstream(time.Second, 100*time.Millisecond, referenceFrame, disposableFrame)
+	if !admit(u, disposableFrame) || !admit(u, referenceFrame) {
+		t.Error("frame dropped without congestion")
+	}
+	if len(u.queue) != 2 || u.Drops().Total() != 0 {
+		t.Errorf("queue = %d, drops = %+v, want 2 and none", len(u.queue), u.Drops())
+	}
+}
+
+func TestAdmitDropsDisposable(t *testing.T) {
+	u := queuedUpstream(time.Second, 600*time.Millisecond, keyframe, disposableFrame, referenceFrame, disposableFrame)
+	// 超过limit的一半时丢弃不被参考的帧
+	if admit(u, disposableFrame) {
+		t.Error("disposable frame admitted")
+	}
+	if !admit(u, referenceFrame) {
+		t.Error("reference frame dropped")
+	}
+	if len(u.queue) != 2 {
+		t.Errorf("queue = %d, want keyframe and reference frame", len(u.queue))
+	}
+	if drops := u.Drops(); drops.Disposable != 3 || drops.Inter != 0 {
+		t.Errorf("drops = %+v, want 3 disposable", drops)
+	}
+}
+
+func TestAdmitSkipsToKeyframe(t *testing.T) {
+	u := queuedUpstream(time.Second, 1500*time.Millisecond, keyframe, referenceFrame, referenceFrame)
+	u.queue = append(u.queue, &outgoing{msg: Message{TypeID: TypeAudio, Payload: audioFrame}, at: time.Now()})
+	// 超过limit时丢弃队列中的非关键帧和之后直到下一个关键帧的帧，音频不丢弃
+	if admit(u, referenceFrame) {
+		t.Error("reference frame admitted")
+	}
+	if len(u.queue) != 2 || u.queue[0].msg.TypeID != TypeVideo || u.queue[1].msg.TypeID != TypeAudio {
+		t.Errorf("queue = %d, want keyframe and audio", len(u.queue))
+	}
+	u.queue = nil
+	if admit(u, disposableFrame) {
+		t.Error("frame admitted before keyframe")
+	}
+	if !admit(u, keyframe) || !admit(u, referenceFrame) {
+		t.Error("frames dropped after keyframe")
+	}
+	if drops := u.Drops(); drops.Inter != 4 || drops.Disposable != 0 {
+		t.Errorf("drops = %+v, want 4 inter", drops)
+	}
+}
@@ -0,0 +1,301 @@
+package rtmptest
+
+// 进程内的RTMP测试工具：模拟推流客户端和直播平台的推流服务器，通过 Pipe 或回环地址连接 RTMPConnection 或代理，
+// 检查服务器收到的命令，用于插件和拦截器的测试
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"rtmpproxy/internal/rtmp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Ingest 模拟直播平台的推流服务器，记录收到的命令、推流请求和音视频消息，可以同时处理多个连接
+type Ingest struct {
+	// CheckConnect 不为nil时在响应connect前调用，返回错误则拒绝连接
+	CheckConnect func(req *rtmp.PublishRequest) error
+
+	mu        sync.Mutex
+	changed   chan struct{} // 记录更新时关闭并替换
+	commands  []*rtmp.Command
+	publishes []*rtmp.PublishRequest
+	messages  []*rtmp.Message
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+}
+
+// NewIngest 创建推流服务器，需要调用 Serve 或 ServeConn 接受连接
+func NewIngest() *Ingest {
+	return &Ingest{changed: make(chan struct{}), conns: make(map[net.Conn]struct{})}
+}
+
+// StartIngest 在回环地址上启动推流服务器，返回服务器和监听地址(host:port)，测试结束时关闭
+func StartIngest(t testing.TB) (*Ingest, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	i := NewIngest()
+	go func() {
+		_ = i.Serve(listener)
+	}()
+	t.Cleanup(i.Close)
+	return i, listener.Addr().String()
+}
+
+// Serve 接受listener上的连接，直到listener关闭
+func (i *Ingest) Serve(listener net.Listener) error {
+	i.mu.Lock()
+	i.listeners = append(i.listeners, listener)
+	i.mu.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			_ = i.ServeConn(conn)
+		}()
+	}
+}
+
+// ServeConn 处理一个连接，接受推流后记录消息直到连接断开，可以用于 Pipe 的一端
+func (i *Ingest) ServeConn(conn net.Conn) error {
+	i.mu.Lock()
+	i.conns[conn] = struct{}{}
+	i.mu.Unlock()
+	defer func() {
+		_ = conn.Close()
+		i.mu.Lock()
+		delete(i.conns, conn)
+		i.mu.Unlock()
+	}()
+
+	c := rtmp.NewConn(conn)
+	c.ObserveCommands(func(cmd *rtmp.Command) {
+		i.record(func() {
+			i.commands = append(i.commands, cmd)
+		})
+	})
+	if err := c.ServerHandshake(); err != nil {
+		return err
+	}
+	req, err := c.ReadPublish(i.CheckConnect)
+	if err != nil {
+		return err
+	}
+	if err = c.AcceptPublish(req); err != nil {
+		return err
+	}
+	i.record(func() {
+		i.publishes = append(i.publishes, req)
+	})
+	for {
+		msg, err := c.ReadMessage()
+		if err != nil {
+			return err
+		}
+		switch msg.TypeID {
+		case rtmp.TypeAudio, rtmp.TypeVideo, rtmp.TypeDataAMF0, rtmp.TypeDataAMF3:
+			i.record(func() {
+				i.messages = append(i.messages, msg)
+			})
+		}
+	}
+}
+
+// Close 关闭所有listener和连接
+func (i *Ingest) Close() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, listener := range i.listeners {
+		_ = listener.Close()
+	}
+	for conn := range i.conns {
+		_ = conn.Close()
+	}
+}
+
+// record 在锁内更新记录并通知等待者
+func (i *Ingest) record(update func()) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	update()
+	close(i.changed)
+	i.changed = make(chan struct{})
+}
+
+// Commands 返回收到的所有命令，按接收顺序
+func (i *Ingest) Commands() []*rtmp.Command {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]*rtmp.Command(nil), i.commands...)
+}
+
+// CommandNames 返回收到的所有命令的名称
+func (i *Ingest) CommandNames() []string {
+	var names []string
+	for _, cmd := range i.Commands() {
+		names = append(names, cmd.Name)
+	}
+	return names
+}
+
+// Publishes 返回已接受的推流请求
+func (i *Ingest) Publishes() []*rtmp.PublishRequest {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]*rtmp.PublishRequest(nil), i.publishes...)
+}
+
+// Messages 返回推流后收到的音视频和数据消息
+func (i *Ingest) Messages() []*rtmp.Message {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]*rtmp.Message(nil), i.messages...)
+}
+
+// Wait 等到cond返回true，超时返回false，cond 在每次收到命令或消息后调用
+func (i *Ingest) Wait(timeout time.Duration, cond func() bool) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		i.mu.Lock()
+		changed := i.changed
+		i.mu.Unlock()
+		if cond() {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return cond()
+		}
+	}
+}
+
+// WaitPublish 等到接受第n个推流请求(从1开始)并返回，超时时测试失败
+func (i *Ingest) WaitPublish(t testing.TB, n int, timeout time.Duration) *rtmp.PublishRequest {
+	t.Helper()
+	if !i.Wait(timeout, func() bool { return len(i.Publishes()) >= n }) {
+		t.Fatalf("timed out waiting for publish %d, got %d", n, len(i.Publishes()))
+	}
+	return i.Publishes()[n-1]
+}
+
+// WaitMessages 等到收到至少n条音视频或数据消息，超时时测试失败
+func (i *Ingest) WaitMessages(t testing.TB, n int, timeout time.Duration) []*rtmp.Message {
+	t.Helper()
+	if !i.Wait(timeout, func() bool { return len(i.Messages()) >= n }) {
+		t.Fatalf("timed out waiting for %d messages, got %d", n, len(i.Messages()))
+	}
+	return i.Messages()
+}
+
+// WaitCommand 等到收到名为name的命令并返回，超时时测试失败
+func (i *Ingest) WaitCommand(t testing.TB, name string, timeout time.Duration) *rtmp.Command {
+	t.Helper()
+	find := func() *rtmp.Command {
+		for _, cmd := range i.Commands() {
+			if cmd.Name == name {
+				return cmd
+			}
+		}
+		return nil
+	}
+	if !i.Wait(timeout, func() bool { return find() != nil }) {
+		t.Fatalf("timed out waiting for command %s, got %v", name, i.CommandNames())
+	}
+	return find()
+}
+
+// AssertCommands 检查收到的命令按顺序包含names，中间可以有其它命令
+func (i *Ingest) AssertCommands(t testing.TB, names ...string) {
+	t.Helper()
+	got := i.CommandNames()
+	next := 0
+	for _, name := range got {
+		if next < len(names) && name == names[next] {
+			next++
+		}
+	}
+	if next < len(names) {
+		t.Errorf("commands %v do not contain %s in order (missing %s)", got, strings.Join(names, ","), names[next])
+	}
+}
+
+// AssertConnect 检查最后一个推流请求的connect object中key的值
+func (i *Ingest) AssertConnect(t testing.TB, key string, want interface{}) {
+	t.Helper()
+	publishes := i.Publishes()
+	if len(publishes) == 0 {
+		t.Errorf("no publish received")
+		return
+	}
+	got, ok := publishes[len(publishes)-1].Connect[key]
+	if !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("connect %s = %v, want %v", key, describe(got, ok), want)
+	}
+}
+
+// AssertPublish 检查最后一个推流请求的app和streamName
+func (i *Ingest) AssertPublish(t testing.TB, app string, streamName string) {
+	t.Helper()
+	publishes := i.Publishes()
+	if len(publishes) == 0 {
+		t.Errorf("no publish received")
+		return
+	}
+	req := publishes[len(publishes)-1]
+	if req.App != app || req.StreamName != streamName {
+		t.Errorf("publish %s/%s, want %s/%s", req.App, req.StreamName, app, streamName)
+	}
+}
+
+func describe(v interface{}, ok bool) string {
+	if !ok {
+		return "<missing>"
+	}
+	return fmt.Sprintf("%#v", v)
+}
+
+// Pipe 返回一对已连接的回环TCP连接，测试结束时关闭。net.Pipe 没有缓冲，RTMP两端会同时发送命令
+// (例如服务端响应releaseStream时客户端正在发送FCPublish)而互相阻塞，连接 RTMPConnection 时使用 Pipe 代替
+func Pipe(t testing.TB) (net.Conn, net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	server := <-accepted
+	if server == nil {
+		_ = client.Close()
+		t.Fatalf("accept failed")
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return client, server
+}
@@ -0,0 +1,168 @@
+package rtmptest
+
+import (
+	"bytes"
+	"fmt"
+	amf "github.com/zhangpeihao/goamf"
+	"net"
+	"rtmpproxy/internal/rtmp"
+	"sync"
+	"time"
+)
+
+// 连接和握手的超时时间
+const dialTimeout = 5 * time.Second
+
+// WriteFrames 的帧间隔(毫秒)和关键帧间隔(帧)
+const (
+	frameInterval = 33
+	gopFrames     = 30
+)
+
+// 可以被 flv.ParseVideoTag/ParseAudioTag 解析的sequence header，H.264 High 3.1 1280x720、AAC LC 44100Hz 双声道
+var (
+	avcSequenceHeader = []byte{
+		0x17, 0x00, 0x00, 0x00, 0x00,
+		0x01, 0x64, 0x00, 0x1f, 0xff, 0xe1,
+		0x00, 0x19, 0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50, 0x05, 0xbb, 0x01, 0x10, 0x00, 0x00,
+		0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x03, 0xc0, 0xf1, 0x83, 0x19, 0x60,
+		0x01, 0x00, 0x06, 0x68, 0xeb, 0xe3, 0xcb, 0x22, 0xc0,
+	}
+	aacSequenceHeader = []byte{0xaf, 0x00, 0x12, 0x10}
+)
+
+// Publisher 模拟OBS等推流客户端，推流后在后台读取并丢弃服务端的消息，避免服务端发送的消息填满连接的缓冲后阻塞
+type Publisher struct {
+	conn       *rtmp.Conn
+	streamName string
+	StreamID   uint32 // 服务端分配的流ID
+
+	mu   sync.Mutex
+	next uint32 // WriteFrames 的下一帧时间戳
+	sent int    // WriteFrames 已发送的视频帧数
+}
+
+// Dial 连接addr并推流，见 Publish
+func Dial(addr string, app string, streamName string, connect amf.Object) (*Publisher, error) {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Publish(conn, app, streamName, connect)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Publish 在conn上握手、connect并推流streamName，connect 为nil时使用OBS的connect参数，没有app时设置为app
+func Publish(conn net.Conn, app string, streamName string, connect amf.Object) (*Publisher, error) {
+	if connect == nil {
+		connect = amf.Object{
+			"type":     "nonprivate",
+			"flashVer": "FMLE/3.0 (compatible; FMSc/1.0)",
+			"tcUrl":    "rtmp://127.0.0.1/" + app,
+			"swfUrl":   "rtmp://127.0.0.1/" + app,
+		}
+	}
+	if _, ok := connect["app"]; !ok {
+		connect["app"] = app
+	}
+	c := rtmp.NewConn(conn)
+	_ = conn.SetDeadline(time.Now().Add(dialTimeout))
+	if err := c.ClientHandshake(); err != nil {
+		return nil, fmt.Errorf("handshake error: %w", err)
+	}
+	if err := c.Connect(connect); err != nil {
+		return nil, err
+	}
+	streamID, err := c.Publish(streamName, "live")
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	go func() {
+		for {
+			if _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	return &Publisher{conn: c, streamName: streamName, StreamID: streamID}, nil
+}
+
+// Write 在推流的消息流上发送msg
+func (p *Publisher) Write(msg *rtmp.Message) error {
+	out := *msg
+	out.StreamID = p.StreamID
+	return p.conn.WriteMessage(&out)
+}
+
+// WriteMetadata 发送 @setDataFrame onMetaData
+func (p *Publisher) WriteMetadata(props amf.Object) error {
+	var b bytes.Buffer
+	_, _ = amf.WriteString(&b, "@setDataFrame")
+	_, _ = amf.WriteString(&b, "onMetaData")
+	if _, err := amf.WriteObject(&b, props); err != nil {
+		return err
+	}
+	return p.Write(&rtmp.Message{TypeID: rtmp.TypeDataAMF0, Payload: b.Bytes()})
+}
+
+// WriteSequenceHeaders 发送H.264和AAC的sequence header
+func (p *Publisher) WriteSequenceHeaders(timestamp uint32) error {
+	if err := p.Write(&rtmp.Message{TypeID: rtmp.TypeVideo, Timestamp: timestamp, Payload: avcSequenceHeader}); err != nil {
+		return err
+	}
+	return p.Write(&rtmp.Message{TypeID: rtmp.TypeAudio, Timestamp: timestamp, Payload: aacSequenceHeader})
+}
+
+// WriteVideo 发送一帧H.264视频，payload 为帧的数据部分
+func (p *Publisher) WriteVideo(timestamp uint32, keyframe bool, payload []byte) error {
+	frameType := byte(0x27)
+	if keyframe {
+		frameType = 0x17
+	}
+	data := append([]byte{frameType, 0x01, 0x00, 0x00, 0x00}, payload...)
+	return p.Write(&rtmp.Message{TypeID: rtmp.TypeVideo, Timestamp: timestamp, Payload: data})
+}
+
+// WriteAudio 发送一帧AAC音频
+func (p *Publisher) WriteAudio(timestamp uint32, payload []byte) error {
+	data := append([]byte{0xaf, 0x01}, payload...)
+	return p.Write(&rtmp.Message{TypeID: rtmp.TypeAudio, Timestamp: timestamp, Payload: data})
+}
+
+// WriteFrames 按30fps的时间戳发送n帧视频和音频，每30帧一个关键帧，第一次调用前先发送sequence header，
+// 不按时间戳等待
+func (p *Publisher) WriteFrames(n int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sent == 0 && n > 0 {
+		if err := p.WriteSequenceHeaders(p.next); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < n; i++ {
+		if err := p.WriteVideo(p.next, p.sent%gopFrames == 0, make([]byte, 64)); err != nil {
+			return err
+		}
+		if err := p.WriteAudio(p.next, make([]byte, 8)); err != nil {
+			return err
+		}
+		p.sent++
+		p.next += frameInterval
+	}
+	return nil
+}
+
+// Unpublish 发送FCUnpublish和deleteStream结束推流，连接保持打开
+func (p *Publisher) Unpublish() error {
+	return p.conn.Unpublish(p.streamName, p.StreamID)
+}
+
+// Close 直接关闭连接，不结束推流，用于模拟客户端断线
+func (p *Publisher) Close() error {
+	return p.conn.Close()
+}
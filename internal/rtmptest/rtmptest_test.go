@@ -0,0 +1,283 @@
+package rtmptest_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"rtmpproxy/internal"
+	"rtmpproxy/internal/plugins"
+	"rtmpproxy/internal/rtmp"
+	"rtmpproxy/internal/rtmptest"
+	"rtmpproxy/internal/server"
+	"testing"
+	"time"
+)
+
+const timeout = 5 * time.Second
+
+// 推流客户端和代理发送的命令
+var publishCommands = []string{"connect", "releaseStream", "FCPublish", "createStream", "publish"}
+
+func TestPublisherToIngest(t *testing.T) {
+	ingest := rtmptest.NewIngest()
+	defer ingest.Close()
+	client, server := rtmptest.Pipe(t)
+	go func() {
+		_ = ingest.ServeConn(server)
+	}()
+
+	p, err := rtmptest.Publish(client, "live", "key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := ingest.WaitPublish(t, 1, timeout)
+	if req.TcUrl != "rtmp://127.0.0.1/live" {
+		t.Errorf("tcUrl = %s", req.TcUrl)
+	}
+	ingest.AssertCommands(t, publishCommands...)
+	ingest.AssertPublish(t, "live", "key")
+	ingest.AssertConnect(t, "flashVer", "FMLE/3.0 (compatible; FMSc/1.0)")
+
+	if err = p.WriteMetadata(map[string]interface{}{"width": 1280.0}); err != nil {
+		t.Fatal(err)
+	}
+	// metadata、两个sequence header和3帧音视频
+	if err = p.WriteFrames(3); err != nil {
+		t.Fatal(err)
+	}
+	messages := ingest.WaitMessages(t, 9, timeout)
+	if messages[1].TypeID != rtmp.TypeVideo || messages[2].TypeID != rtmp.TypeAudio {
+		t.Errorf("sequence headers not sent first")
+	}
+	if last := messages[len(messages)-1]; last.Timestamp != 66 {
+		t.Errorf("last timestamp = %d, want 66", last.Timestamp)
+	}
+
+	if err = p.Unpublish(); err != nil {
+		t.Fatal(err)
+	}
+	ingest.WaitCommand(t, "deleteStream", timeout)
+	ingest.AssertCommands(t, "publish", "FCUnpublish", "deleteStream")
+}
+
+func TestIngestCheckConnect(t *testing.T) {
+	ingest, addr := rtmptest.StartIngest(t)
+	ingest.CheckConnect = func(req *rtmp.PublishRequest) error {
+		if req.App != "live" {
+			return errors.New("unknown app")
+		}
+		return nil
+	}
+	if _, err := rtmptest.Dial(addr, "other", "key", nil); err == nil {
+		t.Fatal("publish to rejected app succeeded")
+	}
+	if len(ingest.Publishes()) != 0 {
+		t.Errorf("rejected publish recorded")
+	}
+	p, err := rtmptest.Dial(addr, "live", "key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = p.Close()
+	}()
+	ingest.WaitPublish(t, 1, timeout)
+}
+
+// TestRTMPConnection 推流客户端通过 Pipe 连接 RTMPConnection，转发到推流服务器
+func TestRTMPConnection(t *testing.T) {
+	ingest := rtmptest.NewIngest()
+	defer ingest.Close()
+	remoteClient, remoteServer := rtmptest.Pipe(t)
+	go func() {
+		_ = ingest.ServeConn(remoteServer)
+	}()
+
+	client, proxySide := rtmptest.Pipe(t)
+	conn := rtmp.CreateRTMPInstance(proxySide, "X/1", "", nil)
+	served := make(chan error, 1)
+	go func() {
+		if err := conn.RTMPHandshake(); err != nil {
+			served <- err
+			return
+		}
+		if _, err := conn.ReadPublish(nil); err != nil {
+			served <- err
+			return
+		}
+		if err := conn.ConnectServer(remoteClient, "live", "127.0.0.1", "remotekey"); err != nil {
+			served <- err
+			return
+		}
+		served <- conn.Serve()
+	}()
+
+	p, err := rtmptest.Publish(client, "live", "key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingest.WaitPublish(t, 1, timeout)
+	ingest.AssertCommands(t, publishCommands...)
+	ingest.AssertPublish(t, "live", "remotekey")
+	ingest.AssertConnect(t, "flashVer", "X/1")
+
+	if err = p.WriteFrames(5); err != nil {
+		t.Fatal(err)
+	}
+	messages := ingest.WaitMessages(t, 12, timeout)
+	checkMedia(t, messages)
+
+	if err = p.Unpublish(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-served; err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	ingest.WaitCommand(t, "deleteStream", timeout)
+	ingest.AssertCommands(t, "publish", "FCUnpublish", "deleteStream")
+}
+
+// checkMedia 检查 WriteFrames 发送的sequence header和音视频帧原样转发
+func checkMedia(t *testing.T, messages []*rtmp.Message) {
+	t.Helper()
+	var video, audio int
+	for _, msg := range messages {
+		switch msg.TypeID {
+		case rtmp.TypeVideo:
+			video++
+			if video == 1 && !bytes.HasPrefix(msg.Payload, []byte{0x17, 0x00}) {
+				t.Errorf("first video message is not a sequence header: % x", msg.Payload[:2])
+			}
+			if video == 2 && !bytes.HasPrefix(msg.Payload, []byte{0x17, 0x01}) {
+				t.Errorf("first video frame is not a keyframe: % x", msg.Payload[:2])
+			}
+		case rtmp.TypeAudio:
+			audio++
+		}
+	}
+	if video < 6 || audio < 6 {
+		t.Errorf("forwarded %d video and %d audio messages, want 6 each", video, audio)
+	}
+}
+
+// startServer 启动代理，推流到ingestAddr上的remote
+func startServer(t *testing.T, remote string, interceptor plugins.Interceptor) string {
+	t.Helper()
+	listen, proxyAddr := "127.0.0.1:0", ""
+	cfg := &internal.Config{ListenAddr: &listen, RemoteAddr: &remote, ProxyAddr: &proxyAddr, FlashVer: "X/1"}
+	s, err := server.New(cfg, interceptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = s.Serve(listener)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		_ = s.Shutdown(ctx, true)
+	})
+	return listener.Addr().String()
+}
+
+// TestServer 推流客户端通过回环地址连接代理
+func TestServer(t *testing.T) {
+	ingest, ingestAddr := rtmptest.StartIngest(t)
+	addr := startServer(t, "rtmp://"+ingestAddr+"/app/remotekey", &plugins.DefaultInterceptor{})
+
+	p, err := rtmptest.Dial(addr, "live", "localkey", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = p.Close()
+	}()
+	ingest.WaitPublish(t, 1, timeout)
+	ingest.AssertCommands(t, publishCommands...)
+	ingest.AssertPublish(t, "app", "remotekey")
+	ingest.AssertConnect(t, "flashVer", "X/1")
+
+	if err = p.WriteFrames(5); err != nil {
+		t.Fatal(err)
+	}
+	checkMedia(t, ingest.WaitMessages(t, 12, timeout))
+	if err = p.Unpublish(); err != nil {
+		t.Fatal(err)
+	}
+	ingest.WaitCommand(t, "deleteStream", timeout)
+}
+
+// routeInterceptor 在 BeforeEstablishTCPConnection 中按客户端的streamName选择远程服务器，拒绝未知的streamName
+type routeInterceptor struct {
+	plugins.DefaultInterceptor
+	routes     map[string]string
+	handshakes chan internal.Session
+}
+
+func (i *routeInterceptor) BeforeEstablishTCPConnection(s *internal.Session) error {
+	remote, ok := i.routes[s.ClientName]
+	if !ok {
+		return errors.New("unknown stream")
+	}
+	s.RemoteAddr = remote
+	return nil
+}
+
+func (i *routeInterceptor) AfterRTMPHandshake(s *internal.Session) error {
+	i.handshakes <- *s
+	return nil
+}
+
+func TestInterceptor(t *testing.T) {
+	first, firstAddr := rtmptest.StartIngest(t)
+	second, secondAddr := rtmptest.StartIngest(t)
+	interceptor := &routeInterceptor{
+		routes: map[string]string{
+			"a": "rtmp://" + firstAddr + "/live/first",
+			"b": "rtmp://" + secondAddr + "/live/second?token=1",
+		},
+		handshakes: make(chan internal.Session, 4),
+	}
+	addr := startServer(t, "rtmp://"+firstAddr+"/live/default", interceptor)
+
+	p, err := rtmptest.Dial(addr, "live", "b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = p.Close()
+	}()
+	req := second.WaitPublish(t, 1, timeout)
+	second.AssertPublish(t, "live", "second")
+	if req.Args.Get("token") != "1" {
+		t.Errorf("publish args = %v, want token=1", req.Args)
+	}
+	select {
+	case s := <-interceptor.handshakes:
+		if s.AppName != "live" || s.StreamName != "second?token=1" || s.ClientName != "b" {
+			t.Errorf("AfterRTMPHandshake session = %s/%s from %s", s.AppName, s.StreamName, s.ClientName)
+		}
+	case <-time.After(timeout):
+		t.Fatal("AfterRTMPHandshake not called")
+	}
+	if err = p.WriteFrames(1); err != nil {
+		t.Fatal(err)
+	}
+	second.WaitMessages(t, 4, timeout)
+	if len(first.Publishes()) != 0 {
+		t.Errorf("default remote received a publish")
+	}
+
+	// 插件返回错误时拒绝推流，不连接远程服务器
+	if _, err = rtmptest.Dial(addr, "live", "unknown", nil); err == nil {
+		t.Errorf("publish rejected by interceptor succeeded")
+	}
+	if len(first.Publishes()) != 0 || len(second.Publishes()) != 1 {
+		t.Errorf("rejected publish reached a remote server")
+	}
+}
@@ -0,0 +1,27 @@
+package server
+
+import "fmt"
+
+// 会话的处理阶段
+const (
+	StageHandshake     = "Handshake"
+	StagePublish       = "Publish"
+	StageAuthorize     = "Authorize"
+	StageConnectRemote = "ConnectRemote"
+	StageServe         = "Serve"
+)
+
+// SessionError 会话在某个阶段失败，Stage 为处理阶段或插件事件名称
+type SessionError struct {
+	SessionID string
+	Stage     string
+	Err       error
+}
+
+func (e *SessionError) Error() string {
+	return fmt.Sprintf("session %s: %s: %v", e.SessionID, e.Stage, e.Err)
+}
+
+func (e *SessionError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"rtmpproxy/internal"
+	"rtmpproxy/internal/plugins"
+	"rtmpproxy/utils"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errHook = errors.New("platform API error")
+
+// failing 在指定事件返回errHook
+type failing struct {
+	plugins.DefaultInterceptor
+	hook   string
+	closed atomic.Int32
+}
+
+func (f *failing) BeforeEstablishTCPConnection(s *internal.Session) error {
+	if f.hook == plugins.StageBeforeEstablishTCPConnection {
+		return errHook
+	}
+	return nil
+}
+
+func (f *failing) AfterRTMPHandshake(s *internal.Session) error {
+	if f.hook == plugins.StageAfterRTMPHandshake {
+		return errHook
+	}
+	return nil
+}
+
+func (f *failing) AfterCloseTCPConnection(s *internal.Session) error {
+	f.closed.Add(1)
+	if f.hook == plugins.StageAfterCloseTCPConnection {
+		return errHook
+	}
+	return nil
+}
+
+// handleConn 用s处理一个回环连接，返回客户端的连接和handle的结果
+func handleConn(t *testing.T, s *Server) (net.Conn, <-chan error) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- s.handle(conn)
+	}()
+	return client, result
+}
+
+func newServer(t *testing.T, remote string, interceptor plugins.Interceptor, hookPolicy string) *Server {
+	t.Helper()
+	listen, proxyAddr := "127.0.0.1:0", ""
+	s, err := New(&internal.Config{ListenAddr: &listen, RemoteAddr: &remote, ProxyAddr: &proxyAddr, HookPolicy: hookPolicy}, interceptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// sessionError 检查handle返回的会话错误
+func sessionError(t *testing.T, result <-chan error, stage string, target error) {
+	t.Helper()
+	err := receive(t, result, "session end")
+	var sessionErr *SessionError
+	if !errors.As(err, &sessionErr) || sessionErr.Stage != stage || sessionErr.SessionID == "" {
+		t.Fatalf("err = %v, want session error at %s", err, stage)
+	}
+	if target != nil && !errors.Is(err, target) {
+		t.Errorf("err = %v, want %v", err, target)
+	}
+}
+
+func TestSessionErrorHandshake(t *testing.T) {
+	s := newServer(t, "rtmp://127.0.0.1:1/live/key", &plugins.DefaultInterceptor{}, "")
+	client, result := handleConn(t, s)
+	_, _ = client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	_ = client.Close()
+	sessionError(t, result, StageHandshake, nil)
+}
+
+func TestSessionErrorHookAbort(t *testing.T) {
+	ingest, ingestAddr := startIngest(t)
+	interceptor := &failing{hook: plugins.StageBeforeEstablishTCPConnection}
+	s := newServer(t, "rtmp://"+ingestAddr+"/live/remotekey", interceptor, "")
+
+	// 插件失败只结束当前会话，客户端收到推流失败
+	client, result := handleConn(t, s)
+	if _, _, err := publish(client, "live", "key"); err == nil {
+		t.Error("publish succeeded after hook failure")
+	}
+	sessionError(t, result, plugins.StageBeforeEstablishTCPConnection, errHook)
+	if n := interceptor.closed.Load(); n != 0 {
+		t.Errorf("AfterCloseTCPConnection called %d times after BeforeEstablishTCPConnection failed", n)
+	}
+	select {
+	case <-ingest.published:
+		t.Error("remote received publish")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSessionErrorHookContinue(t *testing.T) {
+	ingest, ingestAddr := startIngest(t)
+	interceptor := &failing{hook: plugins.StageBeforeEstablishTCPConnection}
+	s := newServer(t, "rtmp://"+ingestAddr+"/live/remotekey", interceptor, "BeforeEstablishTCPConnection=continue")
+
+	client, result := handleConn(t, s)
+	if _, _, err := publish(client, "live", "key"); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if req := receive(t, ingest.published, "publish"); req.StreamName != "remotekey" {
+		t.Errorf("remote stream = %s", req.StreamName)
+	}
+	_ = client.Close()
+	if err := receive(t, result, "session end"); err != nil {
+		var sessionErr *SessionError
+		if !errors.As(err, &sessionErr) || sessionErr.Stage != StageServe {
+			t.Errorf("err = %v, want nil or serve error", err)
+		}
+	}
+	if n := interceptor.closed.Load(); n != 1 {
+		t.Errorf("AfterCloseTCPConnection called %d times, want 1", n)
+	}
+}
+
+func TestSessionErrorAfterClose(t *testing.T) {
+	_, ingestAddr := startIngest(t)
+	s := newServer(t, "rtmp://"+ingestAddr+"/live/remotekey", &failing{hook: plugins.StageAfterCloseTCPConnection}, "")
+
+	client, result := handleConn(t, s)
+	c, streamID, err := publish(client, "live", "key")
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if err = writeFrames(c, streamID, 1); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.Close()
+	sessionError(t, result, plugins.StageAfterCloseTCPConnection, errHook)
+}
+
+func TestSessionErrorRemoteRequired(t *testing.T) {
+	interceptor := &failing{}
+	s := newServer(t, "", interceptor, "")
+	client, result := handleConn(t, s)
+	if _, _, err := publish(client, "live", "key"); err == nil {
+		t.Error("publish succeeded without remote address")
+	}
+	sessionError(t, result, StageConnectRemote, utils.RemoteAddrRequired)
+	// BeforeEstablishTCPConnection 成功后总是调用 AfterCloseTCPConnection
+	if n := interceptor.closed.Load(); n != 1 {
+		t.Errorf("AfterCloseTCPConnection called %d times, want 1", n)
+	}
+}
+
+func TestNewInvalidHookPolicy(t *testing.T) {
+	listen, remote, proxyAddr := "127.0.0.1:0", "rtmp://127.0.0.1/live/key", ""
+	cfg := &internal.Config{ListenAddr: &listen, RemoteAddr: &remote, ProxyAddr: &proxyAddr, HookPolicy: "AfterRtmpHandshake=continue"}
+	if _, err := New(cfg, nil); err == nil {
+		t.Error("New with misspelled hook policy succeeded")
+	}
+}
@@ -0,0 +1,430 @@
+package server
+
+// 同一路推流的多个客户端连接：断线后发送垫片等待重新连接，以及主备编码器之间的切换
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"rtmpproxy/internal/rtmp"
+	"rtmpproxy/utils"
+	"strings"
+	"time"
+)
+
+// 客户端来源的名称
+const (
+	roleClient  = "client"
+	rolePrimary = "primary"
+	roleBackup  = "backup"
+)
+
+// 未配置 -stallTimeout 时判断主备编码器卡顿的时长
+const defaultFailoverTimeout = 5 * time.Second
+
+// feed 向会话推流的一个客户端连接
+type feed struct {
+	conn         *rtmp.RTMPConnection
+	role         string
+	clientAddr   string
+	done         chan struct{} // 会话不再使用该连接后关闭，加入会话的连接的goroutine等待该channel
+	healthySince time.Time     // 最近一次从卡顿中恢复的时间
+}
+
+// 管理接口对会话输出的操作
+const (
+	outputDump  = "dump"  // 丢弃延迟缓冲区中的内容
+	outputSlate = "slate" // 切换到垫片
+	outputLive  = "live"  // 从垫片切换回客户端
+)
+
+// control 管理接口的操作，结果写入reply
+type control struct {
+	action string
+	reply  chan error
+}
+
+type feedResult struct {
+	feed *feed
+	err  error
+}
+
+// group 正在推流的会话，接受同一路推流的其它客户端连接
+type group struct {
+	attach  chan *feed
+	control chan control
+	closed  chan struct{}
+}
+
+// parseFailover 解析主备推流密钥 "primary=backup,primary2=backup2"，返回主密钥到备用密钥的映射
+func parseFailover(s string) (map[string]string, error) {
+	backups := make(map[string]string)
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		primary, backup, ok := strings.Cut(item, "=")
+		primary, backup = strings.TrimSpace(primary), strings.TrimSpace(backup)
+		if !ok || primary == "" || backup == "" || primary == backup {
+			return nil, fmt.Errorf("invalid failover pair %q", item)
+		}
+		if seen[primary] || seen[backup] {
+			return nil, fmt.Errorf("stream key in more than one failover pair: %q", item)
+		}
+		seen[primary], seen[backup] = true, true
+		backups[primary] = backup
+	}
+	return backups, nil
+}
+
+// feedRole 返回客户端streamName的角色和所属的推流
+func (s *Server) feedRole(app string, streamName string) (role string, key string) {
+	if _, ok := s.backups[streamName]; ok {
+		return rolePrimary, app + "/" + streamName
+	}
+	for primary, backup := range s.backups {
+		if backup == streamName {
+			return roleBackup, app + "/" + primary
+		}
+	}
+	return roleClient, app + "/" + streamName
+}
+
+// managedFeeds 该角色的客户端是否由会话管理，而不是直接结束推流
+func (s *Server) managedFeeds(role string) bool {
+	return s.cfg.Standby > 0 || s.cfg.Delay > 0 || s.slate != nil || role != roleClient
+}
+
+// attach 将客户端连接交给同一路推流正在进行的会话，返回的channel在会话不再使用该连接后关闭
+func (s *Server) attach(key string, role string, conn *rtmp.RTMPConnection, clientAddr string) (<-chan struct{}, bool) {
+	s.mu.Lock()
+	g := s.groups[key]
+	s.mu.Unlock()
+	if g == nil {
+		return nil, false
+	}
+	f := &feed{conn: conn, role: role, clientAddr: clientAddr, done: make(chan struct{})}
+	select {
+	case g.attach <- f:
+		return f.done, true
+	case <-g.closed:
+		return nil, false
+	}
+}
+
+// serveFeeds 转发会话的客户端和之后加入的同一路推流的客户端，直到没有客户端推流且等待重新连接超时，
+// 或者远程服务器断开，返回最后一个客户端连接的错误
+func (s *Server) serveFeeds(sess *session, role string, key string) error {
+	upstream, delay := sess.conn.Upstream(), sess.conn.Delay()
+	g := &group{attach: make(chan *feed), control: make(chan control), closed: make(chan struct{})}
+	s.mu.Lock()
+	registered := s.groups[key] == nil
+	if registered {
+		s.groups[key] = g
+	}
+	s.mu.Unlock()
+	sess.mu.Lock()
+	sess.feeds = g
+	sess.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		if s.groups[key] == g {
+			delete(s.groups, key)
+		}
+		s.mu.Unlock()
+		close(g.closed)
+	}()
+
+	timeout := s.cfg.StallTimeout
+	if timeout <= 0 {
+		timeout = defaultFailoverTimeout
+	}
+	var (
+		feeds        []*feed
+		active       *feed // 正在转发或即将切换到的客户端，nil 为垫片
+		cut          bool  // 管理接口切换到了垫片，切回之前不转发客户端
+		lost         *feed // 断开后等待延迟缓冲区发送完的客户端
+		results      = make(chan feedResult)
+		lastErr      error
+		standby      <-chan time.Time
+		drained      <-chan struct{}
+		slateSrc     = rtmp.NewSource("slate")
+		stopSlate    func()
+		upstreamDone = upstream.Done()
+	)
+	start := func(f *feed) {
+		feeds = append(feeds, f)
+		go func() {
+			err := f.conn.Serve()
+			results <- feedResult{feed: f, err: err}
+		}()
+	}
+	switchTo := func(f *feed, reason string) {
+		if active != nil && active != f {
+			sess.Logger().Warn("Switching encoder", "from", active.role, "to", f.role, "reason", reason)
+		}
+		active = f
+		standby, drained, lost = nil, nil, nil
+		if !cut {
+			upstream.Switch(f.conn.Source())
+		}
+	}
+	// pick 选择可以转发的客户端，优先使用没有卡顿的主编码器
+	pick := func(now time.Time) *feed {
+		var best *feed
+		for _, f := range feeds {
+			if now.Sub(f.conn.LastMedia()) > timeout {
+				continue
+			}
+			if best == nil || (f.role == rolePrimary && best.role != rolePrimary) {
+				best = f
+			}
+		}
+		if best == nil && len(feeds) > 0 {
+			best = feeds[len(feeds)-1]
+		}
+		return best
+	}
+	showSlate := func() {
+		if stopSlate != nil && upstream.Active() == slateSrc {
+			upstream.Activate(slateSrc)
+			return
+		}
+		if stopSlate != nil {
+			stopSlate()
+		}
+		upstream.Activate(slateSrc)
+		stopSlate = s.playSlate(sess, upstream, slateSrc)
+	}
+	// lose 没有客户端可以转发时，结束推流或发送垫片等待重新连接，返回是否已经结束推流
+	lose := func(f *feed) bool {
+		if f.conn.Ended() || s.cfg.Standby <= 0 {
+			upstream.Unpublish()
+			return true
+		}
+		sess.Logger().Warn("Client disconnected, sending slate to remote server", "grace", s.cfg.Standby.String())
+		showSlate()
+		standby = time.After(s.cfg.Standby)
+		return false
+	}
+	// output 处理管理接口对输出的操作
+	output := func(action string) error {
+		switch action {
+		case outputDump:
+			if delay == nil {
+				return utils.DelayNotEnabled
+			}
+			// 缓冲区重新积累期间远程服务器收不到内容，大多数平台会断开推流，需要垫片填补
+			if s.slate == nil {
+				return utils.SlateNotConfigured
+			}
+			dropped := delay.Dump()
+			sess.Logger().Warn("Dumped delay buffer", "dropped", dropped.Round(time.Millisecond).String())
+			// 缓冲区重新积累期间发送垫片，之后从客户端的下一个关键帧继续
+			if !cut && active != nil {
+				showSlate()
+				upstream.Switch(active.conn.Source())
+			}
+		case outputSlate:
+			if s.slate == nil {
+				return utils.SlateNotConfigured
+			}
+			cut = true
+			sess.Logger().Warn("Switching output to slate")
+			showSlate()
+		case outputLive:
+			if active == nil {
+				return utils.NoActiveClient
+			}
+			if cut {
+				cut = false
+				sess.Logger().Info("Switching output back to client")
+			}
+			upstream.Switch(active.conn.Source())
+		default:
+			return utils.InvalidOutputAction
+		}
+		return nil
+	}
+
+	first := &feed{conn: sess.conn, role: role, clientAddr: sess.ClientAddr, healthySince: time.Now()}
+	first.conn.Manage(role)
+	upstream.Activate(first.conn.Source())
+	active = first
+	start(first)
+	if !registered {
+		sess.Logger().Warn("Another session is already publishing the same stream")
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	defer func() {
+		if stopSlate != nil {
+			stopSlate()
+		}
+	}()
+	for {
+		select {
+		case f := <-g.attach:
+			f.conn.Join(sess.conn)
+			f.conn.Manage(f.role)
+			f.healthySince = time.Now()
+			sess.stats.addConn(f.conn)
+			sess.Logger().Info("Client joined stream", "role", f.role, "addr", f.clientAddr)
+			start(f)
+			if active == nil || (f.role == rolePrimary && active.role != rolePrimary) || active.role == f.role {
+				switchTo(f, "connected")
+			}
+
+		case c := <-g.control:
+			c.reply <- output(c.action)
+
+		case r := <-results:
+			for i, f := range feeds {
+				if f == r.feed {
+					feeds = append(feeds[:i], feeds[i+1:]...)
+					break
+				}
+			}
+			if r.feed.done != nil {
+				close(r.feed.done)
+			}
+			lastErr = r.err
+			if upstream.Closed() {
+				if len(feeds) == 0 {
+					return lastErr
+				}
+				continue
+			}
+			if r.err != nil {
+				sess.Logger().Warn("Client connection lost", "role", r.feed.role, "err", r.err)
+			} else {
+				sess.Logger().Info("Client disconnected", "role", r.feed.role, "ended", r.feed.conn.Ended())
+			}
+			if r.feed != active {
+				continue
+			}
+			if next := pick(time.Now()); next != nil {
+				switchTo(next, "disconnected")
+				continue
+			}
+			active = nil
+			if delay != nil && !cut {
+				// 先发送完延迟缓冲区中客户端断开前的内容
+				lost, drained = r.feed, delay.Idle()
+				continue
+			}
+			if lose(r.feed) {
+				return lastErr
+			}
+
+		case <-drained:
+			drained = nil
+			if lose(lost) {
+				return lastErr
+			}
+			lost = nil
+
+		case now := <-ticker.C:
+			if stopSlate != nil && upstream.Active() != slateSrc {
+				stopSlate()
+				stopSlate = nil
+			}
+			for _, f := range feeds {
+				if now.Sub(f.conn.LastMedia()) > timeout {
+					f.healthySince = time.Time{}
+				} else if f.healthySince.IsZero() {
+					f.healthySince = now
+				}
+			}
+			if active == nil || len(feeds) < 2 {
+				continue
+			}
+			if active.healthySince.IsZero() {
+				// 当前编码器卡顿，切换到其它正常推流的编码器
+				if next := pick(now); next != active && !next.healthySince.IsZero() {
+					switchTo(next, "stalled")
+				}
+				continue
+			}
+			if active.role == roleBackup {
+				// 主编码器恢复正常推流一段时间后切换回来
+				for _, f := range feeds {
+					if f.role == rolePrimary && !f.healthySince.IsZero() && now.Sub(f.healthySince) >= timeout {
+						switchTo(f, "primary recovered")
+						break
+					}
+				}
+			}
+
+		case <-standby:
+			sess.Logger().Info("Client did not reconnect in time, ending stream")
+			lastErr = nil // 已经记录过
+			upstream.Unpublish()
+
+		case <-upstreamDone:
+			// 远程服务器断开或会话被结束，等待所有客户端连接退出
+			upstreamDone = nil
+			for _, f := range feeds {
+				f.conn.Close()
+			}
+			if len(feeds) == 0 {
+				return lastErr
+			}
+		}
+	}
+}
+
+// ControlOutput 管理接口操作会话发往远程服务器的内容，action 为 dump/slate/live
+func (s *Server) ControlOutput(id string, action string) error {
+	switch action {
+	case outputDump, outputSlate, outputLive:
+	default:
+		return utils.InvalidOutputAction
+	}
+	sess := s.lookupSession(id)
+	if sess == nil {
+		return utils.SessionNotFound
+	}
+	sess.mu.Lock()
+	serving, g := sess.serving, sess.feeds
+	sess.mu.Unlock()
+	if !serving {
+		return utils.SessionNotPublishing
+	}
+	if g == nil {
+		// 未启用延迟和垫片的会话始终转发客户端
+		switch action {
+		case outputDump:
+			return utils.DelayNotEnabled
+		case outputSlate:
+			return utils.SlateNotConfigured
+		}
+		return nil
+	}
+	c := control{action: action, reply: make(chan error, 1)}
+	select {
+	case g.control <- c:
+		return <-c.reply
+	case <-g.closed:
+		return utils.SessionNotPublishing
+	}
+}
+
+// playSlate 开始循环发送垫片，返回停止的函数
+func (s *Server) playSlate(sess *session, upstream *rtmp.Upstream, src *rtmp.Source) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if err := s.slate.Play(upstream, src, done); err != nil && !errors.Is(err, net.ErrClosed) {
+			sess.Logger().Warn("Failed to send slate", "err", err)
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"rtmpproxy/internal"
+	"rtmpproxy/internal/rtmp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeSlate 生成只有一个关键帧的垫片文件
+func writeSlate(t *testing.T) string {
+	t.Helper()
+	file := []byte{'F', 'L', 'V', 1, 0x01, 0, 0, 0, 9, 0, 0, 0, 0}
+	file = append(file, 9, 0, 0, 5, 0, 0, 0, 0, 0, 0, 0, 0x17, 1, 0, 0, 0, 0, 0, 0, 16)
+	path := filepath.Join(t.TempDir(), "slate.flv")
+	if err := os.WriteFile(path, file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func withStandby(t *testing.T, grace time.Duration) func(cfg *internal.Config) {
+	slate := writeSlate(t)
+	return func(cfg *internal.Config) {
+		cfg.Standby, cfg.Slate = grace, slate
+	}
+}
+
+// waitSource 等到唯一的会话正在转发source
+func waitSource(t *testing.T, s *Server, source string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if sessions := s.Sessions(); len(sessions) == 1 && sessions[0].Source == source {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for source %s, sessions %+v", source, s.Sessions())
+}
+
+// dropPublish 推流后不结束推流直接断开
+func dropPublish(t *testing.T, addr string) {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, streamID, err := publish(conn, "live", "key")
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if err := writeFrames(c, streamID, 3); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+}
+
+func TestStandbyResume(t *testing.T) {
+	ingest, ingestAddr := startIngest(t)
+	s, addr := startServer(t, "rtmp://"+ingestAddr+"/live/remotekey", nil, withStandby(t, 5*time.Second))
+
+	dropPublish(t, addr)
+	receive(t, ingest.published, "publish")
+	waitSource(t, s, "slate")
+
+	// 同一推流密钥的客户端重新连接后继续使用原来的远程推流
+	c, streamID := dialPublish(t, addr, "live", "key")
+	if err := writeFrames(c, streamID, 3); err != nil {
+		t.Fatal(err)
+	}
+	waitSource(t, s, "client")
+	select {
+	case <-ingest.published:
+		t.Error("remote server received a second publish")
+	case err := <-ingest.ended:
+		t.Errorf("remote stream ended with %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestStandbyTimeout(t *testing.T) {
+	ingest, ingestAddr := startIngest(t)
+	s, addr := startServer(t, "rtmp://"+ingestAddr+"/live/remotekey", nil, withStandby(t, 200*time.Millisecond))
+
+	dropPublish(t, addr)
+	receive(t, ingest.published, "publish")
+	waitSource(t, s, "slate")
+
+	// 超过等待时长后结束远程推流
+	if err := receive(t, ingest.ended, "unpublish"); err != io.EOF {
+		t.Errorf("remote ended with %v, want unpublish", err)
+	}
+}
+
+func TestStandbyRequiresSlate(t *testing.T) {
+	remote, listen, proxyAddr := "rtmp://127.0.0.1:1935/live/key", "127.0.0.1:0", ""
+	cfg := &internal.Config{ListenAddr: &listen, RemoteAddr: &remote, ProxyAddr: &proxyAddr, Standby: time.Second}
+	if _, err := New(cfg, nil); err == nil || !strings.Contains(err.Error(), "slate is required") {
+		t.Errorf("New = %v, want slate required", err)
+	}
+	cfg.Slate = filepath.Join(t.TempDir(), "missing.flv")
+	if _, err := New(cfg, nil); err == nil {
+		t.Error("New with missing slate succeeded")
+	}
+}
+
+func TestParseFailover(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"", map[string]string{}, false},
+		{"main=main_backup", map[string]string{"main": "main_backup"}, false},
+		{" a = b , c=d,", map[string]string{"a": "b", "c": "d"}, false},
+		{"main", nil, true},
+		{"main=", nil, true},
+		{"main=main", nil, true},
+		{"a=b,b=c", nil, true},
+		{"a=b,c=a", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseFailover(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFailover(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseFailover(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+// encoder 持续推流的客户端，可以暂停发送模拟编码器卡顿
+type encoder struct {
+	c         *rtmp.Conn
+	streamID  uint32
+	timestamp uint32
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func startEncoder(t *testing.T, addr string, streamName string) *encoder {
+	t.Helper()
+	c, streamID := dialPublish(t, addr, "live", streamName)
+	e := &encoder{c: c, streamID: streamID}
+	e.resume()
+	t.Cleanup(e.pause)
+	return e
+}
+
+// resume 每40ms发送一个关键帧
+func (e *encoder) resume() {
+	e.stop = make(chan struct{})
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		ticker := time.NewTicker(40 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			msg := &rtmp.Message{TypeID: rtmp.TypeVideo, StreamID: e.streamID, Timestamp: e.timestamp, Payload: []byte{0x17, 0x01, 0, 0, 0}}
+			if e.c.WriteMessage(msg) != nil {
+				return
+			}
+			e.timestamp += 40
+			select {
+			case <-ticker.C:
+			case <-e.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (e *encoder) pause() {
+	select {
+	case <-e.stop:
+	default:
+		close(e.stop)
+	}
+	e.wg.Wait()
+}
+
+func withFailover(cfg *internal.Config) {
+	cfg.Failover = "main=main_backup"
+	cfg.StallTimeout = 300 * time.Millisecond
+}
+
+func TestFailoverStall(t *testing.T) {
+	ingest, ingestAddr := startIngest(t)
+	s, addr := startServer(t, "rtmp://"+ingestAddr+"/live/remotekey", nil, withFailover)
+
+	primary := startEncoder(t, addr, "main")
+	receive(t, ingest.published, "publish")
+	startEncoder(t, addr, "main_backup")
+	waitSource(t, s, rolePrimary)
+
+	// 主编码器卡顿时切换到备用编码器，恢复一段时间后切换回来
+	primary.pause()
+	waitSource(t, s, roleBackup)
+	primary.resume()
+	waitSource(t, s, rolePrimary)
+
+	select {
+	case <-ingest.published:
+		t.Error("remote server received a second publish")
+	case err := <-ingest.ended:
+		t.Errorf("remote stream ended with %v", err)
+	default:
+	}
+}
+
+func TestFailoverDisconnect(t *testing.T) {
+	ingest, ingestAddr := startIngest(t)
+	s, addr := startServer(t, "rtmp://"+ingestAddr+"/live/remotekey", nil, withFailover)
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, streamID, err := publish(conn, "live", "main")
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if err := writeFrames(c, streamID, 3); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, ingest.published, "publish")
+	backup := startEncoder(t, addr, "main_backup")
+
+	// 主编码器断开后立即切换到备用编码器
+	_ = conn.Close()
+	waitSource(t, s, roleBackup)
+
+	// 两个编码器都断开后结束推流
+	backup.pause()
+	_ = backup.c.Close()
+	if err := receive(t, ingest.ended, "unpublish"); err != io.EOF {
+		t.Errorf("remote ended with %v, want unpublish", err)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+// 会话推流健康状态的检测和通知
+
+import (
+	"rtmpproxy/internal"
+	"rtmpproxy/internal/metrics"
+	"rtmpproxy/internal/plugins"
+	"rtmpproxy/internal/rtmp"
+	"rtmpproxy/internal/stats"
+	"time"
+)
+
+// 检查推流状态的间隔
+const healthCheckInterval = time.Second
+
+// watchHealth 检测会话的推流问题，记录日志和指标并通知实现了 HealthObserver 的插件，返回停止检测的函数
+func (s *Server) watchHealth(session *internal.Session, conn *rtmp.RTMPConnection) (stop func()) {
+	if s.cfg.StallTimeout <= 0 {
+		return func() {}
+	}
+	watchdog := stats.NewWatchdog(s.cfg.StallTimeout, s.cfg.BitrateCollapse, time.Now())
+	conn.Observe(watchdog.Observe)
+	conn.Upstream().ObserveBackwards(watchdog.Backwards)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(healthCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				for _, event := range watchdog.Check(now) {
+					s.reportHealth(session, event)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		for _, kind := range watchdog.Active() {
+			metrics.UnhealthySessions.WithLabelValues(session.ClientApp, kind).Dec()
+		}
+	}
+}
+
+func (s *Server) reportHealth(session *internal.Session, event internal.HealthEvent) {
+	route := session.ClientApp
+	switch {
+	case event.Recovered:
+		session.Logger().Info("Stream recovered", "kind", event.Kind)
+		metrics.UnhealthySessions.WithLabelValues(route, event.Kind).Dec()
+	case event.Kind == internal.HealthTimestampBackwards:
+		// 时间戳回退是一次性的问题，不计入未恢复的会话
+		session.Logger().Warn("Stream unhealthy", "kind", event.Kind, "detail", event.Detail)
+		metrics.HealthEvents.WithLabelValues(route, event.Kind).Inc()
+	default:
+		session.Logger().Warn("Stream unhealthy", "kind", event.Kind, "detail", event.Detail)
+		metrics.HealthEvents.WithLabelValues(route, event.Kind).Inc()
+		metrics.UnhealthySessions.WithLabelValues(route, event.Kind).Inc()
+	}
+	if observer, ok := s.interceptor.(plugins.HealthObserver); ok {
+		observer.OnHealth(session, event)
+	}
+}
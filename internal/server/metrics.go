@@ -0,0 +1,137 @@
+package server
+
+// 会话的 Prometheus 指标
+
+import (
+	"rtmpproxy/internal/metrics"
+	"rtmpproxy/internal/rtmp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 流量和码率的统计间隔
+const metricsInterval = 5 * time.Second
+
+// sessionMetrics 统计单个会话的消息数、流量和码率
+type sessionMetrics struct {
+	conn    *rtmp.RTMPConnection
+	route   string
+	bitrate atomic.Uint64 // 最近一个统计间隔发往远程服务器的码率
+	stop    chan struct{}
+	done    sync.WaitGroup
+
+	mu         sync.Mutex
+	remoteHost string
+	others     []*rtmp.RTMPConnection // 之后加入的客户端连接
+	last       rtmp.Traffic
+	lastDrops  rtmp.Drops
+	reported   float64 // 已计入 PublishBitrate 的码率
+}
+
+// trackSession 开始统计会话的指标，握手阶段的流量也会计入，需要在 Serve 之前调用，结束时调用 close
+func trackSession(conn *rtmp.RTMPConnection, route string, remoteHost string) *sessionMetrics {
+	m := &sessionMetrics{
+		conn:       conn,
+		route:      route,
+		remoteHost: remoteHost,
+		stop:       make(chan struct{}),
+	}
+	conn.ObserveReceived(func(msg *rtmp.Message) {
+		metrics.Messages.WithLabelValues(strconv.Itoa(int(msg.TypeID)), route, m.host()).Inc()
+	})
+	metrics.ActiveSessions.WithLabelValues(route, remoteHost).Inc()
+	m.done.Add(1)
+	go m.run()
+	return m
+}
+
+func (m *sessionMetrics) run() {
+	defer m.done.Done()
+	ticker := time.NewTicker(metricsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sent := m.flush()
+			m.bitrate.Store(sent * 8 / uint64(metricsInterval.Seconds()))
+			m.setBitrate(float64(m.bitrate.Load()))
+		case <-m.stop:
+			m.flush()
+			m.setBitrate(0)
+			return
+		}
+	}
+}
+
+func (m *sessionMetrics) host() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.remoteHost
+}
+
+// setBitrate 多个会话共用标签，按差值修改 PublishBitrate
+func (m *sessionMetrics) setBitrate(bitrate float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	metrics.PublishBitrate.WithLabelValues(m.route, m.remoteHost).Add(bitrate - m.reported)
+	m.reported = bitrate
+}
+
+// flush 将上次统计之后的流量计入指标，返回发往远程服务器的字节数
+func (m *sessionMetrics) flush() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.conn.Traffic()
+	for _, other := range m.others {
+		o := other.Traffic()
+		t.ClientIn += o.ClientIn
+		t.ClientOut += o.ClientOut
+	}
+	add := func(direction string, current uint64, last *uint64) uint64 {
+		// 切换远程服务器时统计值可能短暂回退
+		if current <= *last {
+			return 0
+		}
+		delta := current - *last
+		metrics.Bytes.WithLabelValues(direction, m.route, m.remoteHost).Add(float64(delta))
+		*last = current
+		return delta
+	}
+	add("client_in", t.ClientIn, &m.last.ClientIn)
+	add("client_out", t.ClientOut, &m.last.ClientOut)
+	add("remote_in", t.RemoteIn, &m.last.RemoteIn)
+	sent := add("remote_out", t.RemoteOut, &m.last.RemoteOut)
+	drops := m.conn.Upstream().Drops()
+	metrics.DroppedFrames.WithLabelValues(m.route, "disposable").Add(float64(drops.Disposable - m.lastDrops.Disposable))
+	metrics.DroppedFrames.WithLabelValues(m.route, "gop").Add(float64(drops.Inter - m.lastDrops.Inter))
+	m.lastDrops = drops
+	return sent
+}
+
+// addConn 同一路推流的其它客户端连接的流量也计入会话
+func (m *sessionMetrics) addConn(conn *rtmp.RTMPConnection) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.others = append(m.others, conn)
+}
+
+// switchRemote 切换远程服务器后，之后的指标使用新的 remote_host
+func (m *sessionMetrics) switchRemote(remoteHost string) {
+	m.flush()
+	m.setBitrate(0)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	metrics.ActiveSessions.WithLabelValues(m.route, m.remoteHost).Dec()
+	metrics.ActiveSessions.WithLabelValues(m.route, remoteHost).Inc()
+	metrics.UpstreamReconnects.WithLabelValues(m.route, remoteHost).Inc()
+	m.remoteHost = remoteHost
+}
+
+// close 停止统计并写入剩余的流量
+func (m *sessionMetrics) close() {
+	close(m.stop)
+	m.done.Wait()
+	metrics.ActiveSessions.WithLabelValues(m.route, m.host()).Dec()
+}
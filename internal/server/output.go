@@ -0,0 +1,50 @@
+package server
+
+// 将转发的推流写入标准输出或FLV文件，同一时间只有一个会话写入
+
+import (
+	"os"
+	"rtmpproxy/internal/flv"
+)
+
+// openOutput 打开 -output，"-" 为标准输出，空字符串返回nil
+func openOutput(path string) (*flv.Writer, error) {
+	if path == "" {
+		return nil, nil
+	}
+	out := os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+	return flv.NewWriter(out, true, true)
+}
+
+// claimOutput 会话开始转发时占用输出，已被其它会话占用时返回false，
+// 会话写入的时间戳接在之前的会话写入的之后，保持文件中的时间戳单调
+func (s *Server) claimOutput(sess *session) bool {
+	if s.output == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.outputOwner != nil {
+		sess.Logger().Warn("FLV output is used by another session", "owner", s.outputOwner.ID)
+		return false
+	}
+	s.outputOwner = sess
+	s.output.Continue()
+	return true
+}
+
+// releaseOutput 会话结束后释放输出
+func (s *Server) releaseOutput(sess *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.outputOwner == sess {
+		s.outputOwner = nil
+	}
+}
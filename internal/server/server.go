@@ -0,0 +1,354 @@
+package server
+
+// 接受客户端连接并管理所有活动会话
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"rtmpproxy/internal"
+	"rtmpproxy/internal/acl"
+	"rtmpproxy/internal/auth"
+	"rtmpproxy/internal/flv"
+	"rtmpproxy/internal/plugins"
+	"rtmpproxy/internal/rtmp"
+	"rtmpproxy/internal/shaper"
+	"rtmpproxy/internal/slate"
+	"rtmpproxy/internal/stats"
+	"rtmpproxy/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+// session 活动会话
+type session struct {
+	*internal.Session
+	conn *rtmp.RTMPConnection
+
+	// serving 之后 Session 的修改和读取需持有mu，之前只在处理会话的goroutine中修改
+	mu        sync.Mutex
+	serving   bool
+	switching bool           // SwitchUpstream 正在连接新的远程服务器
+	switches  sync.WaitGroup // 正在进行的 SwitchUpstream，会话结束时等待
+	stats     *sessionMetrics
+	feeds     *group          // 由 serveFeeds 管理的会话接受管理接口的输出操作
+	limiter   *shaper.Limiter // 发往远程服务器的限速，切换远程服务器后继续使用
+}
+
+type Server struct {
+	cfg         *internal.Config
+	interceptor plugins.Interceptor
+	guard       *acl.Guard
+	trusted     []*net.IPNet // 允许发送PROXY头的负载均衡
+	keyVerifier *auth.KeyVerifier
+	notifier    *auth.Notifier
+	policies    plugins.Policies
+	profile     *stats.Profile
+	shaper      *shaper.Shaper
+	slate       *slate.Slate
+	backups     map[string]string           // 主推流密钥到备用推流密钥
+	tracks      map[string]rtmp.TrackFilter // route 的轨道过滤，"" 为默认值
+	output      *flv.Writer                 // -output 的FLV，为nil时不输出
+
+	mu          sync.Mutex
+	listener    net.Listener
+	sessions    map[string]*session
+	groups      map[string]*group // 接受同一路推流其它客户端连接的会话，key 为app/streamName
+	outputOwner *session          // 正在写入 output 的会话
+	closing     bool
+	wg          sync.WaitGroup
+}
+
+func New(cfg *internal.Config, interceptor plugins.Interceptor) (*Server, error) {
+	guard, err := acl.NewGuard(cfg.Access)
+	if err != nil {
+		return nil, err
+	}
+	trusted, err := acl.ParseCIDRs(cfg.ProxyProtocolFrom)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.AcceptProxyProtocol && len(trusted) == 0 {
+		return nil, errors.New("trusted load balancers are required when PROXY protocol is accepted")
+	}
+	policies, err := plugins.ParsePolicies(cfg.HookPolicy)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := stats.ParseProfile(cfg.Profile)
+	if err != nil {
+		return nil, err
+	}
+	var filler *slate.Slate
+	if cfg.Slate != "" {
+		if filler, err = slate.Load(cfg.Slate); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Standby > 0 && filler == nil {
+		return nil, errors.New("slate is required when standby is enabled")
+	}
+	backups, err := parseFailover(cfg.Failover)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := shaper.New(cfg.SessionRate, cfg.GlobalRate)
+	if err != nil {
+		return nil, err
+	}
+	tracks, err := parseTracks(cfg.Tracks)
+	if err != nil {
+		return nil, err
+	}
+	output, err := openOutput(cfg.Output)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		cfg:         cfg,
+		interceptor: interceptor,
+		guard:       guard,
+		trusted:     trusted,
+		keyVerifier: auth.NewKeyVerifier(cfg.StreamKeys, cfg.KeySecret),
+		notifier:    auth.NewNotifier(cfg.OnPublish, cfg.OnDone, 10*time.Second),
+		policies:    policies,
+		profile:     profile,
+		shaper:      limits,
+		slate:       filler,
+		sessions:    make(map[string]*session),
+		backups:     backups,
+		tracks:      tracks,
+		output:      output,
+		groups:      make(map[string]*group),
+	}, nil
+}
+
+// Serve 循环接受客户端连接，Shutdown 后返回nil
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return net.ErrClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	slog.Info("Waiting for client connections", "addr", listener.Addr().String())
+	for {
+		clientConn, err := listener.Accept()
+		if err != nil {
+			if s.isClosing() {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			slog.Warn("Failed to accept connection", "err", err)
+			continue // 继续接受下一个连接
+		}
+
+		// 为每个客户端连接启动一个独立的 goroutine 处理
+		s.wg.Add(1)
+		go func(ClientConn net.Conn) {
+			defer s.wg.Done()
+			_ = s.handle(ClientConn) // 错误已经记录在会话的日志中
+		}(clientConn)
+	}
+}
+
+// Shutdown 停止接受连接，unpublish 为true时通知远程服务器结束推流并关闭所有会话，
+// 否则等待会话自行结束，ctx 到期后强制关闭剩余会话，并等待所有会话的插件事件执行完毕
+func (s *Server) Shutdown(ctx context.Context, unpublish bool) error {
+	s.mu.Lock()
+	s.closing = true
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+	sessions := s.activeSessions()
+	s.mu.Unlock()
+
+	slog.Info("Shutting down", "sessions", len(sessions))
+	if unpublish {
+		for _, sess := range sessions {
+			if sess.conn != nil {
+				sess.conn.Unpublish()
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	sessions = s.activeSessions()
+	s.mu.Unlock()
+	slog.Warn("Shutdown deadline exceeded, closing sessions", "sessions", len(sessions))
+	for _, sess := range sessions {
+		if sess.conn != nil {
+			sess.conn.Close()
+		}
+	}
+	<-done
+	return ctx.Err()
+}
+
+func (s *Server) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+// activeSessions 调用时需持有s.mu
+func (s *Server) activeSessions() []*session {
+	list := make([]*session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		list = append(list, sess)
+	}
+	return list
+}
+
+// addSession 注册会话，服务正在关闭时返回false
+func (s *Server) addSession(sess *session) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	s.sessions[sess.ID] = sess
+	return true
+}
+
+func (s *Server) removeSession(sess *session) {
+	s.mu.Lock()
+	delete(s.sessions, sess.ID)
+	s.mu.Unlock()
+}
+
+// SessionInfo 管理接口返回的会话信息
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	ClientAddr string    `json:"client_addr"`
+	App        string    `json:"app,omitempty"`
+	Stream     string    `json:"stream,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Publishing bool      `json:"publishing"`
+	StartTime  time.Time `json:"start_time"`
+	Uptime     float64   `json:"uptime"`           // 秒
+	Bitrate    uint64    `json:"bitrate"`          // 发往远程服务器的码率，bit/s
+	Source     string    `json:"source,omitempty"` // 正在转发的来源，client/primary/backup/slate
+	Delay      float64   `json:"delay,omitempty"`  // 延迟缓冲区中等待发送的时长，秒
+
+	Stats *internal.StreamStats `json:"stats,omitempty"` // 客户端推流的统计信息
+}
+
+// Sessions 返回所有活动会话，未开始推流的会话只包含地址和开始时间
+func (s *Server) Sessions() []SessionInfo {
+	s.mu.Lock()
+	sessions := s.activeSessions()
+	s.mu.Unlock()
+	list := make([]SessionInfo, 0, len(sessions))
+	for _, sess := range sessions {
+		info := SessionInfo{
+			ID:         sess.ID,
+			ClientAddr: sess.ClientAddr,
+			StartTime:  sess.StartTime,
+			Uptime:     time.Since(sess.StartTime).Seconds(),
+		}
+		sess.mu.Lock()
+		if sess.serving {
+			info.App, info.Stream = sess.ClientApp, sess.ClientName
+			info.RemoteAddr = utils.RedactLink(sess.RemoteAddr)
+			info.Publishing = true
+			info.Bitrate = sess.stats.bitrate.Load()
+			if src := sess.conn.Upstream().Active(); src != nil {
+				info.Source = src.Name()
+			}
+			if delay := sess.conn.Delay(); delay != nil {
+				info.Delay = delay.Buffered().Seconds()
+			}
+			st := sess.Stats()
+			info.Stats = &st
+		}
+		sess.mu.Unlock()
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartTime.Before(list[j].StartTime)
+	})
+	return list
+}
+
+// Kick 通知远程服务器结束推流并关闭会话
+func (s *Server) Kick(id string) error {
+	sess := s.lookupSession(id)
+	if sess == nil {
+		return utils.SessionNotFound
+	}
+	sess.Logger().Info("Kicking session")
+	sess.conn.Unpublish()
+	return nil
+}
+
+// SwitchUpstream 将正在推流的会话切换到新的远程地址，失败时继续使用原来的远程服务器
+func (s *Server) SwitchUpstream(id string, remoteAddr string) error {
+	sess := s.lookupSession(id)
+	if sess == nil {
+		return utils.SessionNotFound
+	}
+	// 连接和推流到新的远程服务器时不持有锁，避免阻塞会话信息的查询
+	sess.mu.Lock()
+	if !sess.serving {
+		sess.mu.Unlock()
+		return utils.SessionNotPublishing
+	}
+	if sess.switching {
+		sess.mu.Unlock()
+		return utils.SwitchInProgress
+	}
+	sess.switching = true
+	sess.switches.Add(1)
+	sess.mu.Unlock()
+	defer func() {
+		sess.mu.Lock()
+		sess.switching = false
+		sess.mu.Unlock()
+		sess.switches.Done()
+	}()
+
+	ServerConn, remoteURL, err := s.cfg.ConnectRemoteAddress(remoteAddr, sess.conn.ClientConn.RemoteAddr(), sess.Logger())
+	if err != nil {
+		return err
+	}
+	ServerConn = sess.limiter.Wrap(ServerConn)
+	appName, streamName, playUrl, err := utils.GetLinkParams(remoteURL)
+	if err == nil {
+		sess.AddSecret(streamName)
+		err = sess.conn.SwitchServer(ServerConn, appName, playUrl, streamName)
+	}
+	if err != nil {
+		_ = ServerConn.Close()
+		return err
+	}
+	sess.mu.Lock()
+	sess.RemoteAddr, sess.AppName, sess.StreamName = remoteAddr, appName, streamName
+	sess.stats.switchRemote(remoteURL.Host)
+	sess.mu.Unlock()
+	sess.Logger().Info("Session switched to remote server", "remote", remoteURL.Host)
+	return nil
+}
+
+func (s *Server) lookupSession(id string) *session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[id]
+}
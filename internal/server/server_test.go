@@ -0,0 +1,235 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	amf "github.com/zhangpeihao/goamf"
+	"io"
+	"net"
+	"rtmpproxy/internal"
+	"rtmpproxy/internal/plugins"
+	"rtmpproxy/internal/rtmp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startServer 在回环地址上启动代理，remote 为远程服务器地址，configure 可以修改其它配置，返回监听地址
+func startServer(t *testing.T, remote string, interceptor plugins.Interceptor, configure func(cfg *internal.Config)) (*Server, string) {
+	t.Helper()
+	listen, proxyAddr := "127.0.0.1:0", ""
+	cfg := &internal.Config{ListenAddr: &listen, RemoteAddr: &remote, ProxyAddr: &proxyAddr}
+	if configure != nil {
+		configure(cfg)
+	}
+	if interceptor == nil {
+		interceptor = &plugins.DefaultInterceptor{}
+	}
+	s, err := New(cfg, interceptor)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go func() {
+		_ = s.Serve(listener)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = s.Shutdown(ctx, true)
+	})
+	return s, listener.Addr().String()
+}
+
+// recorder 记录 BeforeEstablishTCPConnection 和 AfterCloseTCPConnection 时的会话
+type recorder struct {
+	plugins.DefaultInterceptor
+	sessions chan internal.Session
+	closed   chan internal.Session
+}
+
+func newRecorder() *recorder {
+	return &recorder{sessions: make(chan internal.Session, 8), closed: make(chan internal.Session, 8)}
+}
+
+func (r *recorder) BeforeEstablishTCPConnection(s *internal.Session) error {
+	r.sessions <- *s
+	return nil
+}
+
+func (r *recorder) AfterCloseTCPConnection(s *internal.Session) error {
+	r.closed <- *s
+	return nil
+}
+
+func (r *recorder) next(t *testing.T) internal.Session {
+	t.Helper()
+	return receive(t, r.sessions, "session")
+}
+
+// receive 等待ch中的下一个值
+func receive[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		var zero T
+		return zero
+	}
+}
+
+// ingest 接受推流的远程服务器，记录推流请求和收到的媒体消息数量
+type ingest struct {
+	published chan *rtmp.PublishRequest
+	ended     chan error // 收到FCUnpublish或deleteStream时为io.EOF，否则为连接的错误
+
+	mu       sync.Mutex
+	messages int
+}
+
+func startIngest(t *testing.T) (*ingest, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	i := &ingest{published: make(chan *rtmp.PublishRequest, 8), ended: make(chan error, 8)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go i.serve(conn)
+		}
+	}()
+	return i, listener.Addr().String()
+}
+
+func (i *ingest) serve(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	c := rtmp.NewConn(conn)
+	if c.ServerHandshake() != nil {
+		return
+	}
+	req, err := c.ReadPublish(nil)
+	if err != nil || c.AcceptPublish(req) != nil {
+		return
+	}
+	i.published <- req
+	for {
+		msg, err := c.ReadMessage()
+		if err != nil {
+			i.ended <- err
+			return
+		}
+		switch msg.TypeID {
+		case rtmp.TypeAudio, rtmp.TypeVideo, rtmp.TypeDataAMF0:
+			i.mu.Lock()
+			i.messages++
+			i.mu.Unlock()
+		case rtmp.TypeCommandAMF0:
+			name, _ := amf.ReadString(bytes.NewReader(msg.Payload))
+			if name == "FCUnpublish" || name == "deleteStream" {
+				i.ended <- io.EOF
+				return
+			}
+		}
+	}
+}
+
+// waitMessages 等到收到n条媒体消息
+func (i *ingest) waitMessages(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		i.mu.Lock()
+		got := i.messages
+		i.mu.Unlock()
+		if got >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d messages", n)
+}
+
+// publish 在conn上作为客户端握手并开始推流
+func publish(conn net.Conn, app string, streamName string) (*rtmp.Conn, uint32, error) {
+	c := rtmp.NewConn(conn)
+	if err := c.ClientHandshake(); err != nil {
+		return nil, 0, err
+	}
+	err := c.Connect(amf.Object{
+		"app":      app,
+		"tcUrl":    "rtmp://" + conn.RemoteAddr().String() + "/" + app,
+		"flashVer": "FMLE/3.0 (compatible; FMSc/1.0)",
+		"type":     "nonprivate",
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	streamID, err := c.Publish(streamName, "live")
+	if err != nil {
+		return nil, 0, err
+	}
+	return c, streamID, nil
+}
+
+// dialPublish 连接代理并开始推流，测试结束时关闭连接
+func dialPublish(t *testing.T, addr string, app string, streamName string) (*rtmp.Conn, uint32) {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	c, streamID, err := publish(conn, app, streamName)
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	return c, streamID
+}
+
+// writeFrames 发送n个视频帧，第一帧为关键帧
+func writeFrames(c *rtmp.Conn, streamID uint32, n int) error {
+	for i := 0; i < n; i++ {
+		payload := []byte{0x27, 0x01, 0x00, 0x00, 0x00, byte(i)}
+		if i == 0 {
+			payload[0] = 0x17
+		}
+		msg := &rtmp.Message{TypeID: rtmp.TypeVideo, StreamID: streamID, Timestamp: uint32(i * 33), Payload: payload}
+		if err := c.WriteMessage(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitPublishing 等到代理有一个正在推流的会话并返回
+func waitPublishing(t *testing.T, s *Server) SessionInfo {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, info := range s.Sessions() {
+			if info.Publishing {
+				return info
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for publishing session")
+	return SessionInfo{}
+}
@@ -0,0 +1,212 @@
+package server
+
+// 单个客户端连接的处理流程
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"rtmpproxy/internal"
+	"rtmpproxy/internal/acl"
+	"rtmpproxy/internal/metrics"
+	"rtmpproxy/internal/plugins"
+	"rtmpproxy/internal/proxyproto"
+	"rtmpproxy/internal/rtmp"
+	"rtmpproxy/utils"
+	"time"
+)
+
+// handle 处理单个客户端连接，失败时记录并返回 *SessionError
+func (s *Server) handle(ClientConn net.Conn) (err error) {
+	defer func(ClientConn net.Conn) {
+		_ = ClientConn.Close()
+	}(ClientConn)
+	// 负载均衡之后通过PROXY头获取真实的客户端地址，只信任配置的负载均衡发送的PROXY头，
+	// 其它连接按原始地址做访问控制，不等待PROXY头
+	if s.cfg.AcceptProxyProtocol && acl.Contains(s.trusted, ClientConn.RemoteAddr()) {
+		proxyConn, err := proxyproto.ReadHeader(ClientConn)
+		if err != nil {
+			slog.Warn("Invalid PROXY protocol header", "addr", ClientConn.RemoteAddr().String(), "err", err)
+			return nil
+		}
+		ClientConn = proxyConn
+	}
+	release, err := s.guard.Acquire(ClientConn.RemoteAddr())
+	if err != nil {
+		slog.Info("Refused connection", "addr", ClientConn.RemoteAddr().String(), "err", err)
+		return nil
+	}
+	defer release()
+
+	info := internal.NewSession(ClientConn.RemoteAddr().String(), *s.cfg.RemoteAddr)
+	sess := &session{
+		Session: info,
+		conn:    rtmp.CreateRTMPInstance(ClientConn, s.cfg.FlashVer, s.cfg.RTMPType, info.Logger()),
+	}
+	if !s.addSession(sess) {
+		return nil
+	}
+	defer s.removeSession(sess)
+	session, rtmpConnection := sess.Session, sess.conn
+	fail := func(stage string, err error) error {
+		return &SessionError{SessionID: session.ID, Stage: stage, Err: err}
+	}
+	// 在 AfterCloseTCPConnection 之后执行，包含其错误
+	defer func() {
+		logSessionError(session.Logger(), err)
+	}()
+	session.Logger().Info("Client connected", "addr", session.ClientAddr)
+
+	// 与客户端握手并读取推流参数
+	handshakeStart := time.Now()
+	err = rtmpConnection.RTMPHandshake()
+	if err != nil {
+		s.guard.Fail(ClientConn.RemoteAddr())
+		return fail(StageHandshake, err)
+	}
+	metrics.HandshakeDuration.WithLabelValues("client").Observe(time.Since(handshakeStart).Seconds())
+	publishReq, err := rtmpConnection.ReadPublish(func(req *rtmp.PublishRequest) error {
+		return s.keyVerifier.VerifyConnect(req.Args)
+	})
+	if err != nil {
+		s.guard.Fail(ClientConn.RemoteAddr())
+		return fail(StagePublish, err)
+	}
+	session.ClientApp, session.ClientName = publishReq.App, publishReq.StreamName
+	session.AddSecret(publishReq.StreamName)
+	session.Logger().Info("Client publish request", "app", publishReq.App, "stream", publishReq.StreamName, "type", publishReq.Type)
+
+	// 本地推流密钥校验
+	err = s.keyVerifier.Verify(publishReq.StreamName, publishReq.Args)
+	if err != nil {
+		_ = rtmpConnection.Reject("NetStream.Publish.BadName", err.Error())
+		s.guard.Fail(ClientConn.RemoteAddr())
+		return fail(StageAuthorize, err)
+	}
+
+	// on_publish 鉴权，3xx 可重定向到新的远程地址
+	redirect, err := s.notifier.OnPublish(session, publishReq)
+	if err != nil {
+		_ = rtmpConnection.Reject("NetStream.Publish.BadName", "publish unauthorized")
+		s.guard.Fail(ClientConn.RemoteAddr())
+		return fail(StageAuthorize, err)
+	}
+	s.guard.Succeed(ClientConn.RemoteAddr())
+	if redirect != "" {
+		session.RemoteAddr = redirect
+	}
+	defer s.notifier.OnDone(session, publishReq)
+
+	// 同一路推流正在进行的会话，由其转发当前连接：断线重连或主备推流
+	role, feedKey := s.feedRole(publishReq.App, publishReq.StreamName)
+	if s.managedFeeds(role) {
+		if done, ok := s.attach(feedKey, role, rtmpConnection, session.ClientAddr); ok {
+			s.removeSession(sess)
+			<-done
+			return nil
+		}
+	}
+
+	// 连接远程RTMP服务器
+	err = s.policies.Call(session.Logger(), plugins.StageBeforeEstablishTCPConnection, func() error {
+		return s.interceptor.BeforeEstablishTCPConnection(session)
+	})
+	if err != nil {
+		_ = rtmpConnection.Reject("NetStream.Failed", "publish failed")
+		return fail(plugins.StageBeforeEstablishTCPConnection, err)
+	}
+	// BeforeEstablishTCPConnection 成功后，无论之后是否失败都需要调用 AfterCloseTCPConnection
+	defer func() {
+		closeErr := s.policies.Call(session.Logger(), plugins.StageAfterCloseTCPConnection, func() error {
+			return s.interceptor.AfterCloseTCPConnection(session)
+		})
+		if closeErr != nil && err == nil {
+			err = fail(plugins.StageAfterCloseTCPConnection, closeErr)
+		}
+	}()
+
+	if session.RemoteAddr == "" {
+		return fail(StageConnectRemote, utils.RemoteAddrRequired)
+	}
+	ServerConn, remoteURL, err := s.cfg.ConnectRemoteAddress(session.RemoteAddr, ClientConn.RemoteAddr(), session.Logger())
+	if err != nil {
+		_ = rtmpConnection.Reject("NetStream.Failed", "failed to connect remote server")
+		return fail(StageConnectRemote, err)
+	}
+	sess.limiter = s.shaper.Limiter(session.ClientApp)
+	ServerConn = sess.limiter.Wrap(ServerConn)
+	defer func(ServerConn net.Conn) {
+		_ = ServerConn.Close()
+	}(ServerConn)
+
+	appName, streamName, playUrl, err := utils.GetLinkParams(remoteURL)
+	if err != nil {
+		return fail(StageConnectRemote, err)
+	}
+	session.AppName, session.StreamName = appName, streamName
+	session.AddSecret(streamName)
+	handshakeStart = time.Now()
+	err = rtmpConnection.ConnectServer(ServerConn, appName, playUrl, streamName)
+	if err != nil {
+		_ = rtmpConnection.Reject("NetStream.Failed", "failed to publish to remote server")
+		return fail(StageConnectRemote, err)
+	}
+	metrics.HandshakeDuration.WithLabelValues("remote").Observe(time.Since(handshakeStart).Seconds())
+	err = s.policies.Call(session.Logger(), plugins.StageAfterRTMPHandshake, func() error {
+		return s.interceptor.AfterRTMPHandshake(session)
+	})
+	if err != nil {
+		return fail(plugins.StageAfterRTMPHandshake, err)
+	}
+	rtmpConnection.Upstream().RepairJumps(s.cfg.TimestampJump)
+	rtmpConnection.Upstream().QueueWrites(s.cfg.SendQueue)
+	if tracks := s.tracksOf(session.ClientApp); tracks != (rtmp.TrackFilter{}) {
+		session.Logger().Info("Filtering stream tracks", "tracks", tracks.String())
+		rtmpConnection.Upstream().FilterTracks(tracks)
+	}
+	if s.claimOutput(sess) {
+		rtmpConnection.Upstream().Tee(s.output)
+		defer s.releaseOutput(sess)
+	}
+	if s.cfg.Delay > 0 {
+		rtmpConnection.DelayOutput(s.cfg.Delay)
+	}
+	stopStats := s.trackStats(session, rtmpConnection)
+	stopHealth := s.watchHealth(session, rtmpConnection)
+	sess.mu.Lock()
+	sess.stats = trackSession(rtmpConnection, session.ClientApp, remoteURL.Host)
+	sess.serving = true
+	sess.mu.Unlock()
+	if s.managedFeeds(role) {
+		err = s.serveFeeds(sess, role, feedKey)
+	} else {
+		err = rtmpConnection.Serve()
+	}
+	rtmpConnection.Close()
+	// 不再接受新的切换并等待正在进行的切换完成，之后只在当前goroutine中访问 session
+	sess.mu.Lock()
+	sess.serving = false
+	sess.mu.Unlock()
+	sess.switches.Wait()
+	sess.stats.close()
+	stopStats()
+	stopHealth()
+	session.Logger().Info("Session closed", "duration", time.Since(session.StartTime).Round(time.Second).String())
+	if err != nil {
+		return fail(StageServe, err)
+	}
+	return nil
+}
+
+// logSessionError 记录会话错误，不影响其它会话
+func logSessionError(logger *slog.Logger, err error) {
+	if err == nil {
+		return
+	}
+	var sessionErr *SessionError
+	if errors.As(err, &sessionErr) {
+		logger.Error("Session failed", "stage", sessionErr.Stage, "err", sessionErr.Err)
+		return
+	}
+	logger.Error("Session failed", "err", err)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"io"
+	"net"
+	"rtmpproxy/internal"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialWithHeader 连接代理并先发送header
+func dialWithHeader(t *testing.T, addr string, header string) net.Conn {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	if _, err = io.WriteString(conn, header); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestProxyProtocolTrusted(t *testing.T) {
+	ingest, ingestAddr := startIngest(t)
+	rec := newRecorder()
+	_, addr := startServer(t, "rtmp://"+ingestAddr+"/live/remotekey", rec, func(cfg *internal.Config) {
+		cfg.AcceptProxyProtocol = true
+		cfg.ProxyProtocolFrom = []string{"127.0.0.1"}
+		cfg.Access.Deny = []string{"203.0.113.0/24"}
+	})
+
+	// 受信任的负载均衡发送的PROXY头替换客户端地址
+	conn := dialWithHeader(t, addr, "PROXY TCP4 198.51.100.7 127.0.0.1 40000 1935\r\n")
+	if _, _, err := publish(conn, "live", "key"); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if got := rec.next(t).ClientAddr; got != "198.51.100.7:40000" {
+		t.Errorf("ClientAddr = %s, want 198.51.100.7:40000", got)
+	}
+	receive(t, ingest.published, "publish")
+
+	// 访问控制使用PROXY头中的地址
+	conn = dialWithHeader(t, addr, "PROXY TCP4 203.0.113.7 127.0.0.1 40000 1935\r\n")
+	if _, _, err := publish(conn, "live", "key"); err == nil {
+		t.Errorf("publish from denied address succeeded")
+	}
+}
+
+func TestProxyProtocolUntrusted(t *testing.T) {
+	ingest, ingestAddr := startIngest(t)
+	rec := newRecorder()
+	_, addr := startServer(t, "rtmp://"+ingestAddr+"/live/remotekey", rec, func(cfg *internal.Config) {
+		cfg.AcceptProxyProtocol = true
+		cfg.ProxyProtocolFrom = []string{"10.0.0.0/8"}
+	})
+
+	// 不受信任的地址发送的PROXY头不会被解析，握手失败
+	conn := dialWithHeader(t, addr, "PROXY TCP4 198.51.100.7 127.0.0.1 40000 1935\r\n")
+	if _, _, err := publish(conn, "live", "key"); err == nil {
+		t.Errorf("publish with untrusted PROXY header succeeded")
+	}
+
+	// 不发送PROXY头时按原始地址处理
+	dialPublish(t, addr, "live", "key")
+	if got := rec.next(t).ClientAddr; !strings.HasPrefix(got, "127.0.0.1:") {
+		t.Errorf("ClientAddr = %s, want 127.0.0.1", got)
+	}
+	receive(t, ingest.published, "publish")
+}
+
+func TestProxyProtocolDeniedPeer(t *testing.T) {
+	_, ingestAddr := startIngest(t)
+	_, addr := startServer(t, "rtmp://"+ingestAddr+"/live/remotekey", nil, func(cfg *internal.Config) {
+		cfg.AcceptProxyProtocol = true
+		cfg.ProxyProtocolFrom = []string{"10.0.0.0/8"}
+		cfg.Access.Deny = []string{"127.0.0.1"}
+	})
+
+	// 被拒绝的地址不会等待PROXY头，连接立即关闭
+	conn := dialWithHeader(t, addr, "")
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read = %v, want EOF", err)
+	}
+}
+
+func TestProxyProtocolRequiresTrusted(t *testing.T) {
+	listen, remote, proxyAddr := "127.0.0.1:0", "rtmp://127.0.0.1/live/key", ""
+	cfg := &internal.Config{ListenAddr: &listen, RemoteAddr: &remote, ProxyAddr: &proxyAddr, AcceptProxyProtocol: true}
+	if _, err := New(cfg, nil); err == nil {
+		t.Errorf("New without trusted load balancers succeeded")
+	}
+}
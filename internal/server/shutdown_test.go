@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestShutdownUnpublish(t *testing.T) {
+	ingest, ingestAddr := startIngest(t)
+	rec := newRecorder()
+	s, addr := startServer(t, "rtmp://"+ingestAddr+"/live/remotekey", rec, nil)
+
+	c, streamID := dialPublish(t, addr, "live", "key")
+	receive(t, ingest.published, "publish")
+	if err := writeFrames(c, streamID, 3); err != nil {
+		t.Fatal(err)
+	}
+	ingest.waitMessages(t, 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx, true); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	// 远程服务器收到结束推流，会话的 AfterCloseTCPConnection 在 Shutdown 返回前执行
+	if err := receive(t, ingest.ended, "unpublish"); err != io.EOF {
+		t.Errorf("remote ended with %v, want unpublish", err)
+	}
+	select {
+	case <-rec.closed:
+	default:
+		t.Error("AfterCloseTCPConnection not called before Shutdown returned")
+	}
+
+	// 不再接受新的连接
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		_ = conn.Close()
+		t.Error("connection accepted after shutdown")
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	ingest, ingestAddr := startIngest(t)
+	rec := newRecorder()
+	s, addr := startServer(t, "rtmp://"+ingestAddr+"/live/remotekey", rec, nil)
+	dialPublish(t, addr, "live", "key")
+	receive(t, ingest.published, "publish")
+
+	// 不通知远程服务器时等待会话自行结束，超时后强制关闭
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := s.Shutdown(ctx, false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Shutdown returned after %v, before the deadline", elapsed)
+	}
+	select {
+	case <-rec.closed:
+	default:
+		t.Error("AfterCloseTCPConnection not called before Shutdown returned")
+	}
+	receive(t, ingest.ended, "remote publish end")
+}
+
+func TestServeAfterShutdown(t *testing.T) {
+	s, _ := startServer(t, "rtmp://127.0.0.1:1/live/key", nil, nil)
+	if err := s.Shutdown(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	if err = s.Serve(listener); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Serve after Shutdown = %v, want ErrClosed", err)
+	}
+}
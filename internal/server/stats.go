@@ -0,0 +1,142 @@
+package server
+
+// 会话推流统计的定期汇总、编码参数和平台限制的检查
+
+import (
+	"rtmpproxy/internal"
+	"rtmpproxy/internal/metrics"
+	"rtmpproxy/internal/plugins"
+	"rtmpproxy/internal/rtmp"
+	"rtmpproxy/internal/stats"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// streamReporter 记录单个会话的统计信息
+type streamReporter struct {
+	server  *Server
+	session *internal.Session
+
+	mu         sync.Mutex
+	infoLabels []string        // 当前计入 StreamInfo 的标签
+	violated   map[string]bool // 已经警告过的规则
+}
+
+// trackStats 统计会话的推流数据，interval 大于0时定期记录汇总并通知插件，返回停止统计的函数
+func (s *Server) trackStats(session *internal.Session, conn *rtmp.RTMPConnection) (stop func()) {
+	r := &streamReporter{server: s, session: session, violated: make(map[string]bool)}
+	tracker := stats.NewTracker(r.onHeader)
+	conn.Observe(tracker.Observe)
+	statsOf := func() internal.StreamStats {
+		st := tracker.Stats()
+		st.DroppedFrames = conn.Upstream().Drops().Total()
+		return st
+	}
+	session.SetStatsSource(statsOf)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if s.cfg.StatsInterval <= 0 {
+			<-done
+			return
+		}
+		ticker := time.NewTicker(s.cfg.StatsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.report(statsOf())
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		r.setInfo(nil)
+	}
+}
+
+// onHeader 解析到新的sequence header后记录编码参数
+func (r *streamReporter) onHeader(st internal.StreamStats) {
+	r.session.Logger().Info("Stream info",
+		"video_codec", st.VideoCodec,
+		"resolution", resolution(st),
+		"video_profile", st.VideoProfile,
+		"video_level", st.VideoLevel,
+		"audio_codec", st.AudioCodec,
+		"audio_profile", st.AudioProfile,
+		"sample_rate", st.SampleRate,
+		"channels", st.Channels,
+	)
+	r.setInfo([]string{
+		r.session.ClientApp,
+		st.VideoCodec,
+		resolution(st),
+		st.VideoProfile,
+		st.AudioCodec,
+		strconv.Itoa(st.SampleRate),
+		strconv.Itoa(st.Channels),
+	})
+	r.check(st)
+}
+
+// report 记录统计汇总，检查平台限制，并通知实现了 StatsObserver 的插件
+func (r *streamReporter) report(st internal.StreamStats) {
+	r.session.Logger().Info("Stream stats",
+		"video_codec", st.VideoCodec,
+		"video_bitrate", st.VideoBitrate,
+		"fps", st.FPS,
+		"keyframe_interval", st.KeyframeInterval,
+		"audio_codec", st.AudioCodec,
+		"audio_bitrate", st.AudioBitrate,
+		"dropped_frames", st.DroppedFrames,
+	)
+	r.check(st)
+	if observer, ok := r.server.interceptor.(plugins.StatsObserver); ok {
+		observer.OnStats(r.session, st)
+	}
+}
+
+// check 参数超出 -profile 限制时警告，每条规则只警告一次
+func (r *streamReporter) check(st internal.StreamStats) {
+	profile := r.server.profile
+	if profile == nil {
+		return
+	}
+	for _, v := range profile.Check(st) {
+		r.mu.Lock()
+		reported := r.violated[v.Rule]
+		r.violated[v.Rule] = true
+		r.mu.Unlock()
+		if reported {
+			continue
+		}
+		r.session.Logger().Warn("Stream exceeds platform profile", "profile", profile.Name, "rule", v.Rule, "detail", v.Message)
+		metrics.ProfileViolations.WithLabelValues(r.session.ClientApp, v.Rule).Inc()
+	}
+}
+
+// setInfo 更新 StreamInfo 的标签，nil 表示会话结束
+func (r *streamReporter) setInfo(labels []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.infoLabels != nil {
+		metrics.StreamInfo.WithLabelValues(r.infoLabels...).Dec()
+	}
+	if labels != nil {
+		metrics.StreamInfo.WithLabelValues(labels...).Inc()
+	}
+	r.infoLabels = labels
+}
+
+func resolution(st internal.StreamStats) string {
+	if st.Width == 0 {
+		return ""
+	}
+	return strconv.Itoa(st.Width) + "x" + strconv.Itoa(st.Height)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"rtmpproxy/internal/rtmptest"
+	"rtmpproxy/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSwitchUpstream(t *testing.T) {
+	first, firstAddr := rtmptest.StartIngest(t)
+	second, secondAddr := rtmptest.StartIngest(t)
+	s, addr := startServer(t, "rtmp://"+firstAddr+"/live/first", nil, nil)
+
+	p, err := rtmptest.Dial(addr, "live", "key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = p.Close()
+	}()
+	first.WaitPublish(t, 1, 5*time.Second)
+	info := waitPublishing(t, s)
+
+	// 远程服务器接受连接但不握手，切换会一直等待
+	hang, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := hang.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	switched := make(chan error, 1)
+	go func() {
+		switched <- s.SwitchUpstream(info.ID, "rtmp://"+hang.Addr().String()+"/live/hang")
+	}()
+	var hung net.Conn
+	select {
+	case hung = <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for switch to dial")
+	}
+
+	// 切换过程中可以查询会话，同一会话的其它切换被拒绝
+	done := make(chan []SessionInfo, 1)
+	go func() {
+		done <- s.Sessions()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Sessions blocked by switch in progress")
+	}
+	if err = s.SwitchUpstream(info.ID, "rtmp://"+secondAddr+"/live/second"); !errors.Is(err, utils.SwitchInProgress) {
+		t.Fatalf("concurrent switch: err = %v, want SwitchInProgress", err)
+	}
+
+	// 新的远程服务器断开后切换失败，继续使用原来的远程服务器
+	_ = hung.Close()
+	_ = hang.Close()
+	if err = <-switched; err == nil {
+		t.Fatal("switch to hung server succeeded")
+	}
+	if got := waitPublishing(t, s).RemoteAddr; !strings.Contains(got, firstAddr) {
+		t.Errorf("remote after failed switch = %s, want %s", got, firstAddr)
+	}
+
+	if err = s.SwitchUpstream(info.ID, "rtmp://"+secondAddr+"/live/second"); err != nil {
+		t.Fatalf("switch: %v", err)
+	}
+	second.WaitPublish(t, 1, 5*time.Second)
+	second.AssertPublish(t, "live", "second")
+	if got := waitPublishing(t, s).RemoteAddr; !strings.Contains(got, secondAddr) {
+		t.Errorf("remote after switch = %s, want %s", got, secondAddr)
+	}
+	if err = p.WriteFrames(5); err != nil {
+		t.Fatal(err)
+	}
+	second.WaitMessages(t, 1, 5*time.Second)
+}
+
+func TestSwitchUpstreamNotPublishing(t *testing.T) {
+	_, ingestAddr := rtmptest.StartIngest(t)
+	s, _ := startServer(t, "rtmp://"+ingestAddr+"/live/key", nil, nil)
+	if err := s.SwitchUpstream("missing", "rtmp://"+ingestAddr+"/live/other"); !errors.Is(err, utils.SessionNotFound) {
+		t.Errorf("err = %v, want SessionNotFound", err)
+	}
+}
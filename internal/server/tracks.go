@@ -0,0 +1,33 @@
+package server
+
+import (
+	"fmt"
+	"rtmpproxy/internal/rtmp"
+	"rtmpproxy/utils"
+	"strings"
+)
+
+// parseTracks 解析按route配置的轨道过滤 "novideo,radio=novideo+nodata,mute=noaudio"，不带route的为默认值
+func parseTracks(s string) (map[string]rtmp.TrackFilter, error) {
+	filters := make(map[string]rtmp.TrackFilter)
+	for _, item := range utils.SplitList(s) {
+		route, options, ok := strings.Cut(item, "=")
+		if !ok {
+			route, options = "", item
+		}
+		filter, err := rtmp.ParseTrackFilter(options)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tracks %q: %w", item, err)
+		}
+		filters[strings.TrimSpace(route)] = filter
+	}
+	return filters, nil
+}
+
+// tracksOf 返回route的轨道过滤，没有单独配置时使用默认值
+func (s *Server) tracksOf(route string) rtmp.TrackFilter {
+	if filter, ok := s.tracks[route]; ok {
+		return filter
+	}
+	return s.tracks[""]
+}
@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"rtmpproxy/internal/logging"
+	"time"
+)
+
+// Session 单个客户端连接的会话信息，在各个Interceptor事件之间传递
+type Session struct {
+	ID         string    // 会话ID
+	ClientAddr string    // 客户端地址
+	ClientApp  string    // 客户端connect的app
+	ClientName string    // 客户端publish的streamName
+	RemoteAddr string    // 远程RTMP服务器地址，插件可以在BeforeEstablishTCPConnection中修改
+	AppName    string    // 发往远程服务器的appName
+	StreamName string    // 发往远程服务器的streamName
+	StartTime  time.Time // 会话开始时间
+	logger     *slog.Logger
+	addSecret  func(values ...string)
+	stats      func() StreamStats
+}
+
+// NewSession 创建会话，RemoteAddr默认为全局配置的远程地址
+func NewSession(clientAddr string, remoteAddr string) *Session {
+	var id [4]byte
+	_, _ = rand.Read(id[:])
+	s := &Session{
+		ID:         hex.EncodeToString(id[:]),
+		ClientAddr: clientAddr,
+		RemoteAddr: remoteAddr,
+		StartTime:  time.Now(),
+	}
+	s.logger, s.addSecret = logging.Scoped(slog.Default().With("session", s.ID))
+	return s
+}
+
+// Logger 返回附带会话ID的logger，会隐藏通过 AddSecret 添加的值
+func (s *Session) Logger() *slog.Logger {
+	return s.logger
+}
+
+// AddSecret 添加需要在该会话的日志中隐藏的值，例如推流密钥
+func (s *Session) AddSecret(values ...string) {
+	s.addSecret(values...)
+}
+
+// Stats 返回推流的统计信息，开始推流之前为空
+func (s *Session) Stats() StreamStats {
+	if s.stats == nil {
+		return StreamStats{}
+	}
+	return s.stats()
+}
+
+// SetStatsSource 设置 Stats 的数据来源，由会话的处理流程在开始推流前调用
+func (s *Session) SetStatsSource(fn func() StreamStats) {
+	s.stats = fn
+}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"rtmpproxy/internal/logging"
+	"testing"
+)
+
+func TestSessionLogger(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(logging.NewHandler(slog.NewJSONHandler(&buf, nil))))
+
+	first := NewSession("127.0.0.1:40000", "rtmp://example.com/live/remote-key")
+	second := NewSession("127.0.0.1:40001", "rtmp://example.com/live/remote-key")
+	if first.ID == second.ID || len(first.ID) != 8 {
+		t.Errorf("session ids %q and %q", first.ID, second.ID)
+	}
+	first.AddSecret("client-key")
+	first.Logger().Info("Publish", "stream", "live/client-key")
+	second.Logger().Info("Publish", "stream", "live/client-key")
+
+	var records []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var record map[string]any
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records", len(records))
+	}
+	if records[0]["session"] != first.ID || records[0]["stream"] != "live/***" {
+		t.Errorf("first session record = %v", records[0])
+	}
+	// 推流密钥只在添加的会话中隐藏
+	if records[1]["session"] != second.ID || records[1]["stream"] != "live/client-key" {
+		t.Errorf("second session record = %v", records[1])
+	}
+}
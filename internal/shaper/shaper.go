@@ -0,0 +1,162 @@
+package shaper
+
+// 发往远程服务器的带宽限制，每个会话和所有会话合计各有一个令牌桶
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"rtmpproxy/utils"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 令牌桶的容量按该时长的流量计算，容量越小发送越平滑
+	burstWindow = 20 * time.Millisecond
+	// 令牌桶的最小容量(字节)
+	minBurst = 4096
+	// 每次写入的最大字节数，较大的写入拆分后按速率依次发送
+	writeChunk = 4096
+)
+
+// Bucket 令牌桶，令牌为字节，可以被多个连接共用
+type Bucket struct {
+	rate  float64 // 字节/秒
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket 创建速率为bitsPerSecond的令牌桶
+func NewBucket(bitsPerSecond uint64) *Bucket {
+	rate := float64(bitsPerSecond) / 8
+	burst := math.Max(rate*burstWindow.Seconds(), minBurst)
+	return &Bucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve 取出n个令牌，令牌不足时透支，返回需要等待的时长
+func (b *Bucket) reserve(n int, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Shaper 根据会话的route选择限速，route 为客户端connect的app
+type Shaper struct {
+	global   *Bucket
+	fallback uint64            // 未单独配置的route的单个会话限速
+	routes   map[string]uint64 // route 的单个会话限速
+}
+
+// New 解析限速参数，sessionRate 为 "6M,live=8M,backup=0"，不带route的为默认值，0 为不限制，
+// globalRate 为所有会话合计的限速，均为空时返回nil
+func New(sessionRate string, globalRate string) (*Shaper, error) {
+	s := &Shaper{routes: make(map[string]uint64)}
+	for _, item := range utils.SplitList(sessionRate) {
+		route, value, ok := strings.Cut(item, "=")
+		if !ok {
+			route, value = "", item
+		}
+		rate, err := utils.ParseBitrate(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid session rate %q: %w", item, err)
+		}
+		if !ok {
+			s.fallback = rate
+			continue
+		}
+		s.routes[strings.TrimSpace(route)] = rate
+	}
+	if globalRate != "" {
+		rate, err := utils.ParseBitrate(globalRate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid global rate %q: %w", globalRate, err)
+		}
+		if rate > 0 {
+			s.global = NewBucket(rate)
+		}
+	}
+	if s.global == nil && s.fallback == 0 && len(s.routes) == 0 {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// Limiter 返回一个新会话的限速，切换远程服务器后继续使用同一个 Limiter，不限速时返回nil
+func (s *Shaper) Limiter(route string) *Limiter {
+	if s == nil {
+		return nil
+	}
+	rate, ok := s.routes[route]
+	if !ok {
+		rate = s.fallback
+	}
+	var buckets []*Bucket
+	if rate > 0 {
+		buckets = append(buckets, NewBucket(rate))
+	}
+	if s.global != nil {
+		buckets = append(buckets, s.global)
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+	return &Limiter{buckets: buckets}
+}
+
+// Limiter 单个会话的限速
+type Limiter struct {
+	buckets []*Bucket
+}
+
+// Wrap 返回写入时限速的连接，l 为nil时返回conn
+func (l *Limiter) Wrap(conn net.Conn) net.Conn {
+	if l == nil {
+		return conn
+	}
+	return &limitedConn{Conn: conn, limiter: l}
+}
+
+// wait 等待直到所有令牌桶都有n个令牌
+func (l *Limiter) wait(n int) {
+	now := time.Now()
+	var wait time.Duration
+	for _, b := range l.buckets {
+		wait = max(wait, b.reserve(n, now))
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+type limitedConn struct {
+	net.Conn
+	limiter *Limiter
+}
+
+func (c *limitedConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), writeChunk)
+		c.limiter.wait(n)
+		m, err := c.Conn.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,144 @@
+package shaper
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	s, err := New("6M,live=8M, backup = 0", "20M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.fallback != 6000000 || s.routes["live"] != 8000000 || s.routes["backup"] != 0 {
+		t.Errorf("fallback = %d, routes = %v", s.fallback, s.routes)
+	}
+	if s.global == nil || s.global.rate != 20000000/8 {
+		t.Errorf("global = %+v, want 2.5MB/s", s.global)
+	}
+
+	tests := []struct {
+		route   string
+		buckets int
+	}{
+		{"live", 2},
+		{"other", 2},
+		{"backup", 1}, // 只有全局限速
+	}
+	for _, tt := range tests {
+		if l := s.Limiter(tt.route); l == nil || len(l.buckets) != tt.buckets {
+			t.Errorf("Limiter(%q) = %+v, want %d buckets", tt.route, l, tt.buckets)
+		}
+	}
+	// 所有会话共用全局令牌桶
+	if s.Limiter("live").buckets[1] != s.Limiter("other").buckets[1] {
+		t.Errorf("global bucket is not shared")
+	}
+}
+
+func TestNewDisabled(t *testing.T) {
+	for _, args := range [][2]string{{"", ""}, {"0", "0"}, {"live=0", ""}} {
+		s, err := New(args[0], args[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if args[0] == "live=0" {
+			// 只配置了不限速的route，每个会话都没有令牌桶
+			if s.Limiter("live") != nil || s.Limiter("other") != nil {
+				t.Errorf("New(%q, %q): limiter is not nil", args[0], args[1])
+			}
+			continue
+		}
+		if s != nil {
+			t.Errorf("New(%q, %q) = %+v, want nil", args[0], args[1], s)
+		}
+	}
+	var s *Shaper
+	if s.Limiter("live") != nil {
+		t.Errorf("nil Shaper returned a limiter")
+	}
+	var l *Limiter
+	conn := &net.TCPConn{}
+	if l.Wrap(conn) != conn {
+		t.Errorf("nil Limiter wrapped the connection")
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	tests := [][2]string{
+		{"fast", ""},
+		{"live=-1M", ""},
+		{"", "10Mbps"},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt[0], tt[1]); err == nil {
+			t.Errorf("New(%q, %q) succeeded", tt[0], tt[1])
+		}
+	}
+}
+
+func TestBucketReserve(t *testing.T) {
+	b := NewBucket(8000000) // 1MB/s，容量20KB
+	start := b.last
+	if b.burst != 20000 {
+		t.Fatalf("burst = %v, want 20000", b.burst)
+	}
+	if wait := b.reserve(20000, start); wait != 0 {
+		t.Errorf("reserve full bucket: wait = %v, want 0", wait)
+	}
+	// 透支10KB需要等待10ms
+	if wait := b.reserve(10000, start); wait != 10*time.Millisecond {
+		t.Errorf("reserve overdraft: wait = %v, want 10ms", wait)
+	}
+	// 20ms后补充20KB，还清透支后剩余10KB
+	if wait := b.reserve(10000, start.Add(20*time.Millisecond)); wait != 0 {
+		t.Errorf("reserve after refill: wait = %v, want 0", wait)
+	}
+	// 时间回退时不补充
+	if wait := b.reserve(5000, start); wait != 5*time.Millisecond {
+		t.Errorf("reserve with earlier time: wait = %v, want 5ms", wait)
+	}
+	// 补充不超过容量
+	if wait := b.reserve(20000, start.Add(time.Hour)); wait != 0 {
+		t.Errorf("reserve after idle: wait = %v, want 0", wait)
+	}
+	if wait := b.reserve(1000, start.Add(time.Hour)); wait != time.Millisecond {
+		t.Errorf("reserve beyond burst: wait = %v, want 1ms", wait)
+	}
+}
+
+func TestBucketMinBurst(t *testing.T) {
+	if b := NewBucket(8000); b.burst != minBurst {
+		t.Errorf("burst = %v, want %d", b.burst, minBurst)
+	}
+}
+
+func TestLimitedConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			if _, err := server.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	// 8Mbps 即1MB/s，写入容量之外的100KB约需100ms
+	l := &Limiter{buckets: []*Bucket{NewBucket(8000000)}}
+	conn := l.Wrap(client)
+	start := time.Now()
+	n, err := conn.Write(make([]byte, 120000))
+	elapsed := time.Since(start)
+	if err != nil || n != 120000 {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if elapsed < 90*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Write took %v, want about 100ms", elapsed)
+	}
+}
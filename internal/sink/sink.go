@@ -0,0 +1,261 @@
+package sink
+
+// 本地RTMP接收服务器：接受推流并记录connect参数、推流密钥和编码，可以保存为FLV，用于离线测试代理的改写和插件
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	amf "github.com/zhangpeihao/goamf"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"rtmpproxy/internal"
+	"rtmpproxy/internal/flv"
+	"rtmpproxy/internal/rtmp"
+	"rtmpproxy/internal/stats"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 握手和等待publish的超时时间
+const publishTimeout = 10 * time.Second
+
+// Options 接收服务器的参数
+type Options struct {
+	Dir    string       // 保存FLV的目录，为空时不保存
+	Logger *slog.Logger // 为nil时使用默认logger
+}
+
+// Server 接收推流的服务器，每个连接独立处理
+type Server struct {
+	opts   Options
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closing  bool
+	wg       sync.WaitGroup
+	nextID   uint64
+}
+
+// New 创建接收服务器
+func New(opts Options) *Server {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Server{opts: opts, logger: logger, conns: make(map[net.Conn]struct{})}
+}
+
+// Serve 接受listener上的推流，Close 后返回nil
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return net.ErrClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	s.logger.Info("Sink waiting for publishers", "addr", listener.Addr().String(), "dir", s.opts.Dir)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closing := s.closing
+			s.mu.Unlock()
+			if closing {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			s.logger.Warn("Failed to accept connection", "err", err)
+			continue
+		}
+		id, ok := s.track(conn)
+		if !ok {
+			_ = conn.Close()
+			return nil
+		}
+		go func() {
+			defer s.untrack(conn)
+			s.handle(conn, id)
+		}()
+	}
+}
+
+// Close 停止接受连接，断开所有推流并等待FLV写入完成
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closing = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// track 记录连接并分配日志中的id，服务器正在关闭时返回false
+func (s *Server) track(conn net.Conn) (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return 0, false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	s.nextID++
+	return s.nextID, true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	_ = conn.Close()
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+// handle 接受一路推流，记录收到的内容直到客户端结束推流或断开
+func (s *Server) handle(c net.Conn, id uint64) {
+	logger := s.logger.With("id", id, "client", c.RemoteAddr().String())
+
+	conn := rtmp.NewConn(c)
+	_ = c.SetDeadline(time.Now().Add(publishTimeout))
+	if err := conn.ServerHandshake(); err != nil {
+		logger.Warn("Handshake failed", "err", err)
+		return
+	}
+	req, err := conn.ReadPublish(nil)
+	if err != nil {
+		logger.Warn("Failed to read publish", "err", err)
+		return
+	}
+	if err = conn.AcceptPublish(req); err != nil {
+		logger.Warn("Failed to accept publish", "err", err)
+		return
+	}
+	_ = c.SetDeadline(time.Time{})
+	logger.Info("Publish received",
+		"app", req.App,
+		"stream", req.StreamName,
+		"type", req.Type,
+		"args", req.Args.Encode(),
+		"tcUrl", req.TcUrl,
+		"flashVer", req.FlashVer,
+		"connect", fmt.Sprint(req.Connect),
+	)
+
+	var out *flv.Writer
+	if s.opts.Dir != "" {
+		f, err := s.create(req)
+		if err != nil {
+			logger.Error("Failed to create FLV file", "err", err)
+			return
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		if out, err = flv.NewWriter(f, true, true); err != nil {
+			logger.Error("Failed to write FLV file", "err", err)
+			return
+		}
+		logger.Info("Saving FLV", "path", f.Name())
+	}
+
+	tracker := stats.NewTracker(func(st internal.StreamStats) {
+		logger.Info("Stream info",
+			"video_codec", st.VideoCodec,
+			"width", st.Width,
+			"height", st.Height,
+			"video_profile", st.VideoProfile,
+			"video_level", st.VideoLevel,
+			"audio_codec", st.AudioCodec,
+			"audio_profile", st.AudioProfile,
+			"sample_rate", st.SampleRate,
+			"channels", st.Channels,
+		)
+	})
+	var (
+		messages    int
+		first, last uint32
+	)
+	for {
+		msg, err := conn.ReadMedia()
+		if err != nil {
+			st := tracker.Stats()
+			args := []any{
+				"messages", messages,
+				"video_frames", st.VideoFrames,
+				"keyframes", st.Keyframes,
+				"audio_frames", st.AudioFrames,
+				"duration", time.Duration(last-first) * time.Millisecond,
+			}
+			if !errors.Is(err, io.EOF) {
+				args = append(args, "err", err)
+			}
+			logger.Info("Publish ended", args...)
+			return
+		}
+		if messages == 0 {
+			first = msg.Timestamp
+		}
+		messages++
+		last = msg.Timestamp
+		tracker.Observe(msg)
+		if msg.TypeID == rtmp.TypeDataAMF0 || msg.TypeID == rtmp.TypeDataAMF3 {
+			logger.Info("Data message", "timestamp", msg.Timestamp, "values", dataValues(msg))
+		}
+		if out != nil {
+			if err := out.WriteTag(rtmp.FLVTag(msg)); err != nil {
+				logger.Error("Failed to write FLV file", "err", err)
+				out = nil
+			}
+		}
+	}
+}
+
+// create 在 Options.Dir 中创建 app_stream_时间.flv
+func (s *Server) create(req *rtmp.PublishRequest) (*os.File, error) {
+	name := fmt.Sprintf("%s_%s_%s.flv", safeName(req.App), safeName(req.StreamName), time.Now().Format("20060102-150405.000"))
+	return os.Create(filepath.Join(s.opts.Dir, name))
+}
+
+// safeName 将文件名中字母、数字和 .-_ 以外的字符替换为 _
+func safeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// dataValues 解码数据消息中的AMF0值，例如 [@setDataFrame onMetaData map[...]]，无法解码的部分省略
+func dataValues(msg *rtmp.Message) string {
+	payload := msg.Payload
+	if msg.TypeID == rtmp.TypeDataAMF3 && len(payload) > 0 && payload[0] == 0 {
+		payload = payload[1:]
+	}
+	r := bytes.NewReader(payload)
+	var values []interface{}
+	for r.Len() > 0 {
+		v, err := amf.ReadValue(r)
+		if err != nil {
+			break
+		}
+		values = append(values, v)
+	}
+	return fmt.Sprint(values)
+}
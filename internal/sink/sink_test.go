@@ -0,0 +1,269 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	amf "github.com/zhangpeihao/goamf"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"rtmpproxy/internal/flv"
+	"rtmpproxy/internal/rtmp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	// 1280x720 High@3.1
+	avcSequenceHeader = []byte{
+		0x17, 0x00, 0x00, 0x00, 0x00,
+		0x01, 0x64, 0x00, 0x1f, 0xff, 0xe1,
+		0x00, 0x19, 0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50, 0x05, 0xbb, 0x01, 0x10, 0x00, 0x00,
+		0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x03, 0xc0, 0xf1, 0x83, 0x19, 0x60,
+		0x01, 0x00, 0x06, 0x68, 0xeb, 0xe3, 0xcb, 0x22, 0xc0,
+	}
+	// AAC LC 44100Hz 双声道
+	aacSequenceHeader = []byte{0xaf, 0x00, 0x12, 0x10}
+)
+
+// logBuffer 并发写入的JSON日志
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records 返回msg为指定值的日志
+func (b *logBuffer) records(t *testing.T, msg string) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var list []map[string]any
+	scanner := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	for scanner.Scan() {
+		var record map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid log line %q: %v", scanner.Text(), err)
+		}
+		if record["msg"] == msg {
+			list = append(list, record)
+		}
+	}
+	return list
+}
+
+// wait 等待n条msg日志
+func (b *logBuffer) wait(t *testing.T, msg string, n int) []map[string]any {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		list := b.records(t, msg)
+		if len(list) >= n {
+			return list
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d %q logs, want %d", len(list), msg, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func startSink(t *testing.T, dir string) (*logBuffer, string) {
+	t.Helper()
+	logs := &logBuffer{}
+	s := New(Options{Dir: dir, Logger: slog.New(slog.NewJSONHandler(logs, nil))})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = s.Serve(listener)
+	}()
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	return logs, listener.Addr().String()
+}
+
+// publish 作为客户端连接sink并开始推流
+func publish(t *testing.T, addr string, app string, streamName string) (*rtmp.Conn, uint32) {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	conn := rtmp.NewConn(c)
+	if err = conn.ClientHandshake(); err != nil {
+		t.Fatal(err)
+	}
+	err = conn.Connect(amf.Object{
+		"app":      app,
+		"tcUrl":    "rtmp://" + addr + "/" + app,
+		"flashVer": "FMLE/3.0 (compatible; FMSc/1.0)",
+		"type":     "nonprivate",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamID, err := conn.Publish(streamName, "live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, streamID
+}
+
+func TestSink(t *testing.T) {
+	dir := t.TempDir()
+	logs, addr := startSink(t, dir)
+	conn, streamID := publish(t, addr, "live", "key?token=abc")
+
+	var metadata bytes.Buffer
+	_, _ = amf.WriteString(&metadata, "@setDataFrame")
+	_, _ = amf.WriteString(&metadata, "onMetaData")
+	_, _ = amf.WriteObject(&metadata, amf.Object{"width": 1280.0, "height": 720.0})
+	messages := []*rtmp.Message{
+		{TypeID: rtmp.TypeDataAMF0, Payload: metadata.Bytes()},
+		{TypeID: rtmp.TypeVideo, Payload: avcSequenceHeader},
+		{TypeID: rtmp.TypeAudio, Payload: aacSequenceHeader},
+		{TypeID: rtmp.TypeVideo, Payload: []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0x01}},
+		{TypeID: rtmp.TypeAudio, Timestamp: 23, Payload: []byte{0xaf, 0x01, 0x02}},
+		{TypeID: rtmp.TypeVideo, Timestamp: 33, Payload: []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0x03}},
+	}
+	for _, msg := range messages {
+		msg.StreamID = streamID
+		if err := conn.WriteMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := conn.Unpublish("key", streamID); err != nil {
+		t.Fatal(err)
+	}
+
+	received := logs.wait(t, "Publish received", 1)[0]
+	want := map[string]any{
+		"app":      "live",
+		"stream":   "key",
+		"type":     "live",
+		"args":     "token=abc",
+		"tcUrl":    "rtmp://" + addr + "/live",
+		"flashVer": "FMLE/3.0 (compatible; FMSc/1.0)",
+		"id":       1.0,
+	}
+	for k, v := range want {
+		if received[k] != v {
+			t.Errorf("Publish received %s = %v, want %v", k, received[k], v)
+		}
+	}
+	if connect, _ := received["connect"].(string); !strings.Contains(connect, "type:nonprivate") {
+		t.Errorf("Publish received connect = %q", connect)
+	}
+	ended := logs.wait(t, "Publish ended", 1)[0]
+	if ended["messages"] != float64(len(messages)) || ended["keyframes"] != 1.0 || ended["err"] != nil {
+		t.Errorf("Publish ended = %v", ended)
+	}
+	// 每次编码信息变化时记录，最后一条包含音视频
+	infos := logs.wait(t, "Stream info", 1)
+	if info := infos[len(infos)-1]; info["video_codec"] != "H264" || info["width"] != 1280.0 || info["height"] != 720.0 || info["audio_codec"] != "AAC" {
+		t.Errorf("Stream info = %v", infos)
+	}
+	if data := logs.wait(t, "Data message", 1)[0]; !strings.Contains(data["values"].(string), "onMetaData") {
+		t.Errorf("Data message values = %v", data["values"])
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "live_key_*.flv"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("FLV files = %v, %v", files, err)
+	}
+	tags := readFLV(t, files[0])
+	if len(tags) != len(messages) {
+		t.Fatalf("FLV has %d tags, want %d", len(tags), len(messages))
+	}
+	// metadata去掉@setDataFrame前缀
+	if tags[0].Type != flv.TagScript || !bytes.HasPrefix(tags[0].Data, []byte("\x02\x00\x0aonMetaData")) {
+		t.Errorf("first tag = type %d % x", tags[0].Type, tags[0].Data[:3])
+	}
+	for i, msg := range messages[1:] {
+		tag := tags[i+1]
+		if tag.Type != uint8(msg.TypeID) || tag.Timestamp != msg.Timestamp || !bytes.Equal(tag.Data, msg.Payload) {
+			t.Errorf("tag %d = type %d at %d, want type %d at %d", i+1, tag.Type, tag.Timestamp, msg.TypeID, msg.Timestamp)
+		}
+	}
+}
+
+func readFLV(t *testing.T, path string) []*flv.Tag {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	r, err := flv.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tags []*flv.Tag
+	for {
+		tag, err := r.ReadTag()
+		if err == io.EOF {
+			return tags
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		tags = append(tags, tag)
+	}
+}
+
+// TestSinkConnectionIDs 同时接受的连接在日志中使用各自的id
+func TestSinkConnectionIDs(t *testing.T) {
+	logs, addr := startSink(t, "")
+	const n = 8
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// 不握手直接关闭，每个连接记录一次握手失败
+			_ = c.Close()
+		}()
+	}
+	wg.Wait()
+
+	var ids []float64
+	for _, record := range logs.wait(t, "Handshake failed", n) {
+		ids = append(ids, record["id"].(float64))
+	}
+	sort.Float64s(ids)
+	for i, id := range ids {
+		if id != float64(i+1) {
+			t.Fatalf("connection ids = %v, want 1..%d", ids, n)
+		}
+	}
+}
+
+func TestSafeName(t *testing.T) {
+	if got := safeName("live/key?a=b&c"); got != "live_key_a_b_c" {
+		t.Errorf("safeName = %s", got)
+	}
+}
@@ -0,0 +1,129 @@
+package slate
+
+// 客户端断开后循环发送的垫片视频
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"rtmpproxy/internal/flv"
+	"rtmpproxy/internal/rtmp"
+	"time"
+)
+
+// 只有一帧时按该间隔重复发送(毫秒)
+const defaultFrameInterval = 40
+
+// 垫片文件的最大长度
+const maxSize = 64 << 20
+
+// Slate 加载到内存中的FLV文件
+type Slate struct {
+	tags     []*flv.Tag
+	headers  int    // 开头的metadata和sequence header的数量，循环时不再发送
+	duration uint32 // 一次循环的时长(毫秒)
+}
+
+// Load 读取FLV文件，文件需包含视频关键帧
+func Load(path string) (*Slate, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	reader, err := flv.NewReader(io.LimitReader(f, maxSize))
+	if err != nil {
+		return nil, fmt.Errorf("slate %s: %w", path, err)
+	}
+
+	s := &Slate{}
+	var (
+		first, last  uint32
+		videoFrames  int
+		hasKeyframe  bool
+		headerPrefix = true
+	)
+	for {
+		tag, err := reader.ReadTag()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("slate %s: %w", path, err)
+		}
+		if tag.Type != flv.TagAudio && tag.Type != flv.TagVideo && tag.Type != flv.TagScript {
+			continue
+		}
+		header := tag.Type == flv.TagScript
+		if tag.Type == flv.TagVideo {
+			video, ok := flv.ParseVideoTag(tag.Data)
+			header = ok && video.IsSequenceHeader()
+			if ok && video.IsFrame() {
+				if videoFrames == 0 {
+					first = tag.Timestamp
+				}
+				last = tag.Timestamp
+				videoFrames++
+				hasKeyframe = hasKeyframe || video.IsKeyframe()
+			}
+		}
+		if tag.Type == flv.TagAudio {
+			audio, ok := flv.ParseAudioTag(tag.Data)
+			header = ok && audio.IsSequenceHeader()
+		}
+		if headerPrefix && header {
+			s.headers++
+		} else {
+			headerPrefix = false
+		}
+		s.tags = append(s.tags, tag)
+	}
+	if !hasKeyframe {
+		return nil, fmt.Errorf("slate %s: no video keyframe", path)
+	}
+	// 最后一帧的时长按平均帧间隔计算
+	interval := uint32(defaultFrameInterval)
+	if videoFrames > 1 && last > first {
+		interval = (last - first) / uint32(videoFrames-1)
+	}
+	s.duration = last + interval
+	return s, nil
+}
+
+// Play 按实际时间循环写入垫片，直到 stop 关闭或写入失败
+func (s *Slate) Play(upstream *rtmp.Upstream, src *rtmp.Source, stop <-chan struct{}) error {
+	start := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+	var base uint32 // 之前循环的总时长
+	for loop := 0; ; loop++ {
+		for i, tag := range s.tags {
+			if loop > 0 && i < s.headers {
+				continue
+			}
+			timestamp := base + tag.Timestamp
+			if wait := time.Duration(timestamp)*time.Millisecond - time.Since(start); wait > 0 {
+				timer.Reset(wait)
+				select {
+				case <-timer.C:
+				case <-stop:
+					return nil
+				}
+			}
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
+			msg := &rtmp.Message{TypeID: uint32(tag.Type), Timestamp: timestamp, Payload: tag.Data}
+			if err := upstream.Write(src, msg); err != nil {
+				return err
+			}
+		}
+		base += s.duration
+	}
+}
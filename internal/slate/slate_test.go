@@ -0,0 +1,94 @@
+package slate
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"rtmpproxy/internal/flv"
+	"strings"
+	"testing"
+)
+
+// writeFile 将tags写入临时的FLV文件
+func writeFile(t *testing.T, tags ...flv.Tag) string {
+	t.Helper()
+	var b bytes.Buffer
+	b.Write([]byte{'F', 'L', 'V', 1, 0x05, 0, 0, 0, 9, 0, 0, 0, 0})
+	for _, tag := range tags {
+		size := len(tag.Data)
+		b.Write([]byte{tag.Type, byte(size >> 16), byte(size >> 8), byte(size), byte(tag.Timestamp >> 16), byte(tag.Timestamp >> 8), byte(tag.Timestamp), 0, 0, 0, 0})
+		b.Write(tag.Data)
+		b.Write([]byte{0, 0, 0, byte(size + 11)})
+	}
+	path := filepath.Join(t.TempDir(), "slate.flv")
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+var (
+	metadata       = flv.Tag{Type: flv.TagScript, Data: []byte{2, 0, 0}}
+	videoHeader    = flv.Tag{Type: flv.TagVideo, Data: []byte{0x17, 0, 0, 0, 0}}
+	audioHeader    = flv.Tag{Type: flv.TagAudio, Data: []byte{0xaf, 0, 0x12, 0x10}}
+	keyframe       = flv.Tag{Type: flv.TagVideo, Data: []byte{0x17, 1, 0, 0, 0}}
+	interFrame     = flv.Tag{Type: flv.TagVideo, Data: []byte{0x27, 1, 0, 0, 0}}
+	audioFrame     = flv.Tag{Type: flv.TagAudio, Data: []byte{0xaf, 1, 0x21}}
+	unsupportedTag = flv.Tag{Type: 15, Data: []byte{0}}
+)
+
+func at(tag flv.Tag, timestamp uint32) flv.Tag {
+	tag.Timestamp = timestamp
+	return tag
+}
+
+func TestLoad(t *testing.T) {
+	path := writeFile(t,
+		metadata, videoHeader, audioHeader,
+		keyframe, at(audioFrame, 20), unsupportedTag, at(interFrame, 40), at(interFrame, 80), at(interFrame, 120),
+	)
+	s, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if s.headers != 3 {
+		t.Errorf("headers = %d, want 3", s.headers)
+	}
+	if len(s.tags) != 8 {
+		t.Errorf("tags = %d, want 8", len(s.tags))
+	}
+	// 最后一帧按平均帧间隔计算时长
+	if s.duration != 160 {
+		t.Errorf("duration = %d, want 160", s.duration)
+	}
+}
+
+func TestLoadSingleFrame(t *testing.T) {
+	s, err := Load(writeFile(t, videoHeader, keyframe))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if s.headers != 1 || s.duration != defaultFrameInterval {
+		t.Errorf("headers/duration = %d/%d, want 1/%d", s.headers, s.duration, defaultFrameInterval)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"missing", filepath.Join(t.TempDir(), "missing.flv"), "no such file"},
+		{"no keyframe", writeFile(t, videoHeader, interFrame, audioFrame), "no video keyframe"},
+		{"audio only", writeFile(t, audioHeader, audioFrame), "no video keyframe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load = %v, want error containing %q", err, tt.want)
+			}
+		})
+	}
+}
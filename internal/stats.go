@@ -0,0 +1,37 @@
+package internal
+
+// StreamStats 会话中客户端推流的统计信息，码率和帧率按最近的统计窗口计算
+type StreamStats struct {
+	VideoCodec       string  `json:"video_codec,omitempty"`
+	AudioCodec       string  `json:"audio_codec,omitempty"`
+	Width            int     `json:"width,omitempty"` // 从sequence header解析的分辨率
+	Height           int     `json:"height,omitempty"`
+	VideoProfile     string  `json:"video_profile,omitempty"` // 例如 High、Main 10
+	VideoLevel       string  `json:"video_level,omitempty"`   // 例如 4.1
+	SampleRate       int     `json:"sample_rate,omitempty"`   // Hz
+	Channels         int     `json:"channels,omitempty"`
+	AudioProfile     string  `json:"audio_profile,omitempty"` // 例如 LC、HE-AAC
+	VideoBitrate     uint64  `json:"video_bitrate"`           // bit/s
+	AudioBitrate     uint64  `json:"audio_bitrate"`           // bit/s
+	FPS              float64 `json:"fps"`                     // 帧率
+	KeyframeInterval float64 `json:"keyframe_interval"`       // 最近两个关键帧的时间戳间隔(秒)
+	VideoFrames      uint64  `json:"video_frames"`            // 累计视频帧数
+	Keyframes        uint64  `json:"keyframes"`               // 累计关键帧数
+	AudioFrames      uint64  `json:"audio_frames"`            // 累计音频帧数
+	DroppedFrames    uint64  `json:"dropped_frames"`          // 远程服务器拥塞时丢弃的视频帧数
+}
+
+// 推流健康问题的类型
+const (
+	HealthStall              = "stall"               // 一段时间内没有收到音视频
+	HealthAudioOnly          = "audio_only"          // 推流中途只剩下音频
+	HealthTimestampBackwards = "timestamp_backwards" // 时间戳回退
+	HealthBitrateCollapse    = "bitrate_collapse"    // 视频码率骤降
+)
+
+// HealthEvent 推流健康状态的变化，Recovered 为true时表示问题已恢复，时间戳回退没有恢复事件
+type HealthEvent struct {
+	Kind      string `json:"kind"`
+	Detail    string `json:"detail,omitempty"`
+	Recovered bool   `json:"recovered"`
+}
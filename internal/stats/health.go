@@ -0,0 +1,168 @@
+package stats
+
+// 根据客户端推流检测卡顿、只剩音频、时间戳回退和码率骤降
+
+import (
+	"fmt"
+	"rtmpproxy/internal"
+	"rtmpproxy/internal/flv"
+	"rtmpproxy/internal/rtmp"
+	"sync"
+	"time"
+)
+
+// 计算码率骤降的窗口长度，按实际时间计算
+const healthWindow = 5 * time.Second
+
+// Watchdog 检测单个会话的推流问题，Observe 和 Check 可以在不同的goroutine中调用
+type Watchdog struct {
+	stallTimeout time.Duration
+	collapse     float64 // 码率低于基准的比例时认为骤降，0 为不检测
+
+	mu        sync.Mutex
+	start     time.Time
+	lastVideo time.Time
+	lastAudio time.Time
+
+	backwards     int    // 上次 Check 之后时间戳回退的次数
+	backwardsFrom uint32 // 最近一次回退前后的时间戳
+	backwardsTo   uint32
+
+	windowStart time.Time
+	windowBytes uint64
+	baseline    float64 // 视频码率的基准，按窗口的指数平均计算
+
+	active map[string]bool // 尚未恢复的问题
+}
+
+// NewWatchdog 创建Watchdog，stallTimeout 为判断卡顿的时长，collapse 为判断码率骤降的比例
+func NewWatchdog(stallTimeout time.Duration, collapse float64, now time.Time) *Watchdog {
+	return &Watchdog{
+		stallTimeout: stallTimeout,
+		collapse:     collapse,
+		start:        now,
+		windowStart:  now,
+		active:       make(map[string]bool),
+	}
+}
+
+// Observe 记录一条客户端消息，可直接作为 RTMPConnection.Observe 的参数，只统计音视频帧
+func (w *Watchdog) Observe(msg *rtmp.Message) {
+	var (
+		frame bool
+		video bool
+	)
+	switch msg.TypeID {
+	case rtmp.TypeVideo:
+		tag, ok := flv.ParseVideoTag(msg.Payload)
+		frame, video = ok && tag.IsFrame(), true
+	case rtmp.TypeAudio:
+		tag, ok := flv.ParseAudioTag(msg.Payload)
+		frame = ok && tag.IsFrame()
+	}
+	if !frame {
+		return
+	}
+	now := time.Now()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if video {
+		w.lastVideo = now
+		w.windowBytes += uint64(len(msg.Payload))
+	} else {
+		w.lastAudio = now
+	}
+}
+
+// Backwards 记录一次时间戳回退，可直接作为 Upstream.ObserveBackwards 的参数。Observe 收到的时间戳已经修复为单调递增，
+// 回退需要在修复之前检测
+func (w *Watchdog) Backwards(from, to uint32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.backwards++
+	w.backwardsFrom, w.backwardsTo = from, to
+}
+
+// Check 检查推流状态，返回状态发生的变化，应定期调用
+func (w *Watchdog) Check(now time.Time) []internal.HealthEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var events []internal.HealthEvent
+	if w.backwards > 0 {
+		events = append(events, internal.HealthEvent{
+			Kind:   internal.HealthTimestampBackwards,
+			Detail: fmt.Sprintf("timestamp went backwards %d times, last from %d to %d", w.backwards, w.backwardsFrom, w.backwardsTo),
+		})
+		w.backwards = 0
+	}
+
+	lastMedia := w.start
+	if w.lastVideo.After(lastMedia) {
+		lastMedia = w.lastVideo
+	}
+	if w.lastAudio.After(lastMedia) {
+		lastMedia = w.lastAudio
+	}
+	idle := now.Sub(lastMedia)
+	stall := idle > w.stallTimeout
+	events = w.set(events, internal.HealthStall, stall,
+		fmt.Sprintf("no audio or video for %s", idle.Round(time.Second)))
+
+	// 推流开始时就没有视频的不算只剩音频
+	videoIdle := now.Sub(w.lastVideo)
+	audioOnly := !stall && !w.lastVideo.IsZero() && videoIdle > w.stallTimeout
+	events = w.set(events, internal.HealthAudioOnly, audioOnly,
+		fmt.Sprintf("no video for %s while audio continues", videoIdle.Round(time.Second)))
+
+	if stall || audioOnly {
+		// 已经报告了更严重的问题，恢复后重新开始统计码率
+		w.windowStart, w.windowBytes = now, 0
+		return events
+	}
+	elapsed := now.Sub(w.windowStart)
+	if w.collapse <= 0 || elapsed < healthWindow {
+		return events
+	}
+	bitrate := float64(w.windowBytes) * 8 / elapsed.Seconds()
+	w.windowStart, w.windowBytes = now, 0
+	collapsed := w.baseline > 0 && bitrate < w.baseline*w.collapse
+	events = w.set(events, internal.HealthBitrateCollapse, collapsed,
+		fmt.Sprintf("video bitrate %.0f fell below %.0f%% of %.0f", bitrate, w.collapse*100, w.baseline))
+	if !collapsed {
+		// 骤降期间不更新基准，否则低码率会逐渐成为新的基准
+		if w.baseline == 0 {
+			w.baseline = bitrate
+		} else {
+			w.baseline = w.baseline*0.8 + bitrate*0.2
+		}
+	}
+	return events
+}
+
+// set 更新问题的状态，状态变化时添加事件
+func (w *Watchdog) set(events []internal.HealthEvent, kind string, unhealthy bool, detail string) []internal.HealthEvent {
+	if w.active[kind] == unhealthy {
+		return events
+	}
+	w.active[kind] = unhealthy
+	event := internal.HealthEvent{Kind: kind, Recovered: !unhealthy}
+	if unhealthy {
+		event.Detail = detail
+	}
+	return append(events, event)
+}
+
+// Active 返回尚未恢复的问题
+func (w *Watchdog) Active() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var list []string
+	for _, kind := range []string{internal.HealthStall, internal.HealthAudioOnly, internal.HealthBitrateCollapse} {
+		if w.active[kind] {
+			list = append(list, kind)
+		}
+	}
+	return list
+}
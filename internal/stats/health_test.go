@@ -0,0 +1,124 @@
+package stats
+
+import (
+	"reflect"
+	"rtmpproxy/internal"
+	"testing"
+	"time"
+)
+
+func kinds(events []internal.HealthEvent) []string {
+	var list []string
+	for _, event := range events {
+		kind := event.Kind
+		if event.Recovered {
+			kind += " recovered"
+		}
+		list = append(list, kind)
+	}
+	return list
+}
+
+func expectEvents(t *testing.T, events []internal.HealthEvent, want ...string) {
+	t.Helper()
+	if got := kinds(events); !reflect.DeepEqual(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+}
+
+func TestWatchdogStall(t *testing.T) {
+	w := NewWatchdog(3*time.Second, 0, time.Now())
+	w.Observe(video(0, avcKeyframe))
+	expectEvents(t, w.Check(time.Now()))
+
+	expectEvents(t, w.Check(time.Now().Add(4*time.Second)), internal.HealthStall)
+	if active := w.Active(); !reflect.DeepEqual(active, []string{internal.HealthStall}) {
+		t.Errorf("active = %v, want [%s]", active, internal.HealthStall)
+	}
+	// 问题持续时不重复报告
+	expectEvents(t, w.Check(time.Now().Add(5*time.Second)))
+
+	w.Observe(video(40, avcInterFrame))
+	expectEvents(t, w.Check(time.Now()), internal.HealthStall+" recovered")
+	if active := w.Active(); len(active) != 0 {
+		t.Errorf("active = %v, want none", active)
+	}
+}
+
+func TestWatchdogIgnoresSequenceHeaders(t *testing.T) {
+	start := time.Now()
+	w := NewWatchdog(3*time.Second, 0, start)
+	w.Observe(video(0, avcSequenceHeader))
+	w.Observe(audio(0, aacSequenceHeader))
+	expectEvents(t, w.Check(start.Add(4*time.Second)), internal.HealthStall)
+}
+
+func TestWatchdogAudioOnly(t *testing.T) {
+	w := NewWatchdog(50*time.Millisecond, 0, time.Now())
+	w.Observe(video(0, avcKeyframe))
+	time.Sleep(100 * time.Millisecond)
+	w.Observe(audio(100, aacRaw))
+	expectEvents(t, w.Check(time.Now()), internal.HealthAudioOnly)
+
+	w.Observe(video(120, avcInterFrame))
+	expectEvents(t, w.Check(time.Now()), internal.HealthAudioOnly+" recovered")
+}
+
+func TestWatchdogAudioOnlyFromStart(t *testing.T) {
+	w := NewWatchdog(50*time.Millisecond, 0, time.Now())
+	time.Sleep(100 * time.Millisecond)
+	w.Observe(audio(100, aacRaw))
+	expectEvents(t, w.Check(time.Now()))
+}
+
+func TestWatchdogBackwards(t *testing.T) {
+	now := time.Now()
+	w := NewWatchdog(time.Minute, 0, now)
+	w.Observe(video(100, avcInterFrame))
+	// Observe 收到的时间戳已经修复，不检测回退
+	w.Observe(video(50, avcInterFrame))
+	expectEvents(t, w.Check(now))
+
+	w.Backwards(5000, 100)
+	w.Backwards(6000, 200)
+	events := w.Check(now)
+	expectEvents(t, events, internal.HealthTimestampBackwards)
+	if want := "timestamp went backwards 2 times, last from 6000 to 200"; events[0].Detail != want {
+		t.Errorf("detail = %q, want %q", events[0].Detail, want)
+	}
+	// 回退是一次性的事件，报告后清除
+	expectEvents(t, w.Check(now))
+	if active := w.Active(); len(active) != 0 {
+		t.Errorf("active = %v, want none", active)
+	}
+}
+
+func TestWatchdogBitrateCollapse(t *testing.T) {
+	start := time.Now()
+	w := NewWatchdog(time.Hour, 0.5, start)
+	frame := func(size int) {
+		payload := make([]byte, size)
+		copy(payload, avcInterFrame)
+		w.Observe(video(0, payload))
+	}
+
+	frame(1000)
+	expectEvents(t, w.Check(start.Add(time.Second)))
+	expectEvents(t, w.Check(start.Add(healthWindow)))
+
+	frame(100)
+	expectEvents(t, w.Check(start.Add(2*healthWindow)), internal.HealthBitrateCollapse)
+
+	// 骤降期间基准不变，恢复到原来的码率后报告恢复
+	frame(1000)
+	expectEvents(t, w.Check(start.Add(3*healthWindow)), internal.HealthBitrateCollapse+" recovered")
+}
+
+func TestWatchdogBitrateCollapseDisabled(t *testing.T) {
+	start := time.Now()
+	w := NewWatchdog(time.Hour, 0, start)
+	w.Observe(video(0, append(avcInterFrame[:5:5], make([]byte, 1000)...)))
+	expectEvents(t, w.Check(start.Add(healthWindow)))
+	w.Observe(video(0, avcInterFrame))
+	expectEvents(t, w.Check(start.Add(2*healthWindow)))
+}
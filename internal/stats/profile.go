@@ -0,0 +1,155 @@
+package stats
+
+// 平台推流参数限制的校验，超出限制时只记录警告
+
+import (
+	"fmt"
+	"rtmpproxy/internal"
+	"rtmpproxy/utils"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profile 平台允许的推流参数，0 或空为不限制
+type Profile struct {
+	Name                string
+	MaxWidth            int
+	MaxHeight           int
+	MaxFPS              float64
+	MaxVideoBitrate     uint64  // bit/s
+	MaxKeyframeInterval float64 // 秒
+	VideoCodecs         []string
+	AudioCodecs         []string
+	SampleRates         []int
+}
+
+// 内置的平台限制
+var profiles = map[string]Profile{
+	"bilibili": {
+		Name:        "bilibili",
+		MaxWidth:    1920,
+		MaxHeight:   1080,
+		MaxFPS:      60,
+		VideoCodecs: []string{"H264", "HEVC"},
+		AudioCodecs: []string{"AAC"},
+	},
+	"twitch": {
+		Name:                "twitch",
+		MaxWidth:            1920,
+		MaxHeight:           1080,
+		MaxFPS:              60,
+		MaxVideoBitrate:     6000000,
+		MaxKeyframeInterval: 2,
+		VideoCodecs:         []string{"H264"},
+		AudioCodecs:         []string{"AAC"},
+		SampleRates:         []int{44100, 48000},
+	},
+	"youtube": {
+		Name:                "youtube",
+		MaxWidth:            3840,
+		MaxHeight:           2160,
+		MaxFPS:              60,
+		MaxKeyframeInterval: 4,
+		VideoCodecs:         []string{"H264", "HEVC", "AV1"},
+		AudioCodecs:         []string{"AAC", "MP3"},
+	},
+}
+
+// ParseProfile 解析内置平台名称，或 "width=1920,height=1080,fps=60,bitrate=6000k,gop=2,video=H264|HEVC,audio=AAC,samplerate=44100|48000"，
+// 以内置平台名称开头时在其基础上修改，例如 "bilibili,bitrate=8000k"
+func ParseProfile(s string) (*Profile, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	p := &Profile{Name: "custom"}
+	for i, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			base, found := profiles[strings.ToLower(item)]
+			if i != 0 || !found {
+				return nil, fmt.Errorf("unknown profile %q", item)
+			}
+			*p = base
+			continue
+		}
+		var err error
+		switch strings.ToLower(key) {
+		case "width":
+			p.MaxWidth, err = strconv.Atoi(value)
+		case "height":
+			p.MaxHeight, err = strconv.Atoi(value)
+		case "fps":
+			p.MaxFPS, err = strconv.ParseFloat(value, 64)
+		case "bitrate":
+			p.MaxVideoBitrate, err = utils.ParseBitrate(value)
+		case "gop":
+			var d time.Duration
+			if d, err = time.ParseDuration(value); err != nil {
+				// 不带单位时为秒
+				var sec float64
+				sec, err = strconv.ParseFloat(value, 64)
+				d = time.Duration(sec * float64(time.Second))
+			}
+			p.MaxKeyframeInterval = d.Seconds()
+		case "video":
+			p.VideoCodecs = strings.Split(strings.ToUpper(value), "|")
+		case "audio":
+			p.AudioCodecs = strings.Split(strings.ToUpper(value), "|")
+		case "samplerate":
+			p.SampleRates = nil
+			for _, v := range strings.Split(value, "|") {
+				var rate int
+				if rate, err = strconv.Atoi(v); err != nil {
+					break
+				}
+				p.SampleRates = append(p.SampleRates, rate)
+			}
+		default:
+			return nil, fmt.Errorf("unknown profile option %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid profile option %q: %v", item, err)
+		}
+	}
+	return p, nil
+}
+
+// Violation 超出平台限制的参数
+type Violation struct {
+	Rule    string // resolution/fps/bitrate/gop/video_codec/audio_codec/sample_rate
+	Message string
+}
+
+// Check 检查统计信息是否超出限制，尚未统计到的参数不检查
+func (p *Profile) Check(st internal.StreamStats) []Violation {
+	var list []Violation
+	add := func(rule string, format string, args ...any) {
+		list = append(list, Violation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+	if st.Width > 0 && ((p.MaxWidth > 0 && st.Width > p.MaxWidth) || (p.MaxHeight > 0 && st.Height > p.MaxHeight)) {
+		add("resolution", "resolution %dx%d exceeds %dx%d", st.Width, st.Height, p.MaxWidth, p.MaxHeight)
+	}
+	if p.MaxFPS > 0 && st.FPS > p.MaxFPS*1.05 {
+		// 按时间戳统计的帧率有少量误差
+		add("fps", "fps %.2f exceeds %.0f", st.FPS, p.MaxFPS)
+	}
+	if p.MaxVideoBitrate > 0 && st.VideoBitrate > p.MaxVideoBitrate {
+		add("bitrate", "video bitrate %d exceeds %d", st.VideoBitrate, p.MaxVideoBitrate)
+	}
+	if p.MaxKeyframeInterval > 0 && st.KeyframeInterval > p.MaxKeyframeInterval+0.1 {
+		add("gop", "keyframe interval %.2fs exceeds %.2fs", st.KeyframeInterval, p.MaxKeyframeInterval)
+	}
+	if st.VideoCodec != "" && len(p.VideoCodecs) > 0 && !slices.Contains(p.VideoCodecs, st.VideoCodec) {
+		add("video_codec", "video codec %s is not one of %s", st.VideoCodec, strings.Join(p.VideoCodecs, "/"))
+	}
+	if st.AudioCodec != "" && len(p.AudioCodecs) > 0 && !slices.Contains(p.AudioCodecs, st.AudioCodec) {
+		add("audio_codec", "audio codec %s is not one of %s", st.AudioCodec, strings.Join(p.AudioCodecs, "/"))
+	}
+	if st.SampleRate > 0 && len(p.SampleRates) > 0 && !slices.Contains(p.SampleRates, st.SampleRate) {
+		add("sample_rate", "sample rate %d is not one of %v", st.SampleRate, p.SampleRates)
+	}
+	return list
+}
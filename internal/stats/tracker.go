@@ -0,0 +1,153 @@
+package stats
+
+// 根据转发的音视频消息统计码率、帧率和关键帧间隔
+
+import (
+	"math"
+	"rtmpproxy/internal"
+	"rtmpproxy/internal/flv"
+	"rtmpproxy/internal/rtmp"
+	"sync"
+)
+
+// 统计窗口的长度(毫秒)，按消息时间戳计算
+const windowMillis = 5000
+
+// Tracker 统计单个会话的推流数据，Observe 和 Stats 可以在不同的goroutine中调用
+type Tracker struct {
+	mu       sync.Mutex
+	stats    internal.StreamStats
+	onHeader func(stats internal.StreamStats)
+
+	windowStart  uint32 // 当前窗口第一条消息的时间戳
+	windowLast   uint32
+	windowActive bool
+	videoBytes   uint64
+	audioBytes   uint64
+	videoFrames  uint64
+	lastKeyframe uint32
+	hasKeyframe  bool
+}
+
+// NewTracker 创建Tracker，onHeader 不为nil时在解析到新的sequence header后调用
+func NewTracker(onHeader func(stats internal.StreamStats)) *Tracker {
+	return &Tracker{onHeader: onHeader}
+}
+
+// Observe 统计一条客户端消息，可直接作为 RTMPConnection.Observe 的参数
+func (t *Tracker) Observe(msg *rtmp.Message) {
+	if msg.TypeID != rtmp.TypeVideo && msg.TypeID != rtmp.TypeAudio {
+		return
+	}
+	header, changed := t.observe(msg)
+	if changed && t.onHeader != nil {
+		t.onHeader(header)
+	}
+}
+
+// observe 更新统计，解析到新的sequence header时返回true
+func (t *Tracker) observe(msg *rtmp.Message) (internal.StreamStats, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch msg.TypeID {
+	case rtmp.TypeVideo:
+		tag, ok := flv.ParseVideoTag(msg.Payload)
+		if !ok {
+			return t.stats, false
+		}
+		t.stats.VideoCodec = tag.Codec()
+		if tag.IsSequenceHeader() {
+			return t.stats, t.videoHeader(msg.Payload)
+		}
+		if !tag.IsFrame() {
+			return t.stats, false
+		}
+		t.roll(msg.Timestamp)
+		t.stats.VideoFrames++
+		t.videoFrames++
+		t.videoBytes += uint64(len(msg.Payload))
+		if tag.IsKeyframe() {
+			t.stats.Keyframes++
+			if t.hasKeyframe && msg.Timestamp > t.lastKeyframe {
+				t.stats.KeyframeInterval = float64(msg.Timestamp-t.lastKeyframe) / 1000
+			}
+			t.lastKeyframe, t.hasKeyframe = msg.Timestamp, true
+		}
+	case rtmp.TypeAudio:
+		tag, ok := flv.ParseAudioTag(msg.Payload)
+		if !ok {
+			return t.stats, false
+		}
+		t.stats.AudioCodec = tag.Codec()
+		// 没有sequence header的编码从第一帧的tag header中获取参数
+		if tag.IsSequenceHeader() || (t.stats.SampleRate == 0 && tag.SoundFormat != flv.SoundAAC) {
+			if t.audioHeader(msg.Payload) {
+				return t.stats, true
+			}
+		}
+		if !tag.IsFrame() {
+			return t.stats, false
+		}
+		t.roll(msg.Timestamp)
+		t.stats.AudioFrames++
+		t.audioBytes += uint64(len(msg.Payload))
+	}
+	return t.stats, false
+}
+
+// videoHeader 解析视频sequence header，参数变化时返回true
+func (t *Tracker) videoHeader(payload []byte) bool {
+	info, err := flv.ParseVideoSequenceHeader(payload)
+	if err != nil {
+		return false
+	}
+	changed := info.Width != t.stats.Width || info.Height != t.stats.Height ||
+		info.Profile != t.stats.VideoProfile || info.Level != t.stats.VideoLevel
+	t.stats.Width, t.stats.Height = info.Width, info.Height
+	t.stats.VideoProfile, t.stats.VideoLevel = info.Profile, info.Level
+	return changed
+}
+
+// audioHeader 解析音频参数，参数变化时返回true
+func (t *Tracker) audioHeader(payload []byte) bool {
+	info, err := flv.ParseAudioSequenceHeader(payload)
+	if err != nil {
+		return false
+	}
+	changed := info.SampleRate != t.stats.SampleRate || info.Channels != t.stats.Channels ||
+		info.Profile != t.stats.AudioProfile
+	t.stats.SampleRate, t.stats.Channels, t.stats.AudioProfile = info.SampleRate, info.Channels, info.Profile
+	return changed
+}
+
+// roll 时间戳超过窗口长度时计算码率和帧率并开始新的窗口，时间戳回退时丢弃当前窗口
+func (t *Tracker) roll(timestamp uint32) {
+	if !t.windowActive || timestamp < t.windowStart {
+		t.reset(timestamp)
+		return
+	}
+	if timestamp > t.windowLast {
+		t.windowLast = timestamp
+	}
+	span := t.windowLast - t.windowStart
+	if span < windowMillis {
+		return
+	}
+	t.stats.VideoBitrate = t.videoBytes * 8 * 1000 / uint64(span)
+	t.stats.AudioBitrate = t.audioBytes * 8 * 1000 / uint64(span)
+	t.stats.FPS = math.Round(float64(t.videoFrames)*100000/float64(span)) / 100
+	t.reset(timestamp)
+}
+
+func (t *Tracker) reset(timestamp uint32) {
+	t.windowStart, t.windowLast, t.windowActive = timestamp, timestamp, true
+	t.videoBytes, t.audioBytes, t.videoFrames = 0, 0, 0
+}
+
+// Stats 返回当前的统计信息
+func (t *Tracker) Stats() internal.StreamStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
@@ -0,0 +1,88 @@
+package stats
+
+import (
+	"rtmpproxy/internal/rtmp"
+	"testing"
+)
+
+var (
+	avcSequenceHeader = []byte{0x17, 0, 0, 0, 0}
+	avcKeyframe       = []byte{0x17, 1, 0, 0, 0}
+	avcInterFrame     = []byte{0x27, 1, 0, 0, 0}
+	aacSequenceHeader = []byte{0xaf, 0, 0x12, 0x10}
+	aacRaw            = []byte{0xaf, 1, 0x21}
+)
+
+func video(timestamp uint32, payload []byte) *rtmp.Message {
+	return &rtmp.Message{TypeID: rtmp.TypeVideo, Timestamp: timestamp, Payload: payload}
+}
+
+func audio(timestamp uint32, payload []byte) *rtmp.Message {
+	return &rtmp.Message{TypeID: rtmp.TypeAudio, Timestamp: timestamp, Payload: payload}
+}
+
+func TestTrackerCounts(t *testing.T) {
+	tracker := NewTracker(nil)
+	tracker.Observe(video(0, avcSequenceHeader))
+	tracker.Observe(audio(0, aacSequenceHeader))
+	tracker.Observe(&rtmp.Message{TypeID: rtmp.TypeDataAMF0, Payload: []byte{2}})
+	tracker.Observe(video(0, avcKeyframe))
+	tracker.Observe(audio(10, aacRaw))
+	tracker.Observe(video(40, avcInterFrame))
+	tracker.Observe(video(2000, avcKeyframe))
+
+	stats := tracker.Stats()
+	if stats.VideoCodec != "H264" || stats.AudioCodec != "AAC" {
+		t.Errorf("codec = %s/%s, want H264/AAC", stats.VideoCodec, stats.AudioCodec)
+	}
+	if stats.VideoFrames != 3 || stats.Keyframes != 2 || stats.AudioFrames != 1 {
+		t.Errorf("frames = %d/%d/%d, want 3/2/1", stats.VideoFrames, stats.Keyframes, stats.AudioFrames)
+	}
+	if stats.KeyframeInterval != 2 {
+		t.Errorf("keyframe interval = %v, want 2", stats.KeyframeInterval)
+	}
+	if stats.VideoBitrate != 0 || stats.FPS != 0 {
+		t.Errorf("bitrate/fps computed before the window ends: %d/%v", stats.VideoBitrate, stats.FPS)
+	}
+}
+
+func TestTrackerWindow(t *testing.T) {
+	tracker := NewTracker(nil)
+	// 25fps的视频和每帧100字节的音频，共5秒
+	for i := uint32(0); i < 125; i++ {
+		payload := make([]byte, 1000)
+		copy(payload, avcInterFrame)
+		tracker.Observe(video(i*40, payload))
+		tracker.Observe(audio(i*40, append(aacRaw[:2:2], make([]byte, 98)...)))
+	}
+	tracker.Observe(video(5000, avcKeyframe))
+
+	stats := tracker.Stats()
+	if stats.FPS != 25 {
+		t.Errorf("fps = %v, want 25", stats.FPS)
+	}
+	if stats.VideoBitrate != 125*1000*8/5 {
+		t.Errorf("video bitrate = %d, want %d", stats.VideoBitrate, 125*1000*8/5)
+	}
+	if stats.AudioBitrate != 125*100*8/5 {
+		t.Errorf("audio bitrate = %d, want %d", stats.AudioBitrate, 125*100*8/5)
+	}
+}
+
+func TestTrackerTimestampRegression(t *testing.T) {
+	tracker := NewTracker(nil)
+	tracker.Observe(video(100000, avcKeyframe))
+	// 推流端重启后时间戳从0开始，之前窗口的数据不参与计算
+	tracker.Observe(video(0, avcKeyframe))
+	for i := uint32(1); i <= 50; i++ {
+		tracker.Observe(video(i*100, avcInterFrame))
+	}
+
+	stats := tracker.Stats()
+	if stats.FPS != 10 {
+		t.Errorf("fps = %v, want 10", stats.FPS)
+	}
+	if stats.KeyframeInterval != 0 {
+		t.Errorf("keyframe interval = %v, want 0", stats.KeyframeInterval)
+	}
+}
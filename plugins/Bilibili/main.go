@@ -4,8 +4,10 @@ package Bilibili
 
 import (
 	"github.com/CuteReimu/bilibili/v2"
-	"log"
+	"log/slog"
 	"rtmpproxy/internal"
+	"rtmpproxy/internal/logging"
+	"sync/atomic"
 )
 
 type CustomInterceptor struct {
@@ -15,6 +17,7 @@ type CustomInterceptor struct {
 	AreaV2   int    `json:"area_v2"`
 	Platform string `json:"platform,omitempty"` // 默认 android_link
 	client   *Client
+	live     atomic.Bool
 }
 
 func (c *CustomInterceptor) ApplicationStart() error {
@@ -25,11 +28,12 @@ func (c *CustomInterceptor) ApplicationStart() error {
 		}
 		c.client = cli
 	}
-	log.Println("Success to load Bilibili Client")
+	logging.AddCookieSecret(c.client.GetCookiesString())
+	slog.Info("Success to load Bilibili Client")
 	return nil
 }
 
-func (c *CustomInterceptor) BeforeEstablishTCPConnection() error {
+func (c *CustomInterceptor) BeforeEstablishTCPConnection(s *internal.Session) error {
 	startLiveParam := bilibili.StartLiveParam{
 		RoomId:   c.RoomID,
 		AreaV2:   c.AreaV2,
@@ -39,23 +43,39 @@ func (c *CustomInterceptor) BeforeEstablishTCPConnection() error {
 	if err != nil {
 		return StartLiveFailed
 	}
-	rtmpAddr := startLiveResult.Rtmp.Addr + startLiveResult.Rtmp.Code
-	c.BaseCfg.RemoteAddr = &rtmpAddr
-	log.Printf("Success to start live, RoomID: %d, AreaV2: %d, Platform: %s", c.RoomID, c.AreaV2, c.Platform)
+	s.AddSecret(startLiveResult.Rtmp.Code)
+	s.RemoteAddr = startLiveResult.Rtmp.Addr + startLiveResult.Rtmp.Code
+	c.live.Store(true)
+	s.Logger().Info("Success to start live", "room_id", c.RoomID, "area_v2", c.AreaV2, "platform", c.Platform)
 	return nil
 }
 
-func (c *CustomInterceptor) AfterRTMPHandshake() error {
+func (c *CustomInterceptor) AfterRTMPHandshake(s *internal.Session) error {
 	return nil
 }
 
-func (c *CustomInterceptor) AfterCloseTCPConnection() error {
+func (c *CustomInterceptor) AfterCloseTCPConnection(s *internal.Session) error {
 	_, err := c.client.StopLive(bilibili.StopLiveParam{
 		RoomId: c.RoomID,
 	})
 	if err != nil {
 		return StopLiveFailed
 	}
-	log.Printf("Success to stop bilibili live, RoomID: %d", c.RoomID)
+	c.live.Store(false)
+	s.Logger().Info("Success to stop bilibili live", "room_id", c.RoomID)
 	return nil
 }
+
+func (c *CustomInterceptor) ApplicationStop() error {
+	return nil
+}
+
+// Status 直播间和开播状态
+func (c *CustomInterceptor) Status() interface{} {
+	return map[string]interface{}{
+		"room_id":  c.RoomID,
+		"area_v2":  c.AreaV2,
+		"platform": c.Platform,
+		"live":     c.live.Load(),
+	}
+}
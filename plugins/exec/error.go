@@ -0,0 +1,8 @@
+package exec
+
+import "errors"
+
+var (
+	CommandFailed  = errors.New("exec command failed")
+	CommandTimeout = errors.New("exec command timeout")
+)
@@ -0,0 +1,145 @@
+package exec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	osexec "os/exec"
+	"rtmpproxy/internal"
+	"rtmpproxy/utils"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// CustomInterceptor 在各个事件发生时执行配置的命令，类似nginx-rtmp的exec_publish
+type CustomInterceptor struct {
+	ApplicationStartCmds             []string `json:"application_start"`
+	BeforeEstablishTCPConnectionCmds []string `json:"before_establish_tcp_connection"`
+	AfterRTMPHandshakeCmds           []string `json:"after_rtmp_handshake"`
+	AfterCloseTCPConnectionCmds      []string `json:"after_close_tcp_connection"`
+	ApplicationStopCmds              []string `json:"application_stop"`
+	Timeout                          int      `json:"timeout,omitempty"` // 单条命令超时时间(秒)，默认 10
+	Block                            bool     `json:"block,omitempty"`   // 命令失败时中断当前连接
+	timeout                          time.Duration
+	runs                             atomic.Uint64
+	failures                         atomic.Uint64
+}
+
+func (c *CustomInterceptor) ApplicationStart() error {
+	return c.run("ApplicationStart", c.ApplicationStartCmds, nil)
+}
+
+func (c *CustomInterceptor) BeforeEstablishTCPConnection(s *internal.Session) error {
+	return c.run("BeforeEstablishTCPConnection", c.BeforeEstablishTCPConnectionCmds, s)
+}
+
+func (c *CustomInterceptor) AfterRTMPHandshake(s *internal.Session) error {
+	return c.run("AfterRTMPHandshake", c.AfterRTMPHandshakeCmds, s)
+}
+
+func (c *CustomInterceptor) AfterCloseTCPConnection(s *internal.Session) error {
+	return c.run("AfterCloseTCPConnection", c.AfterCloseTCPConnectionCmds, s)
+}
+
+func (c *CustomInterceptor) ApplicationStop() error {
+	return c.run("ApplicationStop", c.ApplicationStopCmds, nil)
+}
+
+// Status 已执行和失败的命令数
+func (c *CustomInterceptor) Status() interface{} {
+	return map[string]uint64{
+		"runs":     c.runs.Load(),
+		"failures": c.failures.Load(),
+	}
+}
+
+// run 依次执行事件对应的命令，Block 为 true 时遇到失败立即返回错误
+func (c *CustomInterceptor) run(event string, cmds []string, s *internal.Session) error {
+	for _, cmdline := range cmds {
+		c.runs.Add(1)
+		err := c.exec(event, cmdline, s)
+		if err == nil {
+			continue
+		}
+		c.failures.Add(1)
+		logger(s).Warn("exec command failed", "event", event, "command", cmdline, "err", err)
+		if c.Block {
+			return fmt.Errorf("%w: %s: %v", CommandFailed, event, err)
+		}
+	}
+	return nil
+}
+
+// exec 通过系统shell执行单条命令，会话信息以环境变量的形式传递，输出写入日志
+func (c *CustomInterceptor) exec(event string, cmdline string, s *internal.Session) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	var cmd *osexec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = osexec.CommandContext(ctx, "cmd", "/C", cmdline)
+	} else {
+		cmd = osexec.CommandContext(ctx, "sh", "-c", cmdline)
+	}
+	cmd.Env = append(os.Environ(), sessionEnv(event, s)...)
+	// 超时只结束shell，shell启动的子进程仍然持有输出管道，不再等待其退出
+	cmd.WaitDelay = time.Second
+	output, err := cmd.CombinedOutput()
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		logger(s).Info("exec output", "event", event, "line", scanner.Text())
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return CommandTimeout
+	}
+	return err
+}
+
+// sessionEnv 会话信息对应的环境变量
+func sessionEnv(event string, s *internal.Session) []string {
+	env := []string{"RTMPPROXY_EVENT=" + event}
+	if s == nil {
+		return env
+	}
+	app, stream := s.AppName, s.StreamName
+	if app == "" && stream == "" {
+		app, stream = remoteParams(s.RemoteAddr)
+	}
+	return append(env,
+		"RTMPPROXY_SESSION_ID="+s.ID,
+		"RTMPPROXY_CLIENT_ADDR="+s.ClientAddr,
+		"RTMPPROXY_CLIENT_APP="+s.ClientApp,
+		"RTMPPROXY_CLIENT_STREAM="+s.ClientName,
+		"RTMPPROXY_REMOTE_ADDR="+s.RemoteAddr,
+		"RTMPPROXY_APP="+app,
+		"RTMPPROXY_STREAM="+stream,
+		fmt.Sprintf("RTMPPROXY_START_TIME=%d", s.StartTime.Unix()),
+	)
+}
+
+// remoteParams 连接远程服务器之前(BeforeEstablishTCPConnection)AppName和StreamName还没有设置，从远程地址中解析，
+// 地址无效时返回空值
+func remoteParams(remoteAddr string) (string, string) {
+	u, _, err := utils.ParseLink(remoteAddr)
+	if err != nil {
+		return "", ""
+	}
+	app, stream, _, err := utils.GetLinkParams(u)
+	if err != nil {
+		return "", ""
+	}
+	return app, stream
+}
+
+// logger 会话事件使用会话的logger
+func logger(s *internal.Session) *slog.Logger {
+	if s == nil {
+		return slog.Default()
+	}
+	return s.Logger()
+}
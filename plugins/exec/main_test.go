@@ -0,0 +1,106 @@
+package exec
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"rtmpproxy/internal"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newInterceptor(block bool) *CustomInterceptor {
+	return &CustomInterceptor{Block: block, timeout: 5 * time.Second}
+}
+
+func TestRunBlock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	s := internal.NewSession("127.0.0.1:5000", "rtmp://127.0.0.1/live/key")
+
+	c := newInterceptor(true)
+	c.BeforeEstablishTCPConnectionCmds = []string{"exit 1", "true"}
+	if err := c.BeforeEstablishTCPConnection(s); !errors.Is(err, CommandFailed) {
+		t.Fatalf("block: err = %v, want CommandFailed", err)
+	}
+	if runs := c.runs.Load(); runs != 1 {
+		t.Errorf("block: runs = %d, want 1", runs)
+	}
+
+	c = newInterceptor(false)
+	c.BeforeEstablishTCPConnectionCmds = []string{"exit 1", "true"}
+	if err := c.BeforeEstablishTCPConnection(s); err != nil {
+		t.Fatalf("non-block: err = %v, want nil", err)
+	}
+	if runs, failures := c.runs.Load(), c.failures.Load(); runs != 2 || failures != 1 {
+		t.Errorf("non-block: runs = %d, failures = %d, want 2, 1", runs, failures)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	c := &CustomInterceptor{Block: true, timeout: 100 * time.Millisecond}
+	c.ApplicationStartCmds = []string{"sleep 5"}
+	if err := c.ApplicationStart(); !errors.Is(err, CommandFailed) || !strings.Contains(err.Error(), CommandTimeout.Error()) {
+		t.Fatalf("err = %v, want timeout", err)
+	}
+}
+
+func TestSessionEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	out := filepath.Join(t.TempDir(), "env")
+	c := newInterceptor(true)
+	c.BeforeEstablishTCPConnectionCmds = []string{`echo "$RTMPPROXY_EVENT $RTMPPROXY_APP $RTMPPROXY_STREAM" > ` + out}
+	c.AfterRTMPHandshakeCmds = c.BeforeEstablishTCPConnectionCmds
+
+	s := internal.NewSession("127.0.0.1:5000", "rtmp://127.0.0.1/live/key?streamname=x")
+	read := func() string {
+		t.Helper()
+		b, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(b))
+	}
+
+	// 连接远程服务器之前从远程地址解析
+	if err := c.BeforeEstablishTCPConnection(s); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := read(), "BeforeEstablishTCPConnection live key?streamname=x"; got != want {
+		t.Errorf("env = %q, want %q", got, want)
+	}
+
+	s.AppName, s.StreamName = "app", "stream"
+	if err := c.AfterRTMPHandshake(s); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := read(), "AfterRTMPHandshake app stream"; got != want {
+		t.Errorf("env = %q, want %q", got, want)
+	}
+}
+
+func TestRemoteParams(t *testing.T) {
+	tests := []struct {
+		addr, app, stream string
+	}{
+		{"rtmp://example.com/live/key", "live", "key"},
+		{"rtmps://example.com:443/app/name", "app", "name"},
+		{"rtmp://example.com/live", "", ""},
+		{"http://example.com/live/key", "", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		app, stream := remoteParams(tt.addr)
+		if app != tt.app || stream != tt.stream {
+			t.Errorf("remoteParams(%q) = %q, %q, want %q, %q", tt.addr, app, stream, tt.app, tt.stream)
+		}
+	}
+}
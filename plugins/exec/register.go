@@ -0,0 +1,30 @@
+package exec
+
+import (
+	"encoding/json"
+	"rtmpproxy/internal"
+	"rtmpproxy/internal/plugins"
+	"time"
+)
+
+type PluginConfig struct {
+	CustomInterceptor CustomInterceptor
+}
+
+func init() {
+	plugins.Register(&PluginConfig{})
+}
+
+func (p *PluginConfig) Name() string { return "exec" }
+
+func (p *PluginConfig) Configure(config []byte, baseCfg *internal.Config) (plugins.Interceptor, error) {
+	if err := json.Unmarshal(config, &p.CustomInterceptor); err != nil {
+		return nil, err
+	}
+	if p.CustomInterceptor.Timeout <= 0 {
+		p.CustomInterceptor.Timeout = 10
+	}
+	p.CustomInterceptor.timeout = time.Duration(p.CustomInterceptor.Timeout) * time.Second
+	customInterceptor := &p.CustomInterceptor
+	return customInterceptor, nil
+}
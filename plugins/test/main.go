@@ -1,7 +1,8 @@
 package test
 
 import (
-	"log"
+	"log/slog"
+	"rtmpproxy/internal"
 )
 
 type CustomInterceptor struct {
@@ -9,21 +10,26 @@ type CustomInterceptor struct {
 }
 
 func (c *CustomInterceptor) ApplicationStart() error {
-	log.Println("ApplicationStart test:", c.Message)
+	slog.Info("ApplicationStart test", "message", c.Message)
 	return nil
 }
 
-func (c *CustomInterceptor) BeforeEstablishTCPConnection() error {
-	log.Println("BeforeEstablishTCPConnection test:", c.Message)
+func (c *CustomInterceptor) BeforeEstablishTCPConnection(s *internal.Session) error {
+	s.Logger().Info("BeforeEstablishTCPConnection test", "message", c.Message)
 	return nil
 }
 
-func (c *CustomInterceptor) AfterRTMPHandshake() error {
-	log.Println("AfterRTMPHandshake test:", c.Message)
+func (c *CustomInterceptor) AfterRTMPHandshake(s *internal.Session) error {
+	s.Logger().Info("AfterRTMPHandshake test", "message", c.Message)
 	return nil
 }
 
-func (c *CustomInterceptor) AfterCloseTCPConnection() error {
-	log.Println("AfterCloseTCPConnection test:", c.Message)
+func (c *CustomInterceptor) AfterCloseTCPConnection(s *internal.Session) error {
+	s.Logger().Info("AfterCloseTCPConnection test", "message", c.Message)
+	return nil
+}
+
+func (c *CustomInterceptor) ApplicationStop() error {
+	slog.Info("ApplicationStop test", "message", c.Message)
 	return nil
 }
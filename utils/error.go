@@ -18,9 +18,36 @@ var (
 	ErrorToExtractParams        = errors.New("error to extract params")
 	FailedToConnectRemoteServer = errors.New("failed to connect to remote server")
 	FailedToEstablishTLS        = errors.New("failed to establish TLS")
+	RemoteAddrRequired          = errors.New("remote address is required")
 )
 
 var (
 	FailedBeforeEstablishConnection = errors.New("failed before establish connection")
 	FailedAfterCloseConnection      = errors.New("failed after connection callback")
 )
+
+// Authorization error
+var (
+	PublishUnauthorized = errors.New("publish unauthorized")
+	InvalidRedirect     = errors.New("invalid redirect location")
+	InvalidStreamKey    = errors.New("invalid stream key")
+	StreamKeyExpired    = errors.New("stream key expired")
+)
+
+// Access control error
+var (
+	AddressDenied   = errors.New("address denied")
+	AddressBanned   = errors.New("address temporarily banned")
+	TooManySessions = errors.New("too many sessions")
+)
+
+// Admin error
+var (
+	SessionNotFound      = errors.New("session not found")
+	SessionNotPublishing = errors.New("session is not publishing")
+	SwitchInProgress     = errors.New("switch is in progress")
+	DelayNotEnabled      = errors.New("delay is not enabled")
+	SlateNotConfigured   = errors.New("slate is not configured")
+	NoActiveClient       = errors.New("no client is publishing")
+	InvalidOutputAction  = errors.New("invalid output action")
+)
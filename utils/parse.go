@@ -1,9 +1,11 @@
 package utils
 
 import (
-	"log"
+	"fmt"
+	"math"
 	"net"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -48,8 +50,7 @@ func GetLinkParams(u *url.URL) (string, string, string, error) {
 	}
 	parts := strings.Split(strings.Trim(u.Path+bilibiliPatch, "/"), "/")
 	if len(parts) < 2 {
-		log.Fatalf("expected at least two path segments, got %d", len(parts))
-		return "", "", "", ErrorToExtractParams
+		return "", "", "", fmt.Errorf("%w: expected at least two path segments, got %d", ErrorToExtractParams, len(parts))
 	}
 
 	appName := parts[0]
@@ -57,3 +58,53 @@ func GetLinkParams(u *url.URL) (string, string, string, error) {
 	playUrl := u.Host
 	return appName, streamName, playUrl, nil
 }
+
+// SplitList 拆分逗号分隔的参数，忽略空白项
+func SplitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// ParseBitrate 解析码率(bit/s)，支持k/m/g后缀，例如 "6000k"、"12M"
+func ParseBitrate(s string) (uint64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	multiplier := uint64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		multiplier, s = 1000, strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "m"):
+		multiplier, s = 1000000, strings.TrimSuffix(s, "m")
+	case strings.HasSuffix(s, "g"):
+		multiplier, s = 1000000000, strings.TrimSuffix(s, "g")
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid bitrate %q", s)
+	}
+	return uint64(v * float64(multiplier)), nil
+}
+
+// RedactLink 隐藏推流地址中的streamName(推流密钥)、参数和密码，用于日志和管理接口
+func RedactLink(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return "***"
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) > 1 {
+		parts = append(parts[:1], "***")
+	}
+	redacted := u.Scheme + "://" + u.Host + "/" + strings.Join(parts, "/")
+	if u.RawQuery != "" {
+		redacted += "?***"
+	}
+	return redacted
+}
@@ -0,0 +1,33 @@
+package utils
+
+import "testing"
+
+func TestParseBitrate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint64
+	}{
+		{"0", 0},
+		{"500000", 500000},
+		{"6000k", 6000000},
+		{"6000K", 6000000},
+		{"12M", 12000000},
+		{"1.5m", 1500000},
+		{"1g", 1000000000},
+		{" 8M ", 8000000},
+	}
+	for _, tt := range tests {
+		got, err := ParseBitrate(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseBitrate(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseBitrateInvalid(t *testing.T) {
+	for _, in := range []string{"", "k", "abc", "6000kb", "6Mbps", "-1M", "NaN", "inf", "1e400"} {
+		if got, err := ParseBitrate(in); err == nil {
+			t.Errorf("ParseBitrate(%q) = %d, want error", in, got)
+		}
+	}
+}